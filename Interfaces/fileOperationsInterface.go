@@ -4,13 +4,20 @@ import (
 	"github.com/ninjamarcus/ninjaStorage/models"
 )
 
-type FileOperations interface {
+// Storage is the backend-neutral file surface every storage backend provides. Paths are relative
+// to the backend's configured parent folder, and List keys are relative to it too.
+type Storage interface {
 	Write(data []byte, filePath string, metaData *models.FileMetaData) (*models.FileMetaData, error)
+	Read(filePath string) ([]byte, *models.FileMetaData, error)
+	Stat(filePath string) (*models.FileMetaData, error)
+	Exists(filePath string) (bool, error)
 	Delete(filePath string) error
 	Move(filePathFrom string, filePathTo string) error
 	Copy(filePathFrom string, filePathTo string) error
-	//Something to do with searching the metadata
-	Find()
 	List(prefix string) (map[string]*models.FileMetaData, error)
-	Read(filePath string) ([]byte, *models.FileMetaData, error)
 }
+
+// FileOperations is the old name of Storage.
+//
+// Deprecated: use Storage.
+type FileOperations = Storage
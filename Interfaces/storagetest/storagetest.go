@@ -0,0 +1,161 @@
+// Package storagetest is a conformance suite for ninjaStorage.Storage implementations, so every
+// backend is held to the same behaviour.
+package storagetest
+
+import (
+	"errors"
+	"testing"
+
+	ninjaStorage "github.com/ninjamarcus/ninjaStorage/Interfaces"
+	"github.com/ninjamarcus/ninjaStorage/models"
+)
+
+// Run runs every scenario as a subtest, each against a fresh, empty Storage returned by open.
+func Run(t *testing.T, open func(t *testing.T) ninjaStorage.Storage) {
+	for _, sc := range scenarios {
+		sc := sc
+		t.Run(sc.name, func(t *testing.T) {
+			sc.run(t, open(t))
+		})
+	}
+}
+
+var scenarios = []struct {
+	name string
+	run  func(t *testing.T, s ninjaStorage.Storage)
+}{
+	{"write then read", func(t *testing.T, s ninjaStorage.Storage) {
+		mdata, err := s.Write([]byte("hello"), "a/b.txt", &models.FileMetaData{UserMetaData: map[string]string{"k": "v"}})
+		if err != nil {
+			t.Fatalf("Write: %v", err)
+		}
+		if mdata.Size != 5 || mdata.Md5Hash != "5d41402abc4b2a76b9719d911017c592" {
+			t.Errorf("Write metadata: %+v", mdata)
+		}
+		data, mdata, err := s.Read("a/b.txt")
+		if err != nil || string(data) != "hello" || mdata.UserMetaData["k"] != "v" {
+			t.Errorf("Read: %q %+v %v", data, mdata, err)
+		}
+	}},
+	{"overwrite replaces content and metadata", func(t *testing.T, s ninjaStorage.Storage) {
+		write(t, s, "f.txt", "first", map[string]string{"old": "1"})
+		write(t, s, "f.txt", "second", map[string]string{"new": "2"})
+		data, mdata, err := s.Read("f.txt")
+		if err != nil || string(data) != "second" {
+			t.Fatalf("Read: %q %v", data, err)
+		}
+		if _, ok := mdata.UserMetaData["old"]; ok || mdata.UserMetaData["new"] != "2" {
+			t.Errorf("metadata after overwrite: %v", mdata.UserMetaData)
+		}
+	}},
+	{"missing files", func(t *testing.T, s ninjaStorage.Storage) {
+		if _, err := s.Stat("missing.txt"); !errors.Is(err, models.ErrNotFound) {
+			t.Errorf("Stat: %v", err)
+		}
+		if _, _, err := s.Read("missing.txt"); !errors.Is(err, models.ErrNotFound) {
+			t.Errorf("Read: %v", err)
+		}
+		if ok, err := s.Exists("missing.txt"); ok || err != nil {
+			t.Errorf("Exists: %v, %v", ok, err)
+		}
+		if err := s.Delete("missing.txt"); !errors.Is(err, models.ErrNotFound) {
+			t.Errorf("Delete: %v", err)
+		}
+	}},
+	{"invalid paths", func(t *testing.T, s ninjaStorage.Storage) {
+		for _, p := range []string{"", "../escaped.txt", "a/../../escaped.txt", "/etc/passwd", "./"} {
+			if _, err := s.Write([]byte("x"), p, nil); !errors.Is(err, models.ErrInvalidPath) {
+				t.Errorf("Write(%q): %v", p, err)
+			}
+			if _, _, err := s.Read(p); !errors.Is(err, models.ErrInvalidPath) {
+				t.Errorf("Read(%q): %v", p, err)
+			}
+			if err := s.Delete(p); !errors.Is(err, models.ErrInvalidPath) {
+				t.Errorf("Delete(%q): %v", p, err)
+			}
+		}
+		if _, err := s.List("../"); !errors.Is(err, models.ErrInvalidPath) {
+			t.Errorf("List(../): %v", err)
+		}
+	}},
+	{"exists", func(t *testing.T, s ninjaStorage.Storage) {
+		write(t, s, "here.txt", "x", nil)
+		if ok, err := s.Exists("here.txt"); !ok || err != nil {
+			t.Errorf("Exists: %v, %v", ok, err)
+		}
+	}},
+	{"copy keeps the source and its metadata", func(t *testing.T, s ninjaStorage.Storage) {
+		write(t, s, "src.txt", "x", map[string]string{"k": "v"})
+		if err := s.Copy("src.txt", "dst/copy.txt"); err != nil {
+			t.Fatalf("Copy: %v", err)
+		}
+		for _, name := range []string{"src.txt", "dst/copy.txt"} {
+			data, mdata, err := s.Read(name)
+			if err != nil || string(data) != "x" || mdata.UserMetaData["k"] != "v" {
+				t.Errorf("Read %s: %q %+v %v", name, data, mdata, err)
+			}
+		}
+	}},
+	{"copy refuses to overwrite", func(t *testing.T, s ninjaStorage.Storage) {
+		write(t, s, "src.txt", "x", nil)
+		write(t, s, "dst.txt", "y", nil)
+		if err := s.Copy("src.txt", "dst.txt"); !errors.Is(err, models.ErrAlreadyExists) {
+			t.Errorf("Copy onto an existing file: %v", err)
+		}
+		if err := s.Copy("src.txt", "src.txt"); err == nil {
+			t.Errorf("Copy onto itself should fail")
+		}
+	}},
+	{"move", func(t *testing.T, s ninjaStorage.Storage) {
+		write(t, s, "src.txt", "x", map[string]string{"k": "v"})
+		if err := s.Move("src.txt", "moved.txt"); err != nil {
+			t.Fatalf("Move: %v", err)
+		}
+		if ok, _ := s.Exists("src.txt"); ok {
+			t.Errorf("the source should be gone after Move")
+		}
+		if data, mdata, err := s.Read("moved.txt"); err != nil || string(data) != "x" || mdata.UserMetaData["k"] != "v" {
+			t.Errorf("Read moved.txt: %q %+v %v", data, mdata, err)
+		}
+	}},
+	{"delete", func(t *testing.T, s ninjaStorage.Storage) {
+		write(t, s, "gone.txt", "x", nil)
+		if err := s.Delete("gone.txt"); err != nil {
+			t.Fatalf("Delete: %v", err)
+		}
+		if _, err := s.Stat("gone.txt"); !errors.Is(err, models.ErrNotFound) {
+			t.Errorf("Stat after Delete: %v", err)
+		}
+	}},
+	{"list", func(t *testing.T, s ninjaStorage.Storage) {
+		for _, name := range []string{"logs/a.txt", "logs/b/c.txt", "logsarchive/d.txt", "other.txt"} {
+			write(t, s, name, "x", nil)
+		}
+		for prefix, want := range map[string][]string{
+			"":      {"logs/a.txt", "logs/b/c.txt", "logsarchive/d.txt", "other.txt"},
+			"logs/": {"logs/a.txt", "logs/b/c.txt"},
+			"logs":  {"logs/a.txt", "logs/b/c.txt", "logsarchive/d.txt"},
+			"none/": nil,
+		} {
+			list, err := s.List(prefix)
+			if err != nil {
+				t.Fatalf("List(%q): %v", prefix, err)
+			}
+			if len(list) != len(want) {
+				t.Errorf("List(%q) = %d entries, want %v", prefix, len(list), want)
+			}
+			for _, name := range want {
+				if mdata, ok := list[name]; !ok || mdata.Size != 1 {
+					t.Errorf("List(%q) is missing %s: %v", prefix, name, list)
+				}
+			}
+		}
+	}},
+}
+
+func write(t *testing.T, s ninjaStorage.Storage, name, data string, meta map[string]string) {
+	t.Helper()
+	if _, err := s.Write([]byte(data), name, &models.FileMetaData{UserMetaData: meta}); err != nil {
+		t.Fatalf("Write %s: %v", name, err)
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"crypto/md5"
 	"fmt"
 	"github.com/ninjamarcus/ninjaStorage"
+	"github.com/ninjamarcus/ninjaStorage/gcpFS"
 	"github.com/ninjamarcus/ninjaStorage/models"
 	"path"
 )
@@ -19,19 +20,21 @@ func main() {
 	if err != nil {
 		panic(fmt.Sprintf("failed to connect to bucket: %v", err))
 	}
+	defer store.Close()
+	gcp := &gcpFS.GCPController{}
 	b := []byte("hello world")
 	filePath := "testdir/test.data"
 	//Write
 	metaData := &models.FileMetaData{UserMetaData: map[string]string{"Test": "metadata"}}
 
-	mdata, err := store.Write(b, filePath, metaData)
+	mdata, err := gcp.Write(store, b, filePath, metaData)
 	if err != nil {
 		panic(fmt.Sprintf("cannot write data to bucket: %v", err))
 	}
 	fmt.Printf("Written date: %s \n", mdata.TimeCreated)
 
 	//Read
-	data, mdata, err := store.Read(filePath)
+	data, mdata, err := gcp.Read(store, filePath)
 	fmt.Printf("read filename From Metadata: %s \n", mdata.Name)
 	if err != nil {
 		panic(fmt.Sprintf("cannot read data from bucket: %v", err))
@@ -42,12 +45,12 @@ func main() {
 
 	//Copy
 	copyFilePath := path.Join("newdir", filePath)
-	if err = store.Copy(filePath, copyFilePath); err != nil {
+	if err = gcp.Copy(store, filePath, copyFilePath); err != nil {
 		panic(fmt.Sprintf("cannot copy data in bucket: %v", err))
 	}
 
 	//List
-	result, err := store.List(filePath)
+	result, err := gcp.List(store, filePath)
 	if err != nil {
 		panic(fmt.Sprintf("cannot retrieve data from bucket: %v", err))
 	}
@@ -56,7 +59,7 @@ func main() {
 		fmt.Printf("\t %v = %v\n", key, value)
 	}
 	//Delete
-	if err = store.Delete(copyFilePath); err != nil {
+	if err = gcp.Delete(store, copyFilePath); err != nil {
 		panic(fmt.Sprintf("cannot delete data from bucket: %v", err))
 	}
 
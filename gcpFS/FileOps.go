@@ -1,46 +1,141 @@
 package gcpFS
 
 import (
+	"bufio"
 	"bytes"
 	"context"
+	"crypto/md5"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
+	"mime/multipart"
+	"net/http"
+	"os"
 	"path"
+	"strconv"
+	"strings"
 	"time"
 
 	"cloud.google.com/go/storage"
 	"github.com/ninjamarcus/ninjaStorage/models"
 	"google.golang.org/api/iterator"
+	"google.golang.org/api/option"
 )
 
 type GCPFS struct {
 	//storage is the gcp storage client
 	client *storage.Client
 	config *models.GCPFSConfig
+	// ctx is the fallback context of every operation, see WithContext.
 	ctx    context.Context
+	stats  *opStats
+	exists *existsCache
+	// requestID labels log lines, see WithRequestID.
+	requestID string
+	// sharedClient is set when the caller supplied client and remains responsible for closing it.
+	sharedClient bool
+	// limiter paces mutating operations to MaxOpsPerSecond; nil when unlimited.
+	limiter *rateLimiter
+	// encryptionKey is the customer-supplied AES-256 key object handles are set up with, see
+	// WithEncryptionKey.
+	encryptionKey []byte
 }
 
 type GCPControls interface {
 	NewGCPStorage(fs *models.GCPFSConfig) (*GCPFS, error)
+	NewGCPStorageWithClient(fs *models.GCPFSConfig, client *storage.Client) (*GCPFS, error)
+	Close(g *GCPFS) error
 	Delete(g *GCPFS, filePath string) error
+	SetHold(g *GCPFS, filePath string, hold bool) error
+	SetEventBasedHold(g *GCPFS, filePath string, hold bool) error
+	DeletePrefix(g *GCPFS, prefix string) (int, error)
+	WriteBatch(g *GCPFS, items []WriteItem, concurrency int) ([]*models.FileMetaData, error)
+	StatBatch(g *GCPFS, paths []string, concurrency int) (map[string]*models.FileMetaData, map[string]error)
 	Move(g *GCPFS, filePathFrom string, filePathTo string) error
+	MoveWithOptions(g *GCPFS, filePathFrom string, filePathTo string, opts *CopyOptions) error
 	Copy(g *GCPFS, filePathFrom string, filePathTo string) error
-	Find()
+	CopyWithOptions(g *GCPFS, filePathFrom string, filePathTo string, opts *CopyOptions) error
+	CopyTo(g *GCPFS, srcPath string, destBucket, destPath string, overwrite bool) error
+	MoveTo(g *GCPFS, srcPath string, destBucket, destPath string, overwrite bool) error
+	MoveMany(g *GCPFS, moves map[string]string, overwrite bool) (map[string]error, error)
+	MoveManyWithOptions(g *GCPFS, moves map[string]string, opts *MoveManyOptions) (map[string]error, error)
+	MoveWithMetadata(g *GCPFS, filePathFrom, filePathTo string, metaData *models.FileMetaData, merge bool) (*models.FileMetaData, error)
+	SoftDelete(g *GCPFS, filePath string) error
+	Restore(g *GCPFS, trashedPath string, destPath string) error
+	Find(g *GCPFS, pattern string) (map[string]*models.FileMetaData, error)
 	Write(g *GCPFS, data []byte, filePath string, metaData *models.FileMetaData) (*models.FileMetaData, error)
+	WriteWithOptions(g *GCPFS, data []byte, filePath string, metaData *models.FileMetaData, opts *WriteOptions) (*models.FileMetaData, error)
+	Create(g *GCPFS, data []byte, filePath string, metaData *models.FileMetaData) (*models.FileMetaData, error)
+	WriteIfNotExists(g *GCPFS, data []byte, filePath string, metaData *models.FileMetaData) (*models.FileMetaData, error)
+	Update(g *GCPFS, data []byte, filePath string, metaData *models.FileMetaData) (*models.FileMetaData, error)
+	WriteIfGeneration(g *GCPFS, data []byte, filePath string, generation int64, metaData *models.FileMetaData) (*models.FileMetaData, error)
+	WriteWithFence(g *GCPFS, data []byte, filePath string, expectedGeneration int64) (int64, error)
+	Stat(g *GCPFS, filePath string) (*models.FileMetaData, error)
+	UpdateMetadata(g *GCPFS, filePath string, patch map[string]string, remove []string) (*models.FileMetaData, error)
+	SetCustomTime(g *GCPFS, filePath string, customTime time.Time) (*models.FileMetaData, error)
+	Exists(g *GCPFS, filePath string) (bool, error)
 	List(g *GCPFS, prefix string) (map[string]*models.FileMetaData, error)
+	ListPage(g *GCPFS, prefix string, pageToken string, pageSize int) (map[string]*models.FileMetaData, string, error)
+	ListDir(g *GCPFS, prefix string) (map[string]*models.FileMetaData, []string, error)
+	ListByMetadata(g *GCPFS, prefix string, match map[string]string) (map[string]*models.FileMetaData, error)
+	ListWithOptions(g *GCPFS, prefix string, opts *ListOptions) (map[string]*models.FileMetaData, error)
+	ListParallel(g *GCPFS, prefix string, concurrency int) (map[string]*models.FileMetaData, error)
 	Read(g *GCPFS, filePath string) ([]byte, *models.FileMetaData, error)
+	ReadCtx(ctx context.Context, g *GCPFS, filePath string) ([]byte, *models.FileMetaData, error)
+	WriteCtx(ctx context.Context, g *GCPFS, data []byte, filePath string, metaData *models.FileMetaData) (*models.FileMetaData, error)
+	StatCtx(ctx context.Context, g *GCPFS, filePath string) (*models.FileMetaData, error)
+	ListCtx(ctx context.Context, g *GCPFS, prefix string) (map[string]*models.FileMetaData, error)
+	DeleteCtx(ctx context.Context, g *GCPFS, filePath string) error
+	ReadStream(g *GCPFS, filePath string) (io.ReadCloser, *models.FileMetaData, error)
+	PublicURL(g *GCPFS, filePath string) string
+	WritePublic(g *GCPFS, data []byte, filePath string, metaData *models.FileMetaData) (string, *models.FileMetaData, error)
+	MakePublic(g *GCPFS, filePath string) error
+	StreamTo(g *GCPFS, filePath string, req *http.Request) (*http.Response, error)
+	ReadText(g *GCPFS, filePath string) (string, *models.FileMetaData, error)
+	ReadWithOptions(g *GCPFS, filePath string, opts *ReadOptions) ([]byte, *models.FileMetaData, error)
+	WriteWithChunkChecksums(g *GCPFS, data []byte, filePath string, metaData *models.FileMetaData, chunkSize int64) (*models.FileMetaData, error)
+	Tail(g *GCPFS, filePath string, n int64) ([]byte, *models.FileMetaData, error)
+	ReadRange(g *GCPFS, filePath string, offset, length int64) ([]byte, *models.FileMetaData, error)
+	ReadParallel(g *GCPFS, filePath string, partSize int64, concurrency int) ([]byte, *models.FileMetaData, error)
+	ReadGeneration(g *GCPFS, filePath string, generation int64) ([]byte, *models.FileMetaData, error)
+	ReadRangeVerified(g *GCPFS, filePath string, offset, length int64) ([]byte, *models.FileMetaData, error)
+	VerifyIntegrity(g *GCPFS, filePath string) (*models.FileMetaData, error)
+	StartScrubber(g *GCPFS, prefix string, interval time.Duration, report func(filePath string, err error)) (stop func(), err error)
+	OpenLineReader(g *GCPFS, filePath string) (*bufio.Reader, io.Closer, error)
+	Stats(g *GCPFS) OpStats
+	TransformPrefix(g *GCPFS, srcPrefix, dstPrefix string, transform func(name string, data []byte) ([]byte, error)) (int, error)
+	FixContentTypes(g *GCPFS, prefix string, opts *FixContentTypesOptions) (int, error)
+	GetIAMPolicy(g *GCPFS) (*models.Policy, error)
+	TestPermissions(g *GCPFS, perms []string) ([]string, error)
+	ListBuckets(g *GCPFS) ([]models.BucketInfo, error)
+	Ping(g *GCPFS) error
+	StorageClassBreakdown(g *GCPFS, prefix string) (map[string]int64, error)
+	SignedURL(g *GCPFS, filePath string, expiry time.Duration) (string, error)
+	SignedUploadURL(g *GCPFS, filePath string, expiry time.Duration, contentType string) (string, error)
+	GenerateUploadPolicy(g *GCPFS, filePath string, maxSize int64, expiry time.Duration, allowedContentType string) (*models.PostPolicy, error)
+	WriteStream(g *GCPFS, r io.Reader, filePath string, metaData *models.FileMetaData) (*models.FileMetaData, error)
+	WriteStreamWithOptions(g *GCPFS, r io.Reader, filePath string, metaData *models.FileMetaData, opts *WriteOptions) (*models.FileMetaData, error)
+	WriteStreamWithAutoMeta(g *GCPFS, r io.Reader, filePath string, metaData *models.FileMetaData) (*models.FileMetaData, error)
+	UploadMultipart(g *GCPFS, r *multipart.Reader, prefix string) (map[string]*models.FileMetaData, error)
+	NewRollingWriter(g *GCPFS, prefix string, maxBytes int64, maxAge time.Duration) (io.WriteCloser, error)
+	WriteStreamWithBudget(g *GCPFS, r io.Reader, filePath string, metaData *models.FileMetaData, maxBytes int64) (*models.FileMetaData, error)
+	Compose(g *GCPFS, dst string, srcs []string, opts *ComposeOptions) (*models.FileMetaData, error)
+	Append(g *GCPFS, filePath string, srcs []string, opts *ComposeOptions) (*models.FileMetaData, error)
+	AppendData(g *GCPFS, data []byte, filePath string) (*models.FileMetaData, error)
 }
 
 type GCPController struct{}
 
 // NewGCPStorage TO Connect successfully you need to have exported your service account.json file
-// as the environment variable GOOGLE_APPLICATION_CREDENTIALS
+// as the environment variable GOOGLE_APPLICATION_CREDENTIALS, or to pass it through
+// CredentialsJSON or CredentialsFile in the config. With STORAGE_EMULATOR_HOST set the
+// client talks to that emulator (e.g. fake-gcs-server) instead, without credentials.
 func (gcp *GCPController) NewGCPStorage(fs *models.GCPFSConfig) (*GCPFS, error) {
 	if err := fs.Validate(); err != nil {
 		return &GCPFS{}, err
 	}
-	gcpfs := &GCPFS{config: fs}
+	gcpfs := &GCPFS{config: fs, stats: &opStats{}, exists: &existsCache{}, limiter: newRateLimiter(fs.MaxOpsPerSecond)}
 	if err := gcpfs.connectToGCPStorage(); err != nil {
 		return &GCPFS{}, err
 	}
@@ -48,183 +143,943 @@ func (gcp *GCPController) NewGCPStorage(fs *models.GCPFSConfig) (*GCPFS, error)
 	return gcpfs, nil
 }
 
-// Connect to the client
+// NewGCPStorageWithClient is NewGCPStorage using client instead of connecting itself, e.g. one
+// pointed at an emulator or built with custom options. The caller keeps ownership of client: Close
+// leaves it open.
+func (gcp *GCPController) NewGCPStorageWithClient(fs *models.GCPFSConfig, client *storage.Client) (*GCPFS, error) {
+	if client == nil {
+		return &GCPFS{}, fmt.Errorf("client cannot be nil")
+	}
+	if err := fs.Validate(); err != nil {
+		return &GCPFS{}, err
+	}
+	gcpfs := &GCPFS{client: client, config: fs, ctx: context.Background(), stats: &opStats{}, exists: &existsCache{}, limiter: newRateLimiter(fs.MaxOpsPerSecond), sharedClient: true}
+	if err := gcpfs.ensureBucket(); err != nil {
+		return &GCPFS{}, err
+	}
+	return gcpfs, nil
+}
+
+// Connect to the client, with the credentials from the config or else application default
+// credentials. Emulators take no credentials, so with STORAGE_EMULATOR_HOST set they are not passed.
 func (g *GCPFS) connectToGCPStorage() error {
 	ctx := context.Background()
-	client, err := storage.NewClient(ctx)
+	var opts []option.ClientOption
+	if os.Getenv("STORAGE_EMULATOR_HOST") == "" {
+		switch {
+		case len(g.config.CredentialsJSON) > 0:
+			opts = append(opts, option.WithCredentialsJSON(g.config.CredentialsJSON))
+		case g.config.CredentialsFile != "":
+			opts = append(opts, option.WithCredentialsFile(g.config.CredentialsFile))
+		}
+	}
+	client, err := storage.NewClient(ctx, opts...)
 	if err != nil {
 		return err
 	}
 	g.client = client
 	g.ctx = ctx
-	defer client.Close()
+	if err := g.ensureBucket(); err != nil {
+		client.Close()
+		return err
+	}
 	return nil
 }
 
-func (gcp *GCPController) Delete(g *GCPFS, filePath string) error {
-	ctx, cancel := context.WithTimeout(g.ctx, time.Second*10)
+// ensureBucket creates the configured bucket in ProjectID if AutoCreateBucket is on and it does
+// not exist yet. A bucket created concurrently by someone else counts as existing.
+func (g *GCPFS) ensureBucket() error {
+	if !g.config.AutoCreateBucket {
+		return nil
+	}
+	ctx, cancel := g.opContext(g.config.MetadataTimeout)
 	defer cancel()
-	fullPath := path.Join(g.config.ParentFolder, filePath)
-	o := g.client.Bucket(g.config.BucketName).Object(fullPath)
+	bucket := g.rawBucket(g.config.BucketName)
+	_, err := bucket.Attrs(ctx)
+	if err == nil {
+		return nil
+	}
+	if err != storage.ErrBucketNotExist {
+		return fmt.Errorf("cannot check bucket:%s reason: %w", g.config.BucketName, wrapGCSError(ctx, err))
+	}
+	err = bucket.Create(ctx, g.config.ProjectID, &storage.BucketAttrs{Location: g.config.BucketLocation})
+	if err != nil && !errors.Is(wrapGCSError(ctx, err), models.ErrAlreadyExists) {
+		return fmt.Errorf("cannot create bucket:%s in project:%s reason: %w", g.config.BucketName, g.config.ProjectID, wrapGCSError(ctx, err))
+	}
+	return nil
+}
 
-	attrs, err := o.Attrs(ctx)
+// Close releases the storage client. The GCPFS, and any copy made with With, cannot be used afterwards.
+// A client passed to NewGCPStorageWithClient is left for the caller to close.
+func (g *GCPFS) Close() error {
+	if g.client == nil || g.sharedClient {
+		return nil
+	}
+	return g.client.Close()
+}
+
+// Close closes g, see GCPFS.Close.
+func (gcp *GCPController) Close(g *GCPFS) error {
+	return g.Close()
+}
 
-	o = o.If(storage.Conditions{GenerationMatch: attrs.Generation})
+func (gcp *GCPController) Delete(g *GCPFS, filePath string) (err error) {
+	defer g.startOp()()
+	fullPath, err := g.objectPath(filePath)
 	if err != nil {
-		return fmt.Errorf("object.Attrs: %v", err)
+		return err
 	}
-	if err := o.Delete(ctx); err != nil {
-		return fmt.Errorf("cannot delete object:%s reason: %v", o.ObjectName(), err)
+	done := g.traceOp("delete", fullPath)
+	defer func() { done(0, err) }()
+	if err := g.throttle(); err != nil {
+		return err
 	}
-	return nil
+	ctx, cancel := g.opContext(g.config.MetadataTimeout)
+	defer cancel()
+	defer g.exists.forget(fullPath)
+	o := g.bucket(g.config.BucketName).Object(fullPath)
 
+	var attrs *storage.ObjectAttrs
+	err = g.retry(ctx, func() (err error) {
+		attrs, err = o.Attrs(ctx)
+		return err
+	})
+
+	if err == storage.ErrObjectNotExist {
+		return fmt.Errorf("cannot delete object:%s reason: %w", fullPath, models.ErrNotFound)
+	}
+	if err != nil {
+		return fmt.Errorf("object.Attrs: %w", wrapGCSError(ctx, err))
+	}
+	if attrs.TemporaryHold || attrs.EventBasedHold {
+		return fmt.Errorf("cannot delete object:%s reason: %w", fullPath, models.ErrObjectHeld)
+	}
+	return g.deleteGeneration(ctx, fullPath, attrs.Generation)
 }
 
-func (gcp *GCPController) Move(g *GCPFS, filePathFrom string, filePathTo string) error {
-	if err := gcp.Copy(g, filePathFrom, filePathTo); err != nil {
-		return fmt.Errorf("could not move/copy file from:%s to:%s reason: %v", filePathFrom, filePathTo, err)
+// deleteGeneration deletes the full object name fullPath in the configured bucket if it is still at
+// generation.
+func (g *GCPFS) deleteGeneration(ctx context.Context, fullPath string, generation int64) error {
+	o := g.bucket(g.config.BucketName).Object(fullPath).If(storage.Conditions{GenerationMatch: generation})
+	attempt := 0
+	err := g.retry(ctx, func() error {
+		attempt++
+		err := o.Delete(ctx)
+		if err == storage.ErrObjectNotExist && attempt > 1 {
+			// An earlier attempt deleted it but its response was lost.
+			return nil
+		}
+		return err
+	})
+	if isHeld(err) {
+		return fmt.Errorf("cannot delete object:%s reason: %v: %w", fullPath, err, models.ErrObjectHeld)
 	}
-	if err := gcp.Delete(g, filePathFrom); err != nil {
-		return fmt.Errorf("could not move/delete file:%s reason: %v", filePathFrom, err)
+	if err != nil {
+		return fmt.Errorf("cannot delete object:%s reason: %w", fullPath, wrapGCSError(ctx, err))
+	}
+	if g.config.CleanupFolderPlaceholders {
+		return g.cleanupFolderPlaceholders(ctx, fullPath)
 	}
 	return nil
 }
 
+func (gcp *GCPController) Move(g *GCPFS, filePathFrom string, filePathTo string) error {
+	return gcp.MoveWithOptions(g, filePathFrom, filePathTo, nil)
+}
+
+// MoveWithOptions is Move with the copy made according to opts, e.g. Overwrite to replace an object
+// already at filePathTo; opts may be nil. Moves are a copy followed by a delete of the source; if the
+// delete fails the copy is removed again, so the object is never left at both paths. With Overwrite
+// the copy is kept instead, as the object it replaced cannot be brought back, and the error says so.
+func (gcp *GCPController) MoveWithOptions(g *GCPFS, filePathFrom string, filePathTo string, opts *CopyOptions) error {
+	defer g.startOp()()
+	if filePathFrom == filePathTo {
+		return fmt.Errorf("the filePathFrom: %s, cannot be the same as filePathTo: %s", filePathFrom, filePathTo)
+	}
+	from, to, err := g.objectPaths(filePathFrom, filePathTo)
+	if err != nil {
+		return err
+	}
+	_, err = gcp.moveObject(g, from, g.config.BucketName, to, opts)
+	return err
+}
+
+// MoveWithMetadata moves filePathFrom to filePathTo like Move, setting the destination's user
+// metadata as part of the server-side copy instead of in a separate update. With merge the keys in
+// metaData are added to (and override) the source's; otherwise they replace them. A ContentType in
+// metaData replaces the source's too; the other attributes, storage class and KMS key included, are
+// carried across. As with MoveWithOptions a held source is refused, the source is only deleted if
+// it is still the generation that was copied, and the copy is removed again when the delete fails.
+// An object already at filePathTo fails the move with ErrAlreadyExists. Returns the destination's
+// metadata.
+func (gcp *GCPController) MoveWithMetadata(g *GCPFS, filePathFrom, filePathTo string, metaData *models.FileMetaData, merge bool) (*models.FileMetaData, error) {
+	defer g.startOp()()
+	return gcp.moveWithMetadata(g, filePathFrom, filePathTo, metaData, merge, nil)
+}
+
+// moveWithMetadata is MoveWithMetadata that also drops the user metadata keys in remove. The move
+// itself is moveObject's, pinned to the generation the metadata was worked out from.
+func (gcp *GCPController) moveWithMetadata(g *GCPFS, filePathFrom, filePathTo string, metaData *models.FileMetaData, merge bool, remove []string) (*models.FileMetaData, error) {
+	if filePathFrom == filePathTo {
+		return nil, fmt.Errorf("the filePathFrom: %s, cannot be the same as filePathTo: %s", filePathFrom, filePathTo)
+	}
+	from, to, err := g.objectPaths(filePathFrom, filePathTo)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := g.opContext(g.config.MetadataTimeout)
+	defer cancel()
+	var srcAttrs *storage.ObjectAttrs
+	err = g.retry(ctx, func() (err error) {
+		srcAttrs, err = g.bucket(g.config.BucketName).Object(from).Attrs(ctx)
+		return err
+	})
+	if err == storage.ErrObjectNotExist {
+		return nil, fmt.Errorf("object(%s) cannot be found: %w", from, models.ErrNotFound)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("object.Attrs: %w", wrapGCSError(ctx, err))
+	}
+
+	meta := map[string]string{}
+	if merge {
+		for k, v := range srcAttrs.Metadata {
+			meta[k] = v
+		}
+	}
+	opts := &CopyOptions{SourceGenerationMatch: srcAttrs.Generation, UserMetaData: meta}
+	if metaData != nil {
+		for k, v := range metaData.UserMetaData {
+			meta[k] = v
+		}
+		opts.ContentType = metaData.ContentType
+	}
+	for _, k := range remove {
+		delete(meta, k)
+	}
+	attrs, err := gcp.moveObject(g, from, g.config.BucketName, to, opts)
+	if err != nil {
+		return nil, err
+	}
+	return g.parseMetaData(attrs), nil
+}
+
 func (gcp *GCPController) Copy(g *GCPFS, filePathFrom string, filePathTo string) error {
+	defer g.startOp()()
+	return gcp.copy(g, filePathFrom, filePathTo, nil)
+}
+
+// CopyOptions tunes CopyWithOptions and MoveWithOptions.
+type CopyOptions struct {
+	// SourceGenerationMatch, when non-zero, only copies the source if it is still at this
+	// generation, e.g. the FileMetaData.Generation the caller last saw. Otherwise the copy fails
+	// with ErrPreconditionFailed and nothing is written.
+	SourceGenerationMatch int64
+	// Overwrite replaces an object already at the destination instead of failing with
+	// ErrAlreadyExists.
+	Overwrite bool
+	// ContentType, StorageClass and CacheControl, when set, replace the source's on the destination,
+	// e.g. StorageClass "STANDARD" to promote an archived object as it is copied back. Empty keeps
+	// the source's.
+	ContentType  string
+	StorageClass string
+	CacheControl string
+	// UserMetaData, when not nil, replaces the source's user metadata on the destination; it is
+	// checked against MetadataSchema.
+	UserMetaData map[string]string
+}
 
+// rewritesAttrs reports whether o changes any of the destination's attributes.
+func (o *CopyOptions) rewritesAttrs() bool {
+	return o.ContentType != "" || o.StorageClass != "" || o.CacheControl != "" || o.UserMetaData != nil
+}
+
+// CopyWithOptions is Copy with per-call options; opts may be nil. As with Copy the destination
+// must not exist yet, which is reported as ErrAlreadyExists, unless Overwrite is set.
+func (gcp *GCPController) CopyWithOptions(g *GCPFS, filePathFrom string, filePathTo string, opts *CopyOptions) error {
+	defer g.startOp()()
+	return gcp.copy(g, filePathFrom, filePathTo, opts)
+}
+
+func (gcp *GCPController) copy(g *GCPFS, filePathFrom string, filePathTo string, opts *CopyOptions) error {
 	if filePathFrom == filePathTo {
 		return fmt.Errorf("the filePathFrom: %s, cannot be the same as filePathTo: %s", filePathFrom, filePathTo)
 	}
-	ctx, cancel := context.WithTimeout(g.ctx, time.Second*10)
+	from, to, err := g.objectPaths(filePathFrom, filePathTo)
+	if err != nil {
+		return err
+	}
+	_, err = gcp.copyObject(g, from, g.config.BucketName, to, opts)
+	return err
+}
+
+// copyObject copies the full object name from in the configured bucket to the full object name to
+// in dstBucket, returning the destination's attributes.
+func (gcp *GCPController) copyObject(g *GCPFS, from, dstBucket, to string, opts *CopyOptions) (attrs *storage.ObjectAttrs, err error) {
+	done := g.traceOp("copy", from, "to", dstBucket+"/"+to)
+	defer func() { done(0, err) }()
+	if opts == nil {
+		opts = &CopyOptions{}
+	}
+	if opts.SourceGenerationMatch < 0 {
+		return nil, fmt.Errorf("SourceGenerationMatch cannot be negative: %d", opts.SourceGenerationMatch)
+	}
+	if opts.StorageClass != "" {
+		if err := models.ValidateStorageClass(opts.StorageClass); err != nil {
+			return nil, err
+		}
+	}
+	if opts.UserMetaData != nil {
+		if err := g.config.MetadataSchema.Validate(opts.UserMetaData); err != nil {
+			return nil, err
+		}
+	}
+	if err := g.throttle(); err != nil {
+		return nil, err
+	}
+	ctx, cancel := g.opContext(g.config.WriteTimeout)
 	defer cancel()
-	from := path.Join(g.config.ParentFolder, filePathFrom)
-	to := path.Join(g.config.ParentFolder, filePathTo)
+	if dstBucket == g.config.BucketName {
+		defer g.exists.forget(to)
+	}
 
-	src := g.client.Bucket(g.config.BucketName).Object(from)
-	dst := g.client.Bucket(g.config.BucketName).Object(to)
+	src := g.keyed(g.bucket(g.config.BucketName).Object(from))
+	dst := g.keyed(g.bucket(dstBucket).Object(to))
 
-	dst = dst.If(storage.Conditions{DoesNotExist: true})
-	if _, err := dst.CopierFrom(src).Run(ctx); err != nil {
-		return fmt.Errorf("Object(%q).CopierFrom(%q).Run: %v", src.ObjectName(), dst.ObjectName(), err)
+	if opts.SourceGenerationMatch != 0 {
+		src = src.If(storage.Conditions{GenerationMatch: opts.SourceGenerationMatch})
 	}
-	return nil
+	if !opts.Overwrite {
+		dst = dst.If(storage.Conditions{DoesNotExist: true})
+	}
+	var (
+		dstAttrs       storage.ObjectAttrs
+		destKMSKeyName string
+	)
+	if opts.rewritesAttrs() {
+		// The destination takes only the attributes sent with the copy, so start from the source's
+		// and copy exactly the generation they were read from.
+		var srcAttrs *storage.ObjectAttrs
+		err := g.retry(ctx, func() (err error) {
+			srcAttrs, err = src.Attrs(ctx)
+			return err
+		})
+		if isPreconditionFailed(err) {
+			return nil, fmt.Errorf("object(%s) is no longer at generation %d: %w", from, opts.SourceGenerationMatch, models.ErrPreconditionFailed)
+		}
+		if err == storage.ErrObjectNotExist {
+			return nil, fmt.Errorf("cannot copy object:%s reason: %w", from, models.ErrNotFound)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("object.Attrs: %w", wrapGCSError(ctx, err))
+		}
+		src = src.Generation(srcAttrs.Generation)
+		dstAttrs = storage.ObjectAttrs{
+			ContentType:        srcAttrs.ContentType,
+			ContentEncoding:    srcAttrs.ContentEncoding,
+			ContentLanguage:    srcAttrs.ContentLanguage,
+			ContentDisposition: srcAttrs.ContentDisposition,
+			CacheControl:       srcAttrs.CacheControl,
+			CustomTime:         srcAttrs.CustomTime,
+			StorageClass:       srcAttrs.StorageClass,
+			Metadata:           srcAttrs.Metadata,
+		}
+		if opts.ContentType != "" {
+			dstAttrs.ContentType = opts.ContentType
+		}
+		if opts.StorageClass != "" {
+			dstAttrs.StorageClass = strings.ToUpper(opts.StorageClass)
+		}
+		if opts.CacheControl != "" {
+			dstAttrs.CacheControl = opts.CacheControl
+		}
+		if opts.UserMetaData != nil {
+			dstAttrs.Metadata = opts.UserMetaData
+		}
+		destKMSKeyName = cryptoKeyName(srcAttrs.KMSKeyName)
+	}
+	// Copies are idempotent: the destination either must not exist yet or is replaced wholesale.
+	err = g.retry(ctx, func() (err error) {
+		copier := dst.CopierFrom(src)
+		copier.ObjectAttrs = dstAttrs
+		copier.DestinationKMSKeyName = destKMSKeyName
+		attrs, err = copier.Run(ctx)
+		return err
+	})
+	if isPreconditionFailed(err) && opts.SourceGenerationMatch != 0 {
+		// Both conditions answer 412, look at the source to tell them apart.
+		if attrs, aerr := g.rawBucket(g.config.BucketName).Object(from).Attrs(ctx); aerr != nil || attrs.Generation != opts.SourceGenerationMatch {
+			return nil, fmt.Errorf("object(%s) is no longer at generation %d: %w", from, opts.SourceGenerationMatch, models.ErrPreconditionFailed)
+		}
+	}
+	if isPreconditionFailed(err) && !opts.Overwrite {
+		return nil, fmt.Errorf("cannot copy to object:%s reason: %w", to, models.ErrAlreadyExists)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Object(%q).CopierFrom(%q).Run: %w", src.ObjectName(), dst.ObjectName(), wrapGCSError(ctx, err))
+	}
+	return attrs, nil
+}
+
+// cryptoKeyName strips the /cryptoKeyVersions/<n> GCS reports in ObjectAttrs.KMSKeyName, giving the
+// key name requests take.
+func cryptoKeyName(kmsKeyName string) string {
+	if i := strings.Index(kmsKeyName, "/cryptoKeyVersions/"); i >= 0 {
+		return kmsKeyName[:i]
+	}
+	return kmsKeyName
+}
+
+// CopyTo copies srcPath, relative to ParentFolder as usual, to the object destPath in destBucket,
+// which may be any bucket the credentials can write to. destPath is the full object name there:
+// ParentFolder is not applied to it. Unless overwrite is set an object already at the destination
+// fails the copy with ErrAlreadyExists. User metadata and content headers are copied along.
+func (gcp *GCPController) CopyTo(g *GCPFS, srcPath string, destBucket, destPath string, overwrite bool) error {
+	defer g.startOp()()
+	return gcp.copyTo(g, srcPath, destBucket, destPath, overwrite)
+}
+
+func (gcp *GCPController) copyTo(g *GCPFS, srcPath string, destBucket, destPath string, overwrite bool) error {
+	from, err := g.crossBucketSource(srcPath, destBucket, destPath)
+	if err != nil {
+		return err
+	}
+	_, err = gcp.copyObject(g, from, destBucket, destPath, &CopyOptions{Overwrite: overwrite})
+	return err
+}
+
+// crossBucketSource checks the arguments of CopyTo or MoveTo and returns the full object name of
+// srcPath.
+func (g *GCPFS) crossBucketSource(srcPath string, destBucket, destPath string) (string, error) {
+	if err := models.ValidateBucketName(destBucket); err != nil {
+		return "", err
+	}
+	if destPath == "" {
+		return "", fmt.Errorf("destPath cannot be empty")
+	}
+	from, err := g.objectPath(srcPath)
+	if err != nil {
+		return "", err
+	}
+	if destBucket == g.config.BucketName && destPath == from {
+		return "", fmt.Errorf("the source %s cannot be the same as the destination", from)
+	}
+	return from, nil
 }
 
-func (gcp *GCPController) Find() {
-	//TODO implement me
-	panic("implement me")
+// MoveTo moves srcPath to destPath in destBucket like CopyTo, then deletes the source. As with Move,
+// a failure to delete the source removes the copy again.
+func (gcp *GCPController) MoveTo(g *GCPFS, srcPath string, destBucket, destPath string, overwrite bool) error {
+	defer g.startOp()()
+	from, err := g.crossBucketSource(srcPath, destBucket, destPath)
+	if err != nil {
+		return err
+	}
+	_, err = gcp.moveObject(g, from, destBucket, destPath, &CopyOptions{Overwrite: overwrite})
+	return err
 }
 
+// Write uploads data to filePath, overwriting any object already there.
+// Use Create or Update when the caller knows which of the two it expects.
 func (gcp *GCPController) Write(g *GCPFS, data []byte, filePath string, metaData *models.FileMetaData) (*models.FileMetaData, error) {
+	defer g.startOp()()
+	return gcp.write(g, data, filePath, metaData, nil, nil)
+}
+
+// Create uploads data to filePath only if no object exists there yet (generation-match 0).
+// Returns models.ErrAlreadyExists when the object is already present.
+func (gcp *GCPController) Create(g *GCPFS, data []byte, filePath string, metaData *models.FileMetaData) (*models.FileMetaData, error) {
+	defer g.startOp()()
+	mdata, err := gcp.write(g, data, filePath, metaData, &storage.Conditions{DoesNotExist: true}, nil)
+	if isPreconditionFailed(err) {
+		return nil, fmt.Errorf("cannot create object:%s reason: %w", filePath, models.ErrAlreadyExists)
+	}
+	return mdata, err
+}
+
+// WriteIfNotExists is Create: a Write that refuses to overwrite, failing with models.ErrAlreadyExists
+// and leaving the existing object untouched when one is already at filePath.
+func (gcp *GCPController) WriteIfNotExists(g *GCPFS, data []byte, filePath string, metaData *models.FileMetaData) (*models.FileMetaData, error) {
+	return gcp.Create(g, data, filePath, metaData)
+}
+
+// Update replaces the object at filePath, which must already exist. The upload is guarded on the
+// generation observed beforehand, so an object deleted or replaced in the meantime is not clobbered.
+// Returns models.ErrNotFound when there is nothing to update.
+func (gcp *GCPController) Update(g *GCPFS, data []byte, filePath string, metaData *models.FileMetaData) (*models.FileMetaData, error) {
+	defer g.startOp()()
+	ctx, cancel := g.opContext(g.config.MetadataTimeout)
+	defer cancel()
+	fullPath, err := g.objectPath(filePath)
+	if err != nil {
+		return nil, err
+	}
+	attrs, err := g.rawBucket(g.config.BucketName).Object(fullPath).Attrs(ctx)
+	if err == storage.ErrObjectNotExist {
+		return nil, fmt.Errorf("cannot update object:%s reason: %w", filePath, models.ErrNotFound)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("object.Attrs: %w", wrapGCSError(ctx, err))
+	}
+	mdata, err := gcp.write(g, data, filePath, metaData, &storage.Conditions{GenerationMatch: attrs.Generation}, nil)
+	if isPreconditionFailed(err) {
+		return nil, fmt.Errorf("object:%s changed during update: %w", filePath, models.ErrPreconditionFailed)
+	}
+	return mdata, err
+}
+
+// WriteIfGeneration writes data only if the object at filePath is still at generation, e.g. the
+// FileMetaData.Generation returned by the Read or Stat it was derived from, with 0 meaning the object
+// must not exist yet. An object changed or deleted in the meantime is left alone and the error wraps
+// models.ErrPreconditionFailed, so read-modify-write loops can start over from the read.
+func (gcp *GCPController) WriteIfGeneration(g *GCPFS, data []byte, filePath string, generation int64, metaData *models.FileMetaData) (*models.FileMetaData, error) {
+	defer g.startOp()()
+	if generation < 0 {
+		return nil, fmt.Errorf("generation cannot be negative: %d", generation)
+	}
+	mdata, err := gcp.write(g, data, filePath, metaData, generationConditions(generation), nil)
+	if isPreconditionFailed(err) {
+		return nil, fmt.Errorf("object:%s is not at generation %d: %w", filePath, generation, models.ErrPreconditionFailed)
+	}
+	return mdata, err
+}
+
+// generationConditions guards a write on the object being at generation, 0 meaning absent.
+func generationConditions(generation int64) *storage.Conditions {
+	if generation == 0 {
+		return &storage.Conditions{DoesNotExist: true}
+	}
+	return &storage.Conditions{GenerationMatch: generation}
+}
+
+// WriteWithFence writes data only if the object is still at expectedGeneration (0 meaning it must
+// not exist yet) and returns the new generation. A stale writer gets models.ErrFenced.
+//
+// Producers typically loop: Stat the object (treating models.ErrNotFound as generation 0), build
+// the new content, then call WriteWithFence with FileMetaData.Generation, starting over from Stat
+// on models.ErrFenced.
+func (gcp *GCPController) WriteWithFence(g *GCPFS, data []byte, filePath string, expectedGeneration int64) (int64, error) {
+	defer g.startOp()()
+	if expectedGeneration < 0 {
+		return 0, fmt.Errorf("expectedGeneration cannot be negative: %d", expectedGeneration)
+	}
+	mdata, err := gcp.write(g, data, filePath, nil, generationConditions(expectedGeneration), nil)
+	if isPreconditionFailed(err) {
+		return 0, fmt.Errorf("object:%s is not at generation %d: %w", filePath, expectedGeneration, models.ErrFenced)
+	}
+	if err != nil {
+		return 0, err
+	}
+	return mdata.Generation, nil
+}
+
+// Stat returns the metadata of the object at filePath without downloading its content.
+// FileMetaData.Bucket tells which bucket answered when a mirror is configured.
+func (gcp *GCPController) Stat(g *GCPFS, filePath string) (*models.FileMetaData, error) {
+	defer g.startOp()()
+	return gcp.stat(g, filePath)
+}
+
+func (gcp *GCPController) stat(g *GCPFS, filePath string) (_ *models.FileMetaData, err error) {
+	paths, err := g.readPaths(filePath)
+	if err != nil {
+		return nil, err
+	}
+	done := g.traceOp("stat", paths[0])
+	defer func() { done(0, err) }()
+	ctx, cancel := g.opContext(g.config.MetadataTimeout)
+	defer cancel()
+	var (
+		fullPath string
+		attrs    *storage.ObjectAttrs
+	)
+	for _, fullPath = range paths {
+		if attrs, err = g.objectAttrs(ctx, fullPath); err != storage.ErrObjectNotExist {
+			break
+		}
+	}
+	if err == storage.ErrObjectNotExist {
+		return nil, fmt.Errorf("object(%s) cannot be found: %w", fullPath, models.ErrNotFound)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("object.Attrs: %w", wrapGCSError(ctx, err))
+	}
+	return g.parseMetaData(attrs), nil
+}
+
+// write uploads data, applying conds to the object handle when set and reporting to progress if
+// not nil.
+func (gcp *GCPController) write(g *GCPFS, data []byte, filePath string, metaData *models.FileMetaData, conds *storage.Conditions, progress ProgressFunc) (_ *models.FileMetaData, err error) {
+	fullPath, err := g.objectPath(filePath)
+	if err != nil {
+		return nil, err
+	}
+	done := g.traceOp("write", fullPath)
+	defer func() {
+		if err != nil {
+			done(0, err)
+		} else {
+			done(int64(len(data)), nil)
+		}
+	}()
 
 	if len(data) == 0 {
 		return nil, fmt.Errorf("length of data is 0 nothing to write")
 	}
-	if filePath == "" {
-		return nil, fmt.Errorf("Filepath cannot be empty")
-	}
 
-	buf := bytes.NewBuffer(data)
-	ctx, cancel := context.WithTimeout(g.ctx, time.Second*50)
+	if err := g.throttle(); err != nil {
+		return nil, err
+	}
+	buf := withProgress(bytes.NewReader(data), progress, int64(len(data)))
+	ctx, cancel := g.opContext(g.config.WriteTimeout)
 	defer cancel()
 
-	fullPath := path.Join(g.config.ParentFolder, filePath)
-	o := g.client.Bucket(g.config.BucketName).Object(fullPath)
+	defer g.exists.forget(fullPath)
+	o := g.rawBucket(g.config.BucketName).Object(fullPath)
 
-	wc := o.NewWriter(ctx)
-	wc.ChunkSize = 0
+	wo := o
+	if conds != nil {
+		wo = o.If(*conds)
+	}
+	wc, err := g.newObjectWriter(ctx, wo, filePath, metaData, data)
+	if err != nil {
+		return nil, err
+	}
 	if _, err := io.Copy(wc, buf); err != nil {
+		if isChecksumMismatch(err) {
+			return nil, fmt.Errorf("object(%s) does not match the supplied checksum: %w", fullPath, models.ErrChecksumMismatch)
+		}
 		return nil, fmt.Errorf("io.Copy error: %v", err)
 	}
 	if err := wc.Close(); err != nil {
-		return nil, fmt.Errorf("Writer.Close error: %v", err)
+		if isChecksumMismatch(err) {
+			return nil, fmt.Errorf("object(%s) does not match the supplied checksum: %w", fullPath, models.ErrChecksumMismatch)
+		}
+		return nil, fmt.Errorf("Writer.Close error: %w", wrapGCSError(ctx, err))
 	}
+	buf.done()
 	if err := gcp.writeMetadata(g, o, metaData); err != nil {
-		return nil, fmt.Errorf("error writing metadata: %v", err)
+		return nil, fmt.Errorf("error writing metadata: %w", err)
 	}
 	attrs, err := o.Attrs(ctx)
 	if err != nil {
-		return nil, fmt.Errorf("could not retrieve object attributes: %v", err)
+		return nil, fmt.Errorf("could not retrieve object attributes: %w", wrapGCSError(ctx, err))
 	}
 
 	return g.parseMetaData(attrs), nil
 }
 
+// newObjectWriter validates an upload to filePath and opens a writer on o configured from metaData.
+// head is the start of the content, used to sniff the content type when none is given.
+func (g *GCPFS) newObjectWriter(ctx context.Context, o *storage.ObjectHandle, filePath string, metaData *models.FileMetaData, head []byte) (*storage.Writer, error) {
+	contentType, contentEncoding, predefinedACL, cacheControl := "", "", "", ""
+	contentDisposition := g.config.DefaultContentDisposition
+	kmsKeyName := g.config.KMSKeyName
+	storageClass := g.config.DefaultStorageClass
+	var userMetaData map[string]string
+	var customTime time.Time
+	if metaData != nil {
+		contentType = metaData.ContentType
+		contentEncoding = metaData.ContentEncoding
+		predefinedACL = metaData.PredefinedACL
+		cacheControl = metaData.CacheControl
+		if metaData.ContentDisposition != "" {
+			contentDisposition = metaData.ContentDisposition
+		}
+		if metaData.KMSKeyName != "" {
+			kmsKeyName = metaData.KMSKeyName
+		}
+		if metaData.StorageClass != "" {
+			storageClass = metaData.StorageClass
+		}
+		userMetaData = metaData.UserMetaData
+		customTime = metaData.CustomTime
+	}
+	if err := g.config.MetadataSchema.Validate(g.withDefaultMetadata(userMetaData)); err != nil {
+		return nil, err
+	}
+	if err := models.ValidateContentDisposition(contentDisposition); err != nil {
+		return nil, err
+	}
+	if err := models.ValidateKMSKeyName(kmsKeyName); err != nil {
+		return nil, err
+	}
+	if g.encryptionKey != nil {
+		if metaData != nil && metaData.KMSKeyName != "" {
+			return nil, fmt.Errorf("KMSKeyName cannot be combined with a customer-supplied encryption key")
+		}
+		// The object is encrypted with the supplied key instead of the configured KMS key.
+		kmsKeyName = ""
+		o = g.keyed(o)
+	}
+	if err := models.ValidateStorageClass(storageClass); err != nil {
+		return nil, err
+	}
+	if err := models.ValidatePredefinedACL(predefinedACL); err != nil {
+		return nil, err
+	}
+	var md5Sum []byte
+	var crc uint32
+	var sendCRC bool
+	if metaData != nil && metaData.Md5Hash != "" {
+		sum, err := hex.DecodeString(metaData.Md5Hash)
+		if err != nil || len(sum) != md5.Size {
+			return nil, fmt.Errorf("Md5Hash %q is not a hex MD5", metaData.Md5Hash)
+		}
+		md5Sum = sum
+	}
+	if metaData != nil && metaData.Crc32c != "" {
+		sum, err := strconv.ParseUint(metaData.Crc32c, 16, 32)
+		if err != nil || len(metaData.Crc32c) != 8 {
+			return nil, fmt.Errorf("Crc32c %q is not a hex CRC32C", metaData.Crc32c)
+		}
+		crc, sendCRC = uint32(sum), true
+	}
+	if err := g.checkCaseCollision(ctx, filePath); err != nil {
+		return nil, err
+	}
+	if contentType == "" {
+		contentType = detectContentType(filePath, head)
+	}
+	if err := g.checkContentType(filePath, contentType); err != nil {
+		return nil, err
+	}
+	o, chunkSize := g.uploadRetries(o)
+	wc := o.NewWriter(ctx)
+	wc.ChunkSize = chunkSize
+	wc.ContentType = contentType
+	wc.ContentEncoding = contentEncoding
+	wc.PredefinedACL = predefinedACL
+	wc.ContentDisposition = contentDisposition
+	wc.CacheControl = cacheControl
+	wc.CustomTime = customTime
+	wc.KMSKeyName = kmsKeyName
+	wc.StorageClass = strings.ToUpper(storageClass)
+	wc.MD5 = md5Sum
+	wc.CRC32C, wc.SendCRC32C = crc, sendCRC
+	return wc, nil
+}
+
 func (gcp *GCPController) writeMetadata(g *GCPFS, handle *storage.ObjectHandle, metaData *models.FileMetaData) error {
 
-	if len(metaData.UserMetaData) == 0 {
+	var userMetaData map[string]string
+	if metaData != nil {
+		userMetaData = metaData.UserMetaData
+	}
+	userMetaData = g.withProvenance(g.withDefaultMetadata(userMetaData))
+	if len(userMetaData) == 0 {
 		return nil
 	}
-	ctx, cancel := context.WithTimeout(g.ctx, time.Second*10)
+	ctx, cancel := g.opContext(g.config.MetadataTimeout)
 	defer cancel()
 	attrs, err := handle.Attrs(ctx)
 	if err != nil {
-		return fmt.Errorf("object.Attrs error: %v", err)
+		return fmt.Errorf("object.Attrs error: %w", wrapGCSError(ctx, err))
 	}
 	handle = handle.If(storage.Conditions{MetagenerationMatch: attrs.Metageneration})
 	objectAttrsToUpdate := storage.ObjectAttrsToUpdate{
-		Metadata: metaData.UserMetaData,
+		Metadata: userMetaData,
 	}
 	if _, err = handle.Update(ctx, objectAttrsToUpdate); err != nil {
-		return fmt.Errorf("ObjectHandle(%q) update failed: %v", handle.ObjectName(), err)
+		return fmt.Errorf("ObjectHandle(%q) update failed: %w", handle.ObjectName(), wrapGCSError(ctx, err))
 	}
 	return nil
 }
 
-// List TODO, we might have to disable the with metadata bit for speed but I will remain optimistic.
-func (gcp *GCPController) List(g *GCPFS, prefix string) (map[string]*models.FileMetaData, error) {
-	results := make(map[string]*models.FileMetaData)
-	ctx, cancel := context.WithTimeout(g.ctx, time.Second*10)
+// List returns every object under prefix, keyed by its path relative to ParentFolder, so keys can
+// be passed straight back to Read and the other methods. FileMetaData.Name holds the full name.
+// TODO, we might have to disable the with metadata bit for speed but I will remain optimistic.
+func (gcp *GCPController) List(g *GCPFS, prefix string) (results map[string]*models.FileMetaData, err error) {
+	defer g.startOp()()
+	fullPath, err := g.listPrefix(prefix)
+	if err != nil {
+		return nil, err
+	}
+	done := g.traceOp("list", fullPath)
+	defer func() { done(0, err, "objects", len(results)) }()
+	ctx, cancel := g.opContext(g.config.ListTimeout)
 	defer cancel()
-	fullPath := path.Join(g.config.ParentFolder, prefix)
-	it := g.client.Bucket(g.config.BucketName).Objects(ctx, &storage.Query{Prefix: fullPath})
 
-	for {
-		attrs, err := it.Next()
-		if err == iterator.Done {
-			break
-		}
-		if err != nil {
-			return nil, fmt.Errorf("Bucket(%s).Objects: %v", g.config.BucketName, err)
-		}
-		results[attrs.Name] = g.parseMetaData(attrs)
+	results = make(map[string]*models.FileMetaData)
+	err = g.listQuery(ctx, &storage.Query{Prefix: fullPath}, func(attrs *storage.ObjectAttrs) {
+		results[g.relativeName(attrs.Name)] = g.parseMetaData(attrs)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Bucket(%s).Objects: %w", g.config.BucketName, wrapGCSError(ctx, err))
 	}
 	return results, nil
 }
 
+// ListPage returns one page of at most pageSize objects under prefix, keyed like List, in name
+// order. Pass an empty pageToken for the first page and the returned nextPageToken for each
+// following one; an empty nextPageToken means there are no more pages. GCS caps the page size at
+// 1000. Objects written while paging may or may not show up on later pages.
+func (gcp *GCPController) ListPage(g *GCPFS, prefix string, pageToken string, pageSize int) (map[string]*models.FileMetaData, string, error) {
+	defer g.startOp()()
+	if pageSize <= 0 {
+		return nil, "", fmt.Errorf("pageSize must be positive: %d", pageSize)
+	}
+	fullPath, err := g.listPrefix(prefix)
+	if err != nil {
+		return nil, "", err
+	}
+	ctx, cancel := g.opContext(g.config.ListTimeout)
+	defer cancel()
+
+	var page []*storage.ObjectAttrs
+	var nextPageToken string
+	err = g.retry(ctx, func() error {
+		page = nil
+		it := g.bucket(g.config.BucketName).Objects(ctx, &storage.Query{Prefix: fullPath})
+		var err error
+		nextPageToken, err = iterator.NewPager(it, pageSize, pageToken).NextPage(&page)
+		return err
+	})
+	if err != nil {
+		return nil, "", fmt.Errorf("Bucket(%s).Objects: %w", g.config.BucketName, wrapGCSError(ctx, err))
+	}
+	results := make(map[string]*models.FileMetaData, len(page))
+	for _, attrs := range page {
+		results[g.relativeName(attrs.Name)] = g.parseMetaData(attrs)
+	}
+	return results, nextPageToken, nil
+}
+
+// relativeName strips the configured ParentFolder from a full object name, giving the path callers
+// pass to the other methods.
+func (g *GCPFS) relativeName(objectName string) string {
+	return strings.TrimPrefix(objectName, path.Join(g.config.ParentFolder)+"/")
+}
+
 // Take in the metadata/attributes from the file and convert them into a our metadata object.
 // TODO: do I need to map this to my own struture or  can I just return googles stuff and somewhere return an interface
 // To maintain its generic structure??
 func (g *GCPFS) parseMetaData(attrs *storage.ObjectAttrs) *models.FileMetaData {
 	return &models.FileMetaData{
-		Bucket:       attrs.Bucket,
-		Md5Hash:      hex.EncodeToString(attrs.MD5[:]),
-		UserMetaData: attrs.Metadata,
-		Name:         attrs.Name,
-		Size:         attrs.Size,
-		TimeCreated:  attrs.Created,
-		Updated:      attrs.Updated,
+		Bucket:             attrs.Bucket,
+		Md5Hash:            hex.EncodeToString(attrs.MD5[:]),
+		Crc32c:             fmt.Sprintf("%08x", attrs.CRC32C),
+		ContentType:        attrs.ContentType,
+		ContentEncoding:    attrs.ContentEncoding,
+		ContentDisposition: attrs.ContentDisposition,
+		CacheControl:       attrs.CacheControl,
+		CustomTime:         attrs.CustomTime,
+		UserMetaData:       attrs.Metadata,
+		Name:               attrs.Name,
+		Size:               attrs.Size,
+		TimeCreated:        attrs.Created,
+		Updated:            attrs.Updated,
+		Generation:         attrs.Generation,
+		Metageneration:     attrs.Metageneration,
+		StorageClass:       attrs.StorageClass,
+		KMSKeyName:         attrs.KMSKeyName,
+		TemporaryHold:      attrs.TemporaryHold,
+		EventBasedHold:     attrs.EventBasedHold,
 	}
 }
 
+// Read downloads the object at filePath. With a MirrorBucketName configured, a missing object or a
+// server error on the primary bucket is retried against the mirror; FileMetaData.Bucket reports which
+// bucket served the data. Should fetching the object's attributes fail once the content has been
+// read, the data is still returned, with only the metadata the download itself reported: no user
+// metadata, checksums or storage class.
 func (gcp *GCPController) Read(g *GCPFS, filePath string) ([]byte, *models.FileMetaData, error) {
-	ctx, cancel := context.WithTimeout(g.ctx, time.Second*50)
+	defer g.startOp()()
+	return gcp.read(g, filePath, nil)
+}
+
+// ReadOptions tunes ReadWithOptions.
+type ReadOptions struct {
+	// Compressed returns gzip-transcoded objects (Content-Encoding: gzip) as the stored compressed
+	// bytes instead of letting GCS decompress them, e.g. to relay them without recompressing.
+	// FileMetaData.Compressed reports whether the bytes returned are actually still compressed.
+	Compressed bool
+	// Progress, when set, is called as the download proceeds (about every MiB) and once more with
+	// the final count, out of the object's size as stored.
+	Progress ProgressFunc
+	// EncryptionKey is the 32-byte AES-256 key the object was written with through
+	// WriteOptions.EncryptionKey. Objects encrypted that way cannot be read without it; the other
+	// read methods take it through WithEncryptionKey.
+	EncryptionKey []byte
+}
+
+// ReadWithOptions is Read with per-call options; opts may be nil.
+func (gcp *GCPController) ReadWithOptions(g *GCPFS, filePath string, opts *ReadOptions) ([]byte, *models.FileMetaData, error) {
+	defer g.startOp()()
+	if opts != nil && opts.EncryptionKey != nil {
+		if err := validateEncryptionKey(opts.EncryptionKey); err != nil {
+			return nil, nil, err
+		}
+		g = g.With(WithEncryptionKey(opts.EncryptionKey))
+	}
+	return gcp.read(g, filePath, opts)
+}
+
+func (gcp *GCPController) read(g *GCPFS, filePath string, opts *ReadOptions) (data []byte, _ *models.FileMetaData, err error) {
+	paths, err := g.readPaths(filePath)
+	if err != nil {
+		return nil, nil, err
+	}
+	done := g.traceOp("read", paths[0])
+	defer func() { done(int64(len(data)), err) }()
+	if opts == nil {
+		opts = &ReadOptions{}
+	}
+	ctx, cancel := g.opContext(g.config.ReadTimeout)
 	defer cancel()
-	fullPath := path.Join(g.config.ParentFolder, filePath)
-	objHandle := g.client.Bucket(g.config.BucketName).Object(fullPath)
-	rc, err := objHandle.NewReader(ctx)
+	var (
+		fullPath  string
+		objHandle *storage.ObjectHandle
+		rc        *storage.Reader
+	)
+	for _, fullPath = range paths {
+		if objHandle, rc, err = g.openReader(ctx, fullPath, opts.Compressed); err != storage.ErrObjectNotExist {
+			break
+		}
+	}
 	if err != nil {
-		return nil, nil, fmt.Errorf("object(%s) cannot be read: %v", fullPath, err)
+		return nil, nil, readError(ctx, fullPath, err)
 	}
 	defer rc.Close()
 
-	data, err := io.ReadAll(rc)
+	// Raw compressed bytes are handed back untouched, so skip any read-side decoding too.
+	compressed := rc.Attrs.ContentEncoding == "gzip"
+	counted := withProgress(rc, opts.Progress, rc.Attrs.Size)
+	r := g.bufferReader(counted)
+	if !compressed {
+		if r, err = g.decodeReader(r); err != nil {
+			return nil, nil, fmt.Errorf("object(%s) cannot be decompressed: %v", fullPath, err)
+		}
+	}
+	data, err = io.ReadAll(r)
 	if err != nil {
 		return nil, nil, fmt.Errorf("io.ReadAll failure: %v", err)
 	}
-	attrs, err := objHandle.Attrs(ctx)
-	if err != nil {
+	counted.done()
+	// The full attributes of the generation just read: the reader only carries some of them.
+	var mdata *models.FileMetaData
+	if attrs, err := objHandle.Generation(rc.Attrs.Generation).Attrs(ctx); err == nil {
+		mdata = g.parseMetaData(attrs)
+	} else {
+		mdata = readerMetaData(objHandle, rc.Attrs)
+	}
+	mdata.Compressed = compressed
+	return data, mdata, nil
+}
 
+// readerMetaData is what a reader's own attributes tell about o, for when fetching o's full
+// attributes failed after its content was read. User metadata, checksums, storage class and the
+// creation time are missing.
+func readerMetaData(o *storage.ObjectHandle, attrs storage.ReaderObjectAttrs) *models.FileMetaData {
+	return &models.FileMetaData{
+		Bucket:          o.BucketName(),
+		Name:            o.ObjectName(),
+		ContentType:     attrs.ContentType,
+		ContentEncoding: attrs.ContentEncoding,
+		CacheControl:    attrs.CacheControl,
+		Size:            attrs.Size,
+		Updated:         attrs.LastModified,
+		Generation:      attrs.Generation,
+		Metageneration:  attrs.Metageneration,
 	}
-	return data, g.parseMetaData(attrs), nil
 }
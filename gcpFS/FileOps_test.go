@@ -0,0 +1,3481 @@
+package gcpFS
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"log"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/textproto"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/storage"
+	ninjaStorage "github.com/ninjamarcus/ninjaStorage/Interfaces"
+	"github.com/ninjamarcus/ninjaStorage/Interfaces/storagetest"
+	"github.com/ninjamarcus/ninjaStorage/models"
+)
+
+const testBucket = "test-bucket"
+
+// newTestGCPFS returns a GCPFS backed by a fresh fakeGCS holding an empty testBucket.
+// conf may be nil; BucketName is always overridden and ParentFolder defaults to "tenants/acme".
+func newTestGCPFS(t *testing.T, conf *models.GCPFSConfig) (*GCPController, *GCPFS, *fakeGCS) {
+	t.Helper()
+	f := newFakeGCS(t)
+	f.createBucket(testBucket)
+	if conf == nil {
+		conf = &models.GCPFSConfig{}
+	}
+	conf.BucketName = testBucket
+	if conf.FS == nil {
+		conf.FS = &models.FS{ParentFolder: "tenants/acme"}
+	}
+	g := &GCPFS{client: f.client(nil), config: conf, ctx: context.Background(), stats: &opStats{}, exists: &existsCache{}}
+	return &GCPController{}, g, f
+}
+
+// testSigningKey returns a throwaway PEM encoded RSA key for signing tests.
+func testSigningKey(t *testing.T) []byte {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("rsa.GenerateKey: %v", err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+}
+
+func TestWriteStreamWithAutoMeta(t *testing.T) {
+	gcp, g, f := newTestGCPFS(t, nil)
+	data := bytes.Repeat([]byte("0123456789"), 100000)
+
+	mdata, err := gcp.WriteStreamWithAutoMeta(g, bytes.NewReader(data), "streams/auto.bin", &models.FileMetaData{UserMetaData: map[string]string{"source": "test"}})
+	if err != nil {
+		t.Fatalf("WriteStreamWithAutoMeta: %v", err)
+	}
+	sum := sha256.Sum256(data)
+	want := map[string]string{
+		"source":          "test",
+		AutoMetaSizeKey:   strconv.Itoa(len(data)),
+		AutoMetaSHA256Key: hex.EncodeToString(sum[:]),
+	}
+	for k, v := range want {
+		if mdata.UserMetaData[k] != v {
+			t.Errorf("metadata %q = %q, want %q", k, mdata.UserMetaData[k], v)
+		}
+	}
+	if mdata.Size != int64(len(data)) {
+		t.Errorf("size = %d, want %d", mdata.Size, len(data))
+	}
+	stored := f.object(testBucket, "tenants/acme/streams/auto.bin")
+	if stored == nil || !bytes.Equal(stored.data, data) {
+		t.Fatalf("stored object does not hold the streamed bytes")
+	}
+}
+
+func TestDeleteCleansUpFolderPlaceholders(t *testing.T) {
+	gcp, g, f := newTestGCPFS(t, &models.GCPFSConfig{CleanupFolderPlaceholders: true})
+	for _, name := range []string{"a/", "a/b/", "a/b/one.txt", "a/b/two.txt"} {
+		f.put(testBucket, "tenants/acme/"+name, []byte("x"), nil)
+	}
+
+	if err := gcp.Delete(g, "a/b/one.txt"); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if f.object(testBucket, "tenants/acme/a/b/") == nil {
+		t.Fatalf("placeholder removed while the folder still has content")
+	}
+	if err := gcp.Delete(g, "a/b/two.txt"); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if names := f.objectNames(testBucket); len(names) != 0 {
+		t.Fatalf("expected every placeholder to be removed, left %v", names)
+	}
+}
+
+func TestWriteStreamWithBudget(t *testing.T) {
+	gcp, g, f := newTestGCPFS(t, nil)
+	data := bytes.Repeat([]byte("x"), 4096)
+
+	_, err := gcp.WriteStreamWithBudget(g, bytes.NewReader(data), "uploads/big.bin", nil, 1024)
+	if !errors.Is(err, models.ErrObjectTooLarge) {
+		t.Fatalf("expected ErrObjectTooLarge, got %v", err)
+	}
+	if names := f.objectNames(testBucket); len(names) != 0 {
+		t.Fatalf("expected no object to be committed, found %v", names)
+	}
+
+	mdata, err := gcp.WriteStreamWithBudget(g, bytes.NewReader(data), "uploads/exact.bin", nil, int64(len(data)))
+	if err != nil {
+		t.Fatalf("WriteStreamWithBudget at the budget: %v", err)
+	}
+	if mdata.Size != int64(len(data)) {
+		t.Errorf("size = %d, want %d", mdata.Size, len(data))
+	}
+}
+
+func TestComposeValidatesSources(t *testing.T) {
+	gcp, g, f := newTestGCPFS(t, nil)
+	f.put(testBucket, "tenants/acme/parts/1", []byte("one,"), nil)
+	f.put(testBucket, "tenants/acme/parts/2", []byte("two"), nil)
+
+	_, err := gcp.Compose(g, "whole", []string{"parts/1", "parts/missing", "parts/2", "parts/gone"}, nil)
+	if !errors.Is(err, models.ErrNotFound) {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+	if !strings.Contains(err.Error(), "parts/gone, parts/missing") {
+		t.Errorf("error does not name the missing sources: %v", err)
+	}
+
+	if _, err := gcp.Compose(g, "whole", []string{"parts/1", "parts/1"}, nil); err == nil || !strings.Contains(err.Error(), "duplicate compose sources: parts/1") {
+		t.Fatalf("expected a duplicate source error, got %v", err)
+	}
+	if f.object(testBucket, "tenants/acme/whole") != nil {
+		t.Fatalf("rejected compose created the destination")
+	}
+
+	mdata, err := gcp.Compose(g, "whole", []string{"parts/1", "parts/1", "parts/2"}, &ComposeOptions{AllowDuplicateSources: true})
+	if err != nil {
+		t.Fatalf("Compose: %v", err)
+	}
+	if got := string(f.object(testBucket, "tenants/acme/whole").data); got != "one,one,two" {
+		t.Errorf("composed content = %q", got)
+	}
+	if mdata.Size != int64(len("one,one,two")) {
+		t.Errorf("size = %d", mdata.Size)
+	}
+}
+
+// failingTransport answers the first n requests with status itself, then passes through. With
+// only set, just the requests whose path starts with it count.
+type failingTransport struct {
+	next   http.RoundTripper
+	status int
+	n      int32
+	only   string
+	method string
+}
+
+func (t *failingTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	if strings.HasPrefix(r.URL.Path, t.only) && (t.method == "" || r.Method == t.method) && atomic.AddInt32(&t.n, -1) >= 0 {
+		body := fmt.Sprintf(`{"error":{"code":%d,"message":"injected"}}`, t.status)
+		return &http.Response{
+			StatusCode: t.status,
+			Header:     http.Header{"Content-Type": {"application/json"}},
+			Body:       io.NopCloser(strings.NewReader(body)),
+			Request:    r,
+		}, nil
+	}
+	return t.next.RoundTrip(r)
+}
+
+func TestReadAttrsFailure(t *testing.T) {
+	gcp, g, f := newTestGCPFS(t, nil)
+	f.put(testBucket, "tenants/acme/a.txt", []byte("content"), map[string]string{"k": "v"})
+	// The download goes through the XML API, attributes through the JSON API.
+	g.client = f.client(func(next http.RoundTripper) http.RoundTripper {
+		return &failingTransport{next: next, status: http.StatusForbidden, n: 1, only: "/storage/v1/"}
+	})
+	data, mdata, err := gcp.Read(g, "a.txt")
+	if err != nil || string(data) != "content" {
+		t.Fatalf("Read: %q, %v", data, err)
+	}
+	if mdata.Name != "tenants/acme/a.txt" || mdata.Size != 7 || mdata.Generation == 0 || mdata.UserMetaData != nil {
+		t.Errorf("metadata from the reader: %+v", mdata)
+	}
+	if _, mdata, err = gcp.Read(g, "a.txt"); err != nil || mdata.UserMetaData["k"] != "v" {
+		t.Errorf("Read with attributes available: %+v, %v", mdata, err)
+	}
+}
+
+func TestRetryClassifier(t *testing.T) {
+	var attempts []int
+	conf := &models.GCPFSConfig{MaxRetries: 5, RetryBaseDelay: time.Millisecond}
+	gcp, g, f := newTestGCPFS(t, conf)
+	f.put(testBucket, "tenants/acme/file.txt", []byte("hello"), nil)
+	flaky := &failingTransport{status: http.StatusServiceUnavailable, n: 2}
+	g.client = f.client(func(rt http.RoundTripper) http.RoundTripper {
+		flaky.next = rt
+		return flaky
+	})
+
+	if _, err := gcp.Stat(g, "file.txt"); err != nil {
+		t.Fatalf("Stat should succeed after two 503s with the default classifier: %v", err)
+	}
+
+	conf.ShouldRetry = func(err error, attempt int) bool {
+		attempts = append(attempts, attempt)
+		return attempt < 2
+	}
+	atomic.StoreInt32(&flaky.n, 10)
+	if _, err := gcp.Stat(g, "file.txt"); err == nil {
+		t.Fatalf("Stat should fail once the custom classifier gives up")
+	}
+	if len(attempts) != 2 || attempts[0] != 1 || attempts[1] != 2 {
+		t.Errorf("ShouldRetry saw attempts %v, want [1 2]", attempts)
+	}
+
+	conf.ShouldRetry = nil
+	atomic.StoreInt32(&flaky.n, 1)
+	flaky.status = http.StatusForbidden
+	if _, err := gcp.Stat(g, "file.txt"); err == nil {
+		t.Fatalf("a 403 is not retryable and should fail")
+	}
+}
+
+func TestReadCompressed(t *testing.T) {
+	gcp, g, _ := newTestGCPFS(t, nil)
+	plain := bytes.Repeat([]byte("compress me "), 100)
+	var gz bytes.Buffer
+	zw := gzip.NewWriter(&gz)
+	zw.Write(plain)
+	zw.Close()
+
+	if _, err := gcp.Write(g, gz.Bytes(), "assets/app.js", &models.FileMetaData{ContentType: "text/javascript", ContentEncoding: "gzip"}); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+
+	data, mdata, err := gcp.Read(g, "assets/app.js")
+	if err != nil {
+		t.Fatalf("Read: %v", err)
+	}
+	if !bytes.Equal(data, plain) || mdata.Compressed {
+		t.Errorf("Read should return decompressed bytes, compressed=%v", mdata.Compressed)
+	}
+
+	data, mdata, err = gcp.ReadWithOptions(g, "assets/app.js", &ReadOptions{Compressed: true})
+	if err != nil {
+		t.Fatalf("ReadWithOptions: %v", err)
+	}
+	if !bytes.Equal(data, gz.Bytes()) || !mdata.Compressed || mdata.ContentEncoding != "gzip" {
+		t.Errorf("expected the stored gzip bytes, compressed=%v encoding=%q", mdata.Compressed, mdata.ContentEncoding)
+	}
+}
+
+func TestWriteCompress(t *testing.T) {
+	gcp, g, f := newTestGCPFS(t, nil)
+	var records []map[string]interface{}
+	for i := 0; i < 5000; i++ {
+		records = append(records, map[string]interface{}{"id": i, "status": "ok", "tags": []string{"alpha", "beta"}})
+	}
+	blob, _ := json.Marshal(records)
+
+	mdata, err := gcp.WriteWithOptions(g, blob, "exports/records.json", nil, &WriteOptions{Compress: true})
+	if err != nil {
+		t.Fatalf("WriteWithOptions: %v", err)
+	}
+	if mdata.ContentEncoding != "gzip" || mdata.ContentType != "application/json" || mdata.Size >= int64(len(blob))/4 {
+		t.Errorf("stored %d of %d bytes as %q/%q", mdata.Size, len(blob), mdata.ContentType, mdata.ContentEncoding)
+	}
+	if data, _, err := gcp.Read(g, "exports/records.json"); err != nil || !bytes.Equal(data, blob) {
+		t.Errorf("Read did not return the original bytes: %d bytes, %v", len(data), err)
+	}
+
+	mdata, err = gcp.WriteStreamWithOptions(g, bytes.NewReader(blob), "exports/stream.log", nil, &WriteOptions{Compress: true})
+	if err != nil {
+		t.Fatalf("WriteStreamWithOptions: %v", err)
+	}
+	if mdata.ContentEncoding != "gzip" || mdata.Size >= int64(len(blob))/4 {
+		t.Errorf("stream stored %d of %d bytes as %q", mdata.Size, len(blob), mdata.ContentEncoding)
+	}
+	if data, _, err := gcp.Read(g, "exports/stream.log"); err != nil || !bytes.Equal(data, blob) {
+		t.Errorf("Read of the stream did not return the original bytes: %d bytes, %v", len(data), err)
+	}
+
+	png := append([]byte("\x89PNG\r\n\x1a\n"), make([]byte, 4096)...)
+	if mdata, err := gcp.WriteWithOptions(g, png, "img/logo.png", nil, &WriteOptions{Compress: true}); err != nil || mdata.ContentEncoding != "" || mdata.Size != int64(len(png)) {
+		t.Errorf("a PNG should be stored as is: %+v, %v", mdata, err)
+	}
+	if !bytes.Equal(f.object(testBucket, "tenants/acme/img/logo.png").data, png) {
+		t.Errorf("the PNG was modified")
+	}
+	if _, err := gcp.WriteWithOptions(g, blob, "exports/sum.json", &models.FileMetaData{Md5Hash: "5d41402abc4b2a76b9719d911017c592"}, &WriteOptions{Compress: true}); err == nil {
+		t.Errorf("Compress with an Md5Hash should be rejected")
+	}
+}
+
+func TestMoveWithMetadata(t *testing.T) {
+	gcp, g, f := newTestGCPFS(t, nil)
+	tags := &models.FileMetaData{UserMetaData: map[string]string{"state": "done"}}
+
+	for _, merge := range []bool{true, false} {
+		f.put(testBucket, "tenants/acme/inbox/a.txt", []byte("a"), map[string]string{"owner": "bob", "state": "new"})
+		mdata, err := gcp.MoveWithMetadata(g, "inbox/a.txt", fmt.Sprintf("archive/a-%v.txt", merge), tags, merge)
+		if err != nil {
+			t.Fatalf("MoveWithMetadata(merge=%v): %v", merge, err)
+		}
+		want := map[string]string{"state": "done"}
+		if merge {
+			want["owner"] = "bob"
+		}
+		if len(mdata.UserMetaData) != len(want) {
+			t.Errorf("merge=%v: metadata = %v, want %v", merge, mdata.UserMetaData, want)
+		}
+		for k, v := range want {
+			if mdata.UserMetaData[k] != v {
+				t.Errorf("merge=%v: metadata %q = %q, want %q", merge, k, mdata.UserMetaData[k], v)
+			}
+		}
+		if f.object(testBucket, "tenants/acme/inbox/a.txt") != nil {
+			t.Errorf("merge=%v: source was not deleted", merge)
+		}
+	}
+}
+
+func TestMoveWithMetadataIsOneMove(t *testing.T) {
+	gcp, g, f := newTestGCPFS(t, nil)
+	const key = "projects/p/locations/l/keyRings/r/cryptoKeys/k"
+	src := f.put(testBucket, "tenants/acme/inbox/a.txt", []byte("a"), map[string]string{"owner": "bob"})
+	src.attrs.StorageClass, src.attrs.KmsKeyName = "NEARLINE", key+"/cryptoKeyVersions/1"
+	mdata, err := gcp.MoveWithMetadata(g, "inbox/a.txt", "archive/a.txt", &models.FileMetaData{UserMetaData: map[string]string{"state": "done"}}, true)
+	if err != nil {
+		t.Fatalf("MoveWithMetadata: %v", err)
+	}
+	if mdata.StorageClass != "NEARLINE" || mdata.KMSKeyName != key || mdata.UserMetaData["owner"] != "bob" {
+		t.Errorf("the source's class, key and metadata should be carried across: %+v", mdata)
+	}
+
+	// The source cannot be deleted: the copy must be removed again.
+	g.client = f.client(func(next http.RoundTripper) http.RoundTripper {
+		return &failingTransport{next: next, status: http.StatusForbidden, n: 1, only: "/storage/v1/b/" + testBucket + "/o/tenants/acme/archive/a.txt", method: http.MethodDelete}
+	})
+	if _, err := gcp.MoveWithMetadata(g, "archive/a.txt", "done/a.txt", nil, true); !errors.Is(err, models.ErrPermissionDenied) {
+		t.Errorf("MoveWithMetadata with a failing delete: expected ErrPermissionDenied, got %v", err)
+	}
+	if names := f.objectNames(testBucket); len(names) != 1 || names[0] != "tenants/acme/archive/a.txt" {
+		t.Errorf("objects after the failed move: %v", names)
+	}
+
+	g.client = f.client(func(next http.RoundTripper) http.RoundTripper {
+		return &failingTransport{next: next, status: http.StatusForbidden, n: 1, only: "/storage/v1/", method: http.MethodGet}
+	})
+	if _, err := gcp.MoveWithMetadata(g, "archive/a.txt", "done/a.txt", nil, true); !errors.Is(err, models.ErrPermissionDenied) {
+		t.Errorf("MoveWithMetadata with a failing stat: expected ErrPermissionDenied, got %v", err)
+	}
+
+	g.client = f.client(nil)
+	if err := gcp.SetEventBasedHold(g, "archive/a.txt", true); err != nil {
+		t.Fatalf("SetEventBasedHold: %v", err)
+	}
+	if _, err := gcp.MoveWithMetadata(g, "archive/a.txt", "done/a.txt", nil, true); !errors.Is(err, models.ErrObjectHeld) {
+		t.Errorf("MoveWithMetadata of a held object: expected ErrObjectHeld, got %v", err)
+	}
+	if names := f.objectNames(testBucket); len(names) != 1 {
+		t.Errorf("a held object was copied: %v", names)
+	}
+}
+
+func TestTrailingSlashNormalization(t *testing.T) {
+	gcp, g, f := newTestGCPFS(t, nil)
+	f.put(testBucket, "tenants/acme-old/a/b/stray.txt", []byte("x"), nil)
+
+	if _, err := gcp.Write(g, []byte("one"), "a/b/", nil); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	if f.object(testBucket, "tenants/acme/a/b") == nil {
+		t.Fatalf("a trailing slash on an object path should be dropped")
+	}
+	if data, _, err := gcp.Read(g, "a/b"); err != nil || string(data) != "one" {
+		t.Fatalf(`Read("a/b") = %q, %v`, data, err)
+	}
+	for _, name := range []string{"a/b/c.txt", "a/bc.txt"} {
+		if _, err := gcp.Write(g, []byte("x"), name, nil); err != nil {
+			t.Fatalf("Write: %v", err)
+		}
+	}
+
+	tests := map[string][]string{
+		"a/b/": {"a/b/c.txt"},
+		"a/b":  {"a/b", "a/b/c.txt", "a/bc.txt"},
+		"":     {"a/b", "a/b/c.txt", "a/bc.txt"},
+	}
+	for prefix, want := range tests {
+		got, err := gcp.List(g, prefix)
+		if err != nil {
+			t.Fatalf("List(%q): %v", prefix, err)
+		}
+		if len(got) != len(want) {
+			t.Errorf("List(%q) returned %d objects, want %v", prefix, len(got), want)
+		}
+		for _, name := range want {
+			if got[name] == nil {
+				t.Errorf("List(%q) is missing %s", prefix, name)
+			}
+		}
+	}
+}
+
+func TestStat(t *testing.T) {
+	gcp, g, f := newTestGCPFS(t, nil)
+	data := bytes.Repeat([]byte("stat me "), 1000)
+	f.put(testBucket, "tenants/acme/known.bin", data, map[string]string{"k": "v"})
+
+	mdata, err := gcp.Stat(g, "known.bin")
+	if err != nil {
+		t.Fatalf("Stat: %v", err)
+	}
+	sum := md5.Sum(data)
+	if mdata.Size != int64(len(data)) || mdata.Md5Hash != hex.EncodeToString(sum[:]) || mdata.UserMetaData["k"] != "v" {
+		t.Errorf("Stat: %+v", mdata)
+	}
+	if _, err := gcp.Stat(g, "unknown.bin"); !errors.Is(err, models.ErrNotFound) {
+		t.Errorf("a missing object should be ErrNotFound, got %v", err)
+	}
+	if n := f.mediaBytes; n != 0 {
+		t.Errorf("Stat downloaded %d bytes", n)
+	}
+}
+
+func TestExists(t *testing.T) {
+	gcp, g, f := newTestGCPFS(t, nil)
+	f.put(testBucket, "tenants/acme/here.txt", []byte("x"), nil)
+	f.put(testBucket, "tenants/acme/secret.txt", []byte("x"), nil)
+	f.deny(testBucket, "tenants/acme/secret.txt")
+
+	if ok, err := gcp.Exists(g, "here.txt"); err != nil || !ok {
+		t.Errorf("present object: Exists = %v, %v", ok, err)
+	}
+	if ok, err := gcp.Exists(g, "gone.txt"); err != nil || ok {
+		t.Errorf("absent object: Exists = %v, %v", ok, err)
+	}
+	if ok, err := gcp.Exists(g, "secret.txt"); err == nil || ok {
+		t.Errorf("permission denied must be an error, got Exists = %v, %v", ok, err)
+	}
+	if n := f.mediaBytes; n != 0 {
+		t.Errorf("Exists downloaded %d bytes", n)
+	}
+}
+
+func TestExistsCache(t *testing.T) {
+	gcp, g, f := newTestGCPFS(t, &models.GCPFSConfig{ExistsCacheTTL: time.Minute})
+	check := func(want bool) {
+		t.Helper()
+		got, err := gcp.Exists(g, "dedup/key")
+		if err != nil {
+			t.Fatalf("Exists: %v", err)
+		}
+		if got != want {
+			t.Fatalf("Exists = %v, want %v", got, want)
+		}
+	}
+
+	check(false)
+	f.put(testBucket, "tenants/acme/dedup/key", []byte("x"), nil)
+	check(false) // cached negative, the out-of-band write is not seen yet
+	if n := f.count("GET json"); n != 1 {
+		t.Errorf("expected a single Attrs call while cached, got %d", n)
+	}
+
+	if _, err := gcp.Write(g, []byte("y"), "dedup/key", nil); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	check(true)
+	if err := gcp.Delete(g, "dedup/key"); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	check(false)
+}
+
+// afterTransport calls hook once each request has been answered, before the caller sees the reply.
+type afterTransport struct {
+	next http.RoundTripper
+	hook func(r *http.Request)
+}
+
+func (t *afterTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	resp, err := t.next.RoundTrip(r)
+	t.hook(r)
+	return resp, err
+}
+
+func TestExistsCacheRace(t *testing.T) {
+	gcp, g, f := newTestGCPFS(t, &models.GCPFSConfig{ExistsCacheTTL: time.Minute})
+	f.put(testBucket, "tenants/acme/dedup/key", []byte("x"), nil)
+
+	// The object is deleted through this GCPFS after GCS has answered the lookup, but before Exists
+	// records the answer.
+	var deleted int32
+	g.client = f.client(func(next http.RoundTripper) http.RoundTripper {
+		return &afterTransport{next: next, hook: func(r *http.Request) {
+			if r.Method == http.MethodGet && r.URL.Path == "/storage/v1/b/"+testBucket+"/o/tenants/acme/dedup/key" && atomic.AddInt32(&deleted, 1) == 1 {
+				if err := gcp.Delete(g, "dedup/key"); err != nil {
+					t.Errorf("Delete: %v", err)
+				}
+			}
+		}}
+	})
+	if got, err := gcp.Exists(g, "dedup/key"); err != nil || !got {
+		t.Fatalf("Exists during the delete: %v, %v", got, err)
+	}
+	if got, err := gcp.Exists(g, "dedup/key"); err != nil || got {
+		t.Errorf("Exists after the delete = %v, %v, want the stale answer not to be cached", got, err)
+	}
+}
+
+func TestReadRangeVerified(t *testing.T) {
+	gcp, g, f := newTestGCPFS(t, nil)
+	data := make([]byte, 1000)
+	for i := range data {
+		data[i] = byte(i)
+	}
+	if _, err := gcp.WriteWithChunkChecksums(g, data, "blobs/chunked", nil, 128); err != nil {
+		t.Fatalf("WriteWithChunkChecksums: %v", err)
+	}
+
+	got, mdata, err := gcp.ReadRangeVerified(g, "blobs/chunked", 100, 300)
+	if err != nil {
+		t.Fatalf("ReadRangeVerified: %v", err)
+	}
+	if !bytes.Equal(got, data[100:400]) {
+		t.Errorf("range content does not match")
+	}
+	if mdata.Size != int64(len(data)) {
+		t.Errorf("size = %d, want the full object size %d", mdata.Size, len(data))
+	}
+	if got, _, err = gcp.ReadRangeVerified(g, "blobs/chunked", 900, -1); err != nil || !bytes.Equal(got, data[900:]) {
+		t.Errorf("tail range: %v", err)
+	}
+
+	f.object(testBucket, "tenants/acme/blobs/chunked").data[300] ^= 0xff
+	if _, _, err := gcp.ReadRangeVerified(g, "blobs/chunked", 260, 10); !errors.Is(err, models.ErrChecksumMismatch) {
+		t.Errorf("expected ErrChecksumMismatch for a corrupted chunk, got %v", err)
+	}
+	if _, _, err := gcp.ReadRangeVerified(g, "blobs/chunked", 0, 100); err != nil {
+		t.Errorf("an untouched chunk should still verify: %v", err)
+	}
+
+	f.put(testBucket, "tenants/acme/blobs/plain", data, nil)
+	if _, _, err := gcp.ReadRangeVerified(g, "blobs/plain", 0, -1); err != nil {
+		t.Errorf("a whole read should verify against the object CRC32C: %v", err)
+	}
+	if _, _, err := gcp.ReadRangeVerified(g, "blobs/plain", 10, 10); err == nil {
+		t.Errorf("a partial read without chunk checksums should be refused")
+	}
+}
+
+func TestProvenanceStamping(t *testing.T) {
+	gcp, g, _ := newTestGCPFS(t, &models.GCPFSConfig{Provenance: []models.ProvenanceField{models.ProvenanceHost, models.ProvenancePID, models.ProvenanceTime}})
+	before := time.Now().Add(-time.Second)
+
+	mdata, err := gcp.Write(g, []byte("x"), "traced.txt", &models.FileMetaData{UserMetaData: map[string]string{string(models.ProvenanceHost): "override"}})
+	if err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	if mdata.UploadHost() != "override" {
+		t.Errorf("user supplied host should win, got %q", mdata.UploadHost())
+	}
+	if mdata.UploadPID() != os.Getpid() {
+		t.Errorf("pid = %d, want %d", mdata.UploadPID(), os.Getpid())
+	}
+	if mdata.UploadTime().Before(before) {
+		t.Errorf("upload time %v is not current", mdata.UploadTime())
+	}
+}
+
+func TestDefaultMetadata(t *testing.T) {
+	defaults := map[string]string{"tenant": "acme", "app": "billing", "env": "prod"}
+	gcp, g, f := newTestGCPFS(t, &models.GCPFSConfig{DefaultMetadata: defaults})
+
+	if _, err := gcp.Write(g, []byte("x"), "a.txt", &models.FileMetaData{UserMetaData: map[string]string{"env": "staging", "owner": "ann"}}); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	if _, err := gcp.WriteStream(g, strings.NewReader("y"), "b.txt", nil); err != nil {
+		t.Fatalf("WriteStream: %v", err)
+	}
+	for name, want := range map[string]map[string]string{
+		"a.txt": {"tenant": "acme", "app": "billing", "env": "staging", "owner": "ann"},
+		"b.txt": defaults,
+	} {
+		got := f.object(testBucket, "tenants/acme/"+name).attrs.Metadata
+		if len(got) != len(want) {
+			t.Errorf("%s: metadata = %v, want %v", name, got, want)
+		}
+		for k, v := range want {
+			if got[k] != v {
+				t.Errorf("%s: metadata[%s] = %q, want %q", name, k, got[k], v)
+			}
+		}
+	}
+	if defaults["owner"] != "" || len(defaults) != 3 {
+		t.Errorf("the configured defaults were modified: %v", defaults)
+	}
+
+	conf := &models.GCPFSConfig{BucketName: testBucket, FS: &models.FS{ParentFolder: "p"}, DefaultMetadata: map[string]string{"": "x"}}
+	if err := conf.Validate(); err == nil {
+		t.Errorf("an empty DefaultMetadata key should be rejected")
+	}
+}
+
+func TestRollingWriter(t *testing.T) {
+	gcp, g, f := newTestGCPFS(t, nil)
+	w, err := gcp.NewRollingWriter(g, "logs", 10, time.Hour)
+	if err != nil {
+		t.Fatalf("NewRollingWriter: %v", err)
+	}
+	for _, line := range []string{"0123456", "789abcdef", "ghijklmnopqrstu", "v"} {
+		if _, err := w.Write([]byte(line)); err != nil {
+			t.Fatalf("Write: %v", err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	names := f.objectNames(testBucket)
+	var got []string
+	for _, name := range names {
+		got = append(got, string(f.object(testBucket, name).data))
+	}
+	want := []string{"0123456789", "abcdefghij", "klmnopqrst", "uv"}
+	if strings.Join(got, "|") != strings.Join(want, "|") {
+		t.Fatalf("rolled objects %v hold %q, want %q", names, got, want)
+	}
+	for _, name := range names {
+		if !strings.HasPrefix(name, "tenants/acme/logs/") {
+			t.Errorf("object %s is not under the prefix", name)
+		}
+	}
+}
+
+func TestRollingWriterMetadata(t *testing.T) {
+	m := &fakeMetrics{ops: map[string]int{}, bytes: map[string]int64{}}
+	gcp, g, f := newTestGCPFS(t, &models.GCPFSConfig{
+		DefaultMetadata: map[string]string{"app": "billing"},
+		Provenance:      []models.ProvenanceField{models.ProvenancePID},
+		Metrics:         m,
+	})
+	w, err := gcp.NewRollingWriter(g, "logs", 4, 0)
+	if err != nil {
+		t.Fatalf("NewRollingWriter: %v", err)
+	}
+	if _, err := w.Write([]byte("abcdef")); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	names := f.objectNames(testBucket)
+	if len(names) != 2 {
+		t.Fatalf("expected two rolled objects, found %v", names)
+	}
+	for _, name := range names {
+		meta := f.object(testBucket, name).attrs.Metadata
+		if meta["app"] != "billing" || meta[string(models.ProvenancePID)] != strconv.Itoa(os.Getpid()) {
+			t.Errorf("%s: metadata = %v, want the defaults and provenance", name, meta)
+		}
+	}
+	if n := m.ops["write_stream test-bucket ok"]; n != 2 || m.bytes["write_stream test-bucket"] != 6 {
+		t.Errorf("expected each rolled object traced, ops = %v, bytes = %v", m.ops, m.bytes)
+	}
+}
+
+func TestRollingWriterMaxAge(t *testing.T) {
+	gcp, g, f := newTestGCPFS(t, nil)
+	w, err := gcp.NewRollingWriter(g, "logs", 0, 50*time.Millisecond)
+	if err != nil {
+		t.Fatalf("NewRollingWriter: %v", err)
+	}
+	w.Write([]byte("first"))
+	time.Sleep(300 * time.Millisecond)
+	if names := f.objectNames(testBucket); len(names) != 1 {
+		t.Fatalf("expected the aged object to be committed before Close, found %v", names)
+	}
+	w.Write([]byte("second"))
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	if names := f.objectNames(testBucket); len(names) != 2 {
+		t.Fatalf("expected two objects, found %v", names)
+	}
+}
+
+func BenchmarkReadSmallObjects(b *testing.B) {
+	for _, size := range []int{0, 4 << 10, 64 << 10} {
+		b.Run(fmt.Sprintf("ReadBufferSize=%d", size), func(b *testing.B) {
+			f := newFakeGCS(b)
+			f.createBucket(testBucket)
+			conf := &models.GCPFSConfig{BucketName: testBucket, ReadBufferSize: size, FS: &models.FS{ParentFolder: "bench"}}
+			g := &GCPFS{client: f.client(nil), config: conf, ctx: context.Background(), stats: &opStats{}, exists: &existsCache{}}
+			gcp := &GCPController{}
+			data := bytes.Repeat([]byte("s"), 2048)
+			for i := 0; i < 64; i++ {
+				f.put(testBucket, fmt.Sprintf("bench/obj-%d", i), data, nil)
+			}
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if _, _, err := gcp.Read(g, fmt.Sprintf("obj-%d", i%64)); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func TestFixContentTypes(t *testing.T) {
+	gcp, g, f := newTestGCPFS(t, nil)
+	f.put(testBucket, "tenants/acme/site/index.html", []byte("<html></html>"), nil).attrs.ContentType = "application/octet-stream"
+	f.put(testBucket, "tenants/acme/site/ok.css", []byte("a{}"), nil).attrs.ContentType = "text/css"
+	f.put(testBucket, "tenants/acme/site/blob", []byte("%PDF-1.4"), nil).attrs.ContentType = "application/octet-stream"
+
+	n, err := gcp.FixContentTypes(g, "site/", &FixContentTypesOptions{DryRun: true, Sniff: true})
+	if err != nil || n != 2 {
+		t.Fatalf("dry run = %d, %v; want 2 fixes", n, err)
+	}
+	if ct := f.object(testBucket, "tenants/acme/site/index.html").attrs.ContentType; ct != "application/octet-stream" {
+		t.Fatalf("dry run changed the content type to %q", ct)
+	}
+
+	if n, err = gcp.FixContentTypes(g, "site/", nil); err != nil || n != 1 {
+		t.Fatalf("FixContentTypes = %d, %v; want 1 fix without sniffing", n, err)
+	}
+	if ct := f.object(testBucket, "tenants/acme/site/index.html").attrs.ContentType; !strings.HasPrefix(ct, "text/html") {
+		t.Errorf("index.html content type = %q", ct)
+	}
+	if n, err = gcp.FixContentTypes(g, "site/", &FixContentTypesOptions{Sniff: true}); err != nil || n != 1 {
+		t.Fatalf("FixContentTypes with Sniff = %d, %v; want 1 fix", n, err)
+	}
+	if ct := f.object(testBucket, "tenants/acme/site/blob").attrs.ContentType; ct != "application/pdf" {
+		t.Errorf("sniffed content type = %q", ct)
+	}
+}
+
+func TestGenerateUploadPolicy(t *testing.T) {
+	gcp, g, _ := newTestGCPFS(t, &models.GCPFSConfig{SigningAccessID: "uploader@example.iam.gserviceaccount.com", SigningPrivateKey: testSigningKey(t)})
+
+	policy, err := gcp.GenerateUploadPolicy(g, "uploads/avatar.png", 1<<20, time.Hour, "image/*")
+	if err != nil {
+		t.Fatalf("GenerateUploadPolicy: %v", err)
+	}
+	if !strings.Contains(policy.URL, testBucket) {
+		t.Errorf("URL %q does not target the bucket", policy.URL)
+	}
+	if policy.Fields["key"] != "tenants/acme/uploads/avatar.png" || policy.Fields["x-goog-signature"] == "" {
+		t.Errorf("unexpected fields %v", policy.Fields)
+	}
+	doc, err := base64.StdEncoding.DecodeString(policy.Fields["policy"])
+	if err != nil {
+		t.Fatalf("policy is not base64: %v", err)
+	}
+	for _, cond := range []string{`["content-length-range",0,1048576]`, `["starts-with","$Content-Type","image/"]`} {
+		if !strings.Contains(string(doc), cond) {
+			t.Errorf("policy %s is missing condition %s", doc, cond)
+		}
+	}
+
+	if _, err := gcp.GenerateUploadPolicy(g, "uploads/avatar.png", 1<<20, 0, ""); err == nil {
+		t.Errorf("a non-positive expiry should be rejected")
+	}
+}
+
+func TestListParallel(t *testing.T) {
+	gcp, g, f := newTestGCPFS(t, nil)
+	var want []string
+	for _, name := range []string{"top.txt", "a/1", "a/2", "b/x/1", "b/y/2", "c/1"} {
+		f.put(testBucket, "tenants/acme/data/"+name, []byte("x"), nil)
+		want = append(want, "data/"+name)
+	}
+	f.put(testBucket, "tenants/acme/other/1", []byte("x"), nil)
+
+	got, err := gcp.ListParallel(g, "data/", 2)
+	if err != nil {
+		t.Fatalf("ListParallel: %v", err)
+	}
+	if len(got) != len(want) {
+		t.Errorf("got %d objects, want %d", len(got), len(want))
+	}
+	for _, name := range want {
+		if got[name] == nil {
+			t.Errorf("missing %s", name)
+		}
+	}
+
+	flat, err := gcp.ListParallel(g, "data/a/", 2)
+	if err != nil || len(flat) != 2 || flat["data/a/1"] == nil {
+		t.Errorf("ListParallel without subprefixes = %v, %v", flat, err)
+	}
+}
+
+func TestWritePublic(t *testing.T) {
+	gcp, g, f := newTestGCPFS(t, nil)
+	u, mdata, err := gcp.WritePublic(g, []byte("body{}"), "static/site.css", nil)
+	if err != nil {
+		t.Fatalf("WritePublic: %v", err)
+	}
+	if want := "https://storage.googleapis.com/test-bucket/tenants/acme/static/site.css"; u != want {
+		t.Errorf("url = %q, want %q", u, want)
+	}
+	if mdata.ContentType != "text/css; charset=utf-8" {
+		t.Errorf("content type = %q", mdata.ContentType)
+	}
+	acl := f.object(testBucket, "tenants/acme/static/site.css").attrs.Acl
+	if len(acl) != 1 || acl[0].Entity != "allUsers" {
+		t.Errorf("object is not public, acl = %v", acl)
+	}
+
+	f.bucket(testBucket).uniformAccess = true
+	if _, _, err := gcp.WritePublic(g, []byte("x"), "static/other.css", nil); err == nil || !strings.Contains(err.Error(), "bucket IAM") {
+		t.Errorf("expected a uniform access error pointing at IAM, got %v", err)
+	}
+}
+
+func TestPredefinedACLAndMakePublic(t *testing.T) {
+	gcp, g, f := newTestGCPFS(t, nil)
+	if _, err := gcp.Write(g, []byte("x"), "team.txt", &models.FileMetaData{PredefinedACL: "projectPrivate"}); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	if acl := f.object(testBucket, "tenants/acme/team.txt").attrs.Acl; len(acl) != 1 || acl[0].Entity != "predefined-projectPrivate" {
+		t.Errorf("PredefinedACL was not applied, acl = %v", acl)
+	}
+	meta := &models.FileMetaData{PredefinedACL: "private"}
+	if _, err := gcp.WriteWithOptions(g, []byte("x"), "logo.png", meta, &WriteOptions{PredefinedACL: "publicRead"}); err != nil {
+		t.Fatalf("WriteWithOptions: %v", err)
+	}
+	if acl := f.object(testBucket, "tenants/acme/logo.png").attrs.Acl; len(acl) != 1 || acl[0].Entity != "allUsers" {
+		t.Errorf("WriteOptions.PredefinedACL was not applied, acl = %v", acl)
+	}
+	if meta.PredefinedACL != "private" {
+		t.Errorf("the caller's metadata was modified")
+	}
+	if _, err := gcp.Write(g, []byte("x"), "bad.txt", &models.FileMetaData{PredefinedACL: "everyone"}); err == nil {
+		t.Errorf("an unknown ACL should be rejected")
+	}
+
+	f.put(testBucket, "tenants/acme/old.css", []byte("body{}"), nil)
+	if err := gcp.MakePublic(g, "old.css"); err != nil {
+		t.Fatalf("MakePublic: %v", err)
+	}
+	acl := f.object(testBucket, "tenants/acme/old.css").attrs.Acl
+	if len(acl) != 1 || acl[0].Entity != "allUsers" || acl[0].Role != "READER" {
+		t.Errorf("object is not public, acl = %v", acl)
+	}
+	if err := gcp.MakePublic(g, "missing.css"); !errors.Is(err, models.ErrNotFound) {
+		t.Errorf("MakePublic of a missing object: expected ErrNotFound, got %v", err)
+	}
+	f.bucket(testBucket).uniformAccess = true
+	if err := gcp.MakePublic(g, "old.css"); err == nil || !strings.Contains(err.Error(), "bucket IAM") {
+		t.Errorf("expected a uniform access error pointing at IAM, got %v", err)
+	}
+}
+
+func TestCopySourceGenerationMatch(t *testing.T) {
+	gcp, g, f := newTestGCPFS(t, nil)
+	if _, err := gcp.Write(g, []byte("v1"), "snap/src", nil); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	seen, err := gcp.Stat(g, "snap/src")
+	if err != nil {
+		t.Fatalf("Stat: %v", err)
+	}
+	// The source changes between the caller looking at it and copying it.
+	if _, err := gcp.Write(g, []byte("v2"), "snap/src", nil); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+
+	err = gcp.CopyWithOptions(g, "snap/src", "snap/dst", &CopyOptions{SourceGenerationMatch: seen.Generation})
+	if !errors.Is(err, models.ErrPreconditionFailed) {
+		t.Fatalf("expected ErrPreconditionFailed, got %v", err)
+	}
+	if f.object(testBucket, "tenants/acme/snap/dst") != nil {
+		t.Fatalf("a failed copy wrote the destination")
+	}
+
+	current, _ := gcp.Stat(g, "snap/src")
+	if err := gcp.CopyWithOptions(g, "snap/src", "snap/dst", &CopyOptions{SourceGenerationMatch: current.Generation}); err != nil {
+		t.Fatalf("CopyWithOptions at the current generation: %v", err)
+	}
+	if err := gcp.CopyWithOptions(g, "snap/src", "snap/dst", &CopyOptions{SourceGenerationMatch: current.Generation}); !errors.Is(err, models.ErrAlreadyExists) {
+		t.Fatalf("expected ErrAlreadyExists for an existing destination, got %v", err)
+	}
+}
+
+func TestDefaultContentDisposition(t *testing.T) {
+	gcp, g, _ := newTestGCPFS(t, &models.GCPFSConfig{DefaultContentDisposition: "attachment"})
+
+	mdata, err := gcp.Write(g, []byte("%PDF"), "docs/a.pdf", nil)
+	if err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	if mdata.ContentDisposition != "attachment" {
+		t.Errorf("default disposition not applied, got %q", mdata.ContentDisposition)
+	}
+	override := `inline; filename="b.pdf"`
+	if mdata, err = gcp.Write(g, []byte("%PDF"), "docs/b.pdf", &models.FileMetaData{ContentDisposition: override}); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	if mdata.ContentDisposition != override {
+		t.Errorf("per-call disposition not applied, got %q", mdata.ContentDisposition)
+	}
+	if _, err := gcp.Write(g, []byte("x"), "docs/c.pdf", &models.FileMetaData{ContentDisposition: "attachment; filename="}); err == nil {
+		t.Errorf("a malformed disposition should be rejected")
+	}
+}
+
+func TestTail(t *testing.T) {
+	gcp, g, _ := newTestGCPFS(t, nil)
+	if _, err := gcp.Write(g, []byte("line1\nline2\n"), "logs/app.log", nil); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	data, mdata, err := gcp.Tail(g, "logs/app.log", 6)
+	if err != nil || string(data) != "line2\n" {
+		t.Fatalf("Tail = %q, %v", data, err)
+	}
+	if mdata.Size != 12 {
+		t.Errorf("size = %d, want 12", mdata.Size)
+	}
+	if data, _, err = gcp.Tail(g, "logs/app.log", 100); err != nil || string(data) != "line1\nline2\n" {
+		t.Errorf("Tail past the start = %q, %v", data, err)
+	}
+}
+
+func TestLowercaseKeys(t *testing.T) {
+	gcp, g, f := newTestGCPFS(t, &models.GCPFSConfig{LowercaseKeys: true})
+	f.put(testBucket, "tenants/acme/Legacy/Report.PDF", []byte("old"), nil)
+
+	if _, err := gcp.Write(g, []byte("new"), "Img/Logo.PNG", nil); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	if f.object(testBucket, "tenants/acme/img/logo.png") == nil {
+		t.Fatalf("key was not lowercased, have %v", f.objectNames(testBucket))
+	}
+	if data, _, err := gcp.Read(g, "IMG/logo.png"); err != nil || string(data) != "new" {
+		t.Errorf("Read with other casing = %q, %v", data, err)
+	}
+	if data, _, err := gcp.Read(g, "Legacy/Report.PDF"); err != nil || string(data) != "old" {
+		t.Errorf("pre-existing mixed-case object should still be readable: %q, %v", data, err)
+	}
+	if r, c, err := gcp.OpenLineReader(g, "Legacy/Report.PDF"); err != nil {
+		t.Errorf("OpenLineReader of the pre-existing mixed-case object: %v", err)
+	} else {
+		line, _ := r.ReadString('\n')
+		c.Close()
+		if line != "old" {
+			t.Errorf("OpenLineReader of the pre-existing mixed-case object = %q", line)
+		}
+	}
+	if _, err := gcp.Write(g, []byte("x"), "Legacy/Report.PDF", nil); !errors.Is(err, models.ErrCaseCollision) {
+		t.Errorf("expected ErrCaseCollision, got %v", err)
+	}
+}
+
+func TestStreamingReadsUseMirror(t *testing.T) {
+	gcp, g, f := newTestGCPFS(t, &models.GCPFSConfig{MirrorBucketName: "mirror-bucket"})
+	f.createBucket("mirror-bucket")
+	f.put("mirror-bucket", "tenants/acme/replicated.txt", []byte("from the mirror\n"), nil)
+
+	r, c, err := gcp.OpenLineReader(g, "replicated.txt")
+	if err != nil {
+		t.Fatalf("OpenLineReader: %v", err)
+	}
+	defer c.Close()
+	if line, err := r.ReadString('\n'); err != nil || line != "from the mirror\n" {
+		t.Errorf("OpenLineReader = %q, %v", line, err)
+	}
+	rc, mdata, err := gcp.ReadStream(g, "replicated.txt")
+	if err != nil {
+		t.Fatalf("ReadStream: %v", err)
+	}
+	defer rc.Close()
+	if mdata.Bucket != "mirror-bucket" {
+		t.Errorf("ReadStream served from %q, want the mirror", mdata.Bucket)
+	}
+}
+
+func TestUploadMultipart(t *testing.T) {
+	gcp, g, f := newTestGCPFS(t, nil)
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	mw.WriteField("title", "holiday")
+	h := textproto.MIMEHeader{}
+	h.Set("Content-Disposition", `form-data; name="photo"; filename="../../beach.jpg"`)
+	h.Set("Content-Type", "image/jpeg")
+	pw, _ := mw.CreatePart(h)
+	pw.Write([]byte("jpeg bytes"))
+	fw, _ := mw.CreateFormFile("notes", "notes.txt")
+	fw.Write([]byte("remember sunscreen"))
+	mw.Close()
+
+	got, err := gcp.UploadMultipart(g, multipart.NewReader(&body, mw.Boundary()), "uploads/42")
+	if err != nil {
+		t.Fatalf("UploadMultipart: %v", err)
+	}
+	if len(got) != 2 || got["photo/beach.jpg"] == nil || got["notes/notes.txt"] == nil {
+		t.Fatalf("unexpected result keys: %v", got)
+	}
+	if ct := got["photo/beach.jpg"].ContentType; ct != "image/jpeg" {
+		t.Errorf("content type = %q, want the part's image/jpeg", ct)
+	}
+	if o := f.object(testBucket, "tenants/acme/uploads/42/beach.jpg"); o == nil || string(o.data) != "jpeg bytes" {
+		t.Errorf("photo not stored under the prefix, have %v", f.objectNames(testBucket))
+	}
+
+	// Parts whose filenames share a base name would land on the same object.
+	body.Reset()
+	mw = multipart.NewWriter(&body)
+	fw, _ = mw.CreateFormFile("front", "scan.png")
+	fw.Write([]byte("front page"))
+	fw, _ = mw.CreateFormFile("back", "other/scan.png")
+	fw.Write([]byte("back page"))
+	mw.Close()
+	got, err = gcp.UploadMultipart(g, multipart.NewReader(&body, mw.Boundary()), "uploads/43")
+	if err == nil {
+		t.Fatalf("expected an error for a repeated object name, got %v", got)
+	}
+	if len(got) != 1 || got["front/scan.png"] == nil {
+		t.Errorf("the first part should be reported as uploaded: %v", got)
+	}
+	if o := f.object(testBucket, "tenants/acme/uploads/43/scan.png"); o == nil || string(o.data) != "front page" {
+		t.Errorf("the first part was overwritten: %+v", o)
+	}
+}
+
+func TestReadText(t *testing.T) {
+	gcp, g, f := newTestGCPFS(t, &models.GCPFSConfig{TextDecoders: map[string]func([]byte) (string, error){
+		"x-rot13": func(b []byte) (string, error) {
+			return strings.Map(func(r rune) rune {
+				if r >= 'a' && r <= 'z' {
+					return 'a' + (r-'a'+13)%26
+				}
+				return r
+			}, string(b)), nil
+		},
+	}})
+	put := func(name string, data []byte, contentType string) {
+		f.put(testBucket, "tenants/acme/"+name, data, nil).attrs.ContentType = contentType
+	}
+	put("bom8.txt", append([]byte{0xef, 0xbb, 0xbf}, "héllo"...), "text/plain")
+	put("bom16.txt", []byte{0xff, 0xfe, 'h', 0, 0xe9, 0}, "text/plain")
+	put("latin1.txt", []byte{'h', 0xe9}, "text/plain; charset=ISO-8859-1")
+	put("custom.txt", []byte("uryyb"), "text/plain; charset=x-rot13")
+	put("unknown.txt", []byte("raw"), "text/plain; charset=x-unknown")
+
+	tests := map[string]string{
+		"bom8.txt":    "héllo",
+		"bom16.txt":   "hé",
+		"latin1.txt":  "hé",
+		"custom.txt":  "hello",
+		"unknown.txt": "raw",
+	}
+	for name, want := range tests {
+		got, _, err := gcp.ReadText(g, name)
+		if err != nil {
+			t.Fatalf("ReadText(%s): %v", name, err)
+		}
+		if got != want {
+			t.Errorf("ReadText(%s) = %q, want %q", name, got, want)
+		}
+	}
+}
+
+func TestStreamTo(t *testing.T) {
+	gcp, g, _ := newTestGCPFS(t, nil)
+	data := bytes.Repeat([]byte(`{"event":1}`), 1000)
+	if _, err := gcp.Write(g, data, "events/batch.json", nil); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	var got []byte
+	var gotLength int64
+	var gotType string
+	hook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got, _ = io.ReadAll(r.Body)
+		gotLength, gotType = r.ContentLength, r.Header.Get("Content-Type")
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer hook.Close()
+
+	req, _ := http.NewRequest(http.MethodPost, hook.URL, nil)
+	resp, err := gcp.StreamTo(g, "events/batch.json", req)
+	if err != nil {
+		t.Fatalf("StreamTo: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusAccepted {
+		t.Errorf("status = %d", resp.StatusCode)
+	}
+	if !bytes.Equal(got, data) || gotLength != int64(len(data)) || gotType != "application/json" {
+		t.Errorf("endpoint got %d bytes, Content-Length %d, Content-Type %q", len(got), gotLength, gotType)
+	}
+	if s := gcp.Stats(g); s.InFlight != 0 {
+		t.Errorf("operation still in flight after the body was sent: %+v", s)
+	}
+}
+
+func TestLogOperations(t *testing.T) {
+	var logs bytes.Buffer
+	gcp, g, _ := newTestGCPFS(t, &models.GCPFSConfig{Logger: log.New(&logs, "", 0)})
+	if _, err := gcp.Write(g, []byte("hello"), "a.txt", nil); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	if logs.Len() != 0 {
+		t.Errorf("operations were logged without LogOperations: %q", logs.String())
+	}
+
+	g.config.LogOperations = true
+	if _, err := gcp.Write(g.With(WithRequestID("req-7")), []byte("hello"), "a.txt", nil); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(logs.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("expected a start and a completion line, got %q", lines)
+	}
+	if lines[0] != "event=op_start request_id=req-7 op=write object=tenants/acme/a.txt" {
+		t.Errorf("start line %q", lines[0])
+	}
+	if !strings.HasPrefix(lines[1], "event=op_done request_id=req-7 op=write object=tenants/acme/a.txt bytes=5 duration=") || strings.Contains(lines[1], "err=") {
+		t.Errorf("completion line %q", lines[1])
+	}
+
+	logs.Reset()
+	if _, _, err := gcp.Read(g, "missing.txt"); err == nil {
+		t.Fatalf("Read of a missing object should fail")
+	}
+	if done := logs.String(); !strings.Contains(done, "event=op_done op=read object=tenants/acme/missing.txt bytes=0") || !strings.Contains(done, "err=") {
+		t.Errorf("failed read logged as %q", done)
+	}
+}
+
+// fakeMetrics counts ObserveOp calls by "op bucket result" and bytes by "op bucket".
+type fakeMetrics struct {
+	mu    sync.Mutex
+	ops   map[string]int
+	bytes map[string]int64
+}
+
+func (m *fakeMetrics) ObserveOp(op string, bucket string, duration time.Duration, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	result := "ok"
+	if err != nil {
+		result = "error"
+	}
+	m.ops[op+" "+bucket+" "+result]++
+}
+
+func (m *fakeMetrics) AddBytes(op string, bucket string, n int64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.bytes[op+" "+bucket] += n
+}
+
+func TestMetrics(t *testing.T) {
+	m := &fakeMetrics{ops: map[string]int{}, bytes: map[string]int64{}}
+	gcp, g, _ := newTestGCPFS(t, &models.GCPFSConfig{Metrics: m})
+	if _, err := gcp.Write(g, []byte("hello"), "a.txt", nil); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	if _, _, err := gcp.Read(g, "a.txt"); err != nil {
+		t.Fatalf("Read: %v", err)
+	}
+	if err := gcp.Delete(g, "missing.txt"); err == nil {
+		t.Fatalf("Delete of a missing object should fail")
+	}
+
+	if _, err := gcp.WriteStreamWithAutoMeta(g, strings.NewReader("streamed"), "b.txt", nil); err != nil {
+		t.Fatalf("WriteStreamWithAutoMeta: %v", err)
+	}
+
+	want := map[string]int{"write test-bucket ok": 2, "read test-bucket ok": 1, "delete test-bucket error": 1}
+	if fmt.Sprint(m.ops) != fmt.Sprint(want) {
+		t.Errorf("ops = %v, want %v", m.ops, want)
+	}
+	if m.bytes["write test-bucket"] != 5+8 || m.bytes["read test-bucket"] != 5 || len(m.bytes) != 2 {
+		t.Errorf("bytes = %v", m.bytes)
+	}
+}
+
+func TestWithRequestID(t *testing.T) {
+	var logs bytes.Buffer
+	conf := &models.GCPFSConfig{MaxRetries: 3, RetryBaseDelay: time.Millisecond, Logger: log.New(&logs, "", 0)}
+	gcp, g, f := newTestGCPFS(t, conf)
+	f.put(testBucket, "tenants/acme/file.txt", []byte("hello"), nil)
+	flaky := &failingTransport{status: http.StatusServiceUnavailable, n: 1}
+	g.client = f.client(func(rt http.RoundTripper) http.RoundTripper {
+		flaky.next = rt
+		return flaky
+	})
+
+	if _, _, err := gcp.Read(g.With(WithRequestID("req-42")), "file.txt"); err != nil {
+		t.Fatalf("Read: %v", err)
+	}
+	line := logs.String()
+	if !strings.Contains(line, "event=retry request_id=req-42 attempt=1") {
+		t.Errorf("retry log line missing the request ID: %q", line)
+	}
+	if g.requestID != "" {
+		t.Errorf("With must not modify the original GCPFS")
+	}
+
+	logs.Reset()
+	atomic.StoreInt32(&flaky.n, 1)
+	if _, _, err := gcp.Read(g, "file.txt"); err != nil {
+		t.Fatalf("Read: %v", err)
+	}
+	if strings.Contains(logs.String(), "request_id") {
+		t.Errorf("untagged operation logged a request ID: %q", logs.String())
+	}
+}
+
+func TestStorageClassBreakdown(t *testing.T) {
+	gcp, g, f := newTestGCPFS(t, nil)
+	for i := 0; i < 1100; i++ {
+		o := f.put(testBucket, fmt.Sprintf("tenants/acme/logs/%04d.log", i), []byte("0123456789"), nil)
+		if i%4 == 0 {
+			o.attrs.StorageClass = "COLDLINE"
+		}
+	}
+	f.put(testBucket, "tenants/other/big.bin", make([]byte, 100), nil)
+
+	totals, err := gcp.StorageClassBreakdown(g, "logs")
+	if err != nil {
+		t.Fatalf("StorageClassBreakdown: %v", err)
+	}
+	if len(totals) != 2 || totals["STANDARD"] != 825*10 || totals["COLDLINE"] != 275*10 {
+		t.Errorf("totals = %v", totals)
+	}
+
+	mdata, err := gcp.Stat(g, "logs/0000.log")
+	if err != nil {
+		t.Fatalf("Stat: %v", err)
+	}
+	if mdata.StorageClass != "COLDLINE" {
+		t.Errorf("StorageClass = %q", mdata.StorageClass)
+	}
+}
+
+func TestMoveMany(t *testing.T) {
+	gcp, g, f := newTestGCPFS(t, nil)
+	for _, name := range []string{"a.txt", "b.txt", "c.txt"} {
+		f.put(testBucket, "tenants/acme/in/"+name, []byte(name), nil)
+	}
+	f.put(testBucket, "tenants/acme/out/b.txt", []byte("existing"), nil)
+	moves := map[string]string{"in/a.txt": "out/a.txt", "in/b.txt": "out/b.txt", "in/c.txt": "out/c.txt"}
+
+	results, err := gcp.MoveMany(g, moves, false)
+	var batch *BatchError
+	if !errors.As(err, &batch) || len(batch.Errors) != 1 {
+		t.Fatalf("expected a single pre-flight conflict, got %v", err)
+	}
+	if !errors.Is(results["in/b.txt"], models.ErrAlreadyExists) || results["in/a.txt"] != nil || len(results) != 3 {
+		t.Errorf("results = %v", results)
+	}
+	if f.object(testBucket, "tenants/acme/out/a.txt") != nil || f.object(testBucket, "tenants/acme/in/a.txt") == nil {
+		t.Errorf("a conflicting batch must not move anything")
+	}
+
+	results, err = gcp.MoveManyWithOptions(g, map[string]string{"in/a.txt": "out/x.txt", "in/c.txt": "out/x.txt", "in/missing.txt": "out/m.txt"}, &MoveManyOptions{DryRun: true})
+	if err == nil || !errors.Is(results["in/a.txt"], models.ErrAlreadyExists) || !errors.Is(results["in/c.txt"], models.ErrAlreadyExists) || !errors.Is(results["in/missing.txt"], models.ErrNotFound) {
+		t.Errorf("dry run: results = %v, err = %v", results, err)
+	}
+
+	results, err = gcp.MoveManyWithOptions(g, moves, &MoveManyOptions{Overwrite: true, DryRun: true})
+	if err != nil || len(results) != 3 || f.object(testBucket, "tenants/acme/out/a.txt") != nil {
+		t.Fatalf("dry run with overwrite: results = %v, err = %v", results, err)
+	}
+
+	if _, err := gcp.MoveMany(g, moves, true); err != nil {
+		t.Fatalf("MoveMany: %v", err)
+	}
+	for from, to := range moves {
+		if f.object(testBucket, "tenants/acme/"+from) != nil {
+			t.Errorf("%s was not removed", from)
+		}
+		if o := f.object(testBucket, "tenants/acme/"+to); o == nil || string(o.data) != path.Base(to) {
+			t.Errorf("%s does not hold the moved content", to)
+		}
+	}
+}
+
+func TestPublicURL(t *testing.T) {
+	conf := &models.GCPFSConfig{}
+	gcp, g, _ := newTestGCPFS(t, conf)
+	if got, want := gcp.PublicURL(g, "img/logo.png"), "https://storage.googleapis.com/test-bucket/tenants/acme/img/logo.png"; got != want {
+		t.Errorf("without a CDN got %q, want %q", got, want)
+	}
+	for _, base := range []string{"https://cdn.example.com/assets", "https://cdn.example.com/assets/"} {
+		conf.CDNBaseURL = base
+		if got, want := gcp.PublicURL(g, "img/my logo.png"), "https://cdn.example.com/assets/img/my%20logo.png"; got != want {
+			t.Errorf("base %q: got %q, want %q", base, got, want)
+		}
+	}
+	if got := gcp.PublicURL(g, "/img/logo.png"); got != "" {
+		t.Errorf("an absolute path should give no URL, got %q", got)
+	}
+	conf.CDNBaseURL = "cdn.example.com"
+	if err := conf.Validate(); err == nil {
+		t.Errorf("a base URL without scheme should be rejected")
+	}
+}
+
+func TestVerifyIntegrity(t *testing.T) {
+	gcp, g, f := newTestGCPFS(t, nil)
+	data := bytes.Repeat([]byte("0123456789"), 100)
+	if _, err := gcp.Write(g, data, "ok.bin", nil); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	if _, err := gcp.WriteWithChunkChecksums(g, data, "chunked.bin", nil, 64); err != nil {
+		t.Fatalf("WriteWithChunkChecksums: %v", err)
+	}
+	if _, err := gcp.Write(g, data, "rotten.bin", nil); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	f.object(testBucket, "tenants/acme/rotten.bin").data[500] ^= 1
+
+	for _, name := range []string{"ok.bin", "chunked.bin"} {
+		if _, err := gcp.VerifyIntegrity(g, name); err != nil {
+			t.Errorf("VerifyIntegrity(%s): %v", name, err)
+		}
+	}
+	if _, err := gcp.VerifyIntegrity(g, "rotten.bin"); !errors.Is(err, models.ErrChecksumMismatch) {
+		t.Errorf("corrupted object: expected ErrChecksumMismatch, got %v", err)
+	}
+	meta := f.object(testBucket, "tenants/acme/chunked.bin").attrs.Metadata
+	sums := strings.Split(meta[ChunkCRC32CKey], ",")
+	sums[3] = "00000000"
+	meta[ChunkCRC32CKey] = strings.Join(sums, ",")
+	if _, err := gcp.VerifyIntegrity(g, "chunked.bin"); !errors.Is(err, models.ErrChecksumMismatch) || !strings.Contains(err.Error(), "chunk 3") {
+		t.Errorf("bad chunk checksum: expected a chunk 3 mismatch, got %v", err)
+	}
+}
+
+func TestStartScrubber(t *testing.T) {
+	gcp, g, f := newTestGCPFS(t, nil)
+	for i := 0; i < 10; i++ {
+		if _, err := gcp.Write(g, []byte(fmt.Sprintf("object %d", i)), fmt.Sprintf("data/%d.txt", i), nil); err != nil {
+			t.Fatalf("Write: %v", err)
+		}
+	}
+	f.object(testBucket, "tenants/acme/data/7.txt").data[0] = 'O'
+
+	failures := make(chan string, 10)
+	stop, err := gcp.StartScrubber(g, "data", time.Hour, func(filePath string, err error) {
+		if errors.Is(err, models.ErrChecksumMismatch) {
+			failures <- filePath
+		} else {
+			t.Errorf("unexpected scrub error for %s: %v", filePath, err)
+		}
+	})
+	if err != nil {
+		t.Fatalf("StartScrubber: %v", err)
+	}
+	select {
+	case name := <-failures:
+		if name != "data/7.txt" {
+			t.Errorf("scrubber reported %s", name)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("scrubber did not report the corrupted object")
+	}
+	stop()
+	stop()
+	if s := gcp.Stats(g); s.InFlight != 0 {
+		t.Errorf("scrub still in flight after stop: %+v", s)
+	}
+	if len(failures) != 0 {
+		t.Errorf("only one object is corrupted, got another report: %s", <-failures)
+	}
+
+	if _, err := gcp.StartScrubber(g, "data", 0, func(string, error) {}); err == nil {
+		t.Errorf("a zero interval should be rejected")
+	}
+}
+
+func TestNewGCPStorageClientStaysOpen(t *testing.T) {
+	f := newFakeGCS(t)
+	f.createBucket(testBucket)
+	t.Setenv("STORAGE_EMULATOR_HOST", f.srv.URL)
+	gcp := &GCPController{}
+	g, err := gcp.NewGCPStorage(&models.GCPFSConfig{BucketName: testBucket, FS: &models.FS{ParentFolder: "tenants/acme"}})
+	if err != nil {
+		t.Fatalf("NewGCPStorage: %v", err)
+	}
+	for i := 0; i < 3; i++ {
+		if _, err := gcp.Write(g, []byte("still connected"), "conn.txt", nil); err != nil {
+			t.Fatalf("Write %d: %v", i, err)
+		}
+		if data, _, err := gcp.Read(g, "conn.txt"); err != nil || string(data) != "still connected" {
+			t.Fatalf("Read %d: %q, %v", i, data, err)
+		}
+	}
+	if err := gcp.Close(g); err != nil {
+		t.Errorf("Close: %v", err)
+	}
+}
+
+func TestAutoCreateBucket(t *testing.T) {
+	f := newFakeGCS(t)
+	t.Setenv("STORAGE_EMULATOR_HOST", f.srv.URL)
+	gcp := &GCPController{}
+	conf := &models.GCPFSConfig{BucketName: "fresh-bucket", FS: &models.FS{ParentFolder: "ci"}, ProjectID: "dev", BucketLocation: "EU"}
+	if _, err := gcp.Write(mustConnect(t, gcp, conf), []byte("x"), "a.txt", nil); !errors.Is(err, models.ErrNotFound) {
+		t.Errorf("Write without the bucket: expected ErrNotFound, got %v", err)
+	}
+	if f.bucket("fresh-bucket") != nil {
+		t.Fatalf("the bucket was created without AutoCreateBucket")
+	}
+
+	conf.AutoCreateBucket = true
+	g := mustConnect(t, gcp, conf)
+	if b := f.bucket("fresh-bucket"); b == nil || b.attrs.Location != "EU" {
+		t.Fatalf("bucket was not created in EU: %+v", b)
+	}
+	if _, err := gcp.Write(g, []byte("x"), "a.txt", nil); err != nil {
+		t.Errorf("Write to the created bucket: %v", err)
+	}
+	// Connecting again finds the bucket and leaves it alone.
+	mustConnect(t, gcp, conf)
+	if f.object("fresh-bucket", "ci/a.txt") == nil {
+		t.Errorf("reconnecting replaced the bucket")
+	}
+
+	conf = &models.GCPFSConfig{BucketName: "other-bucket", FS: &models.FS{ParentFolder: "ci"}, AutoCreateBucket: true}
+	if err := conf.Validate(); err == nil {
+		t.Errorf("Validate should require a ProjectID for AutoCreateBucket")
+	}
+}
+
+// mustConnect calls NewGCPStorage, closing the result at the end of the test.
+func mustConnect(t *testing.T, gcp *GCPController, conf *models.GCPFSConfig) *GCPFS {
+	t.Helper()
+	g, err := gcp.NewGCPStorage(conf)
+	if err != nil {
+		t.Fatalf("NewGCPStorage: %v", err)
+	}
+	t.Cleanup(func() { g.Close() })
+	return g
+}
+
+func TestNewGCPStorageCredentialsFromConfig(t *testing.T) {
+	t.Setenv("STORAGE_EMULATOR_HOST", "")
+	t.Setenv("GOOGLE_APPLICATION_CREDENTIALS", "")
+	const email = "tenant@example.iam.gserviceaccount.com"
+	creds, _ := json.Marshal(map[string]string{
+		"type":           "service_account",
+		"project_id":     "example",
+		"private_key_id": "1",
+		"private_key":    string(testSigningKey(t)),
+		"client_email":   email,
+		"client_id":      "1",
+		"token_uri":      "https://oauth2.googleapis.com/token",
+	})
+	credsFile := filepath.Join(t.TempDir(), "key.json")
+	if err := os.WriteFile(credsFile, creds, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	gcp := &GCPController{}
+	for name, conf := range map[string]*models.GCPFSConfig{
+		"json": {CredentialsJSON: creds},
+		"file": {CredentialsFile: credsFile},
+	} {
+		conf.BucketName, conf.FS = testBucket, &models.FS{ParentFolder: "tenants/acme"}
+		g, err := gcp.NewGCPStorage(conf)
+		if err != nil {
+			t.Fatalf("%s: NewGCPStorage: %v", name, err)
+		}
+		// Signing needs no network, so checking the signer shows which credentials the client holds.
+		signed, err := gcp.SignedURL(g, "a.txt", time.Minute)
+		if err != nil {
+			t.Fatalf("%s: SignedURL: %v", name, err)
+		}
+		if u, _ := url.Parse(signed); !strings.HasPrefix(u.Query().Get("X-Goog-Credential"), email+"/") {
+			t.Errorf("%s: URL not signed with the configured credentials: %s", name, signed)
+		}
+		g.Close()
+	}
+
+	conf := &models.GCPFSConfig{BucketName: testBucket, FS: &models.FS{ParentFolder: "p"}, CredentialsJSON: creds, CredentialsFile: credsFile}
+	if err := conf.Validate(); err == nil {
+		t.Errorf("Validate should reject both CredentialsJSON and CredentialsFile")
+	}
+}
+
+func TestReadStream(t *testing.T) {
+	gcp, g, f := newTestGCPFS(t, nil)
+	// 8 MiB of pseudo-random content; only its hash is kept on the test side.
+	payload := make([]byte, 8<<20)
+	rand.Read(payload)
+	want := sha256.Sum256(payload)
+	f.put(testBucket, "tenants/acme/big.bin", payload, nil).attrs.ContentType = "application/octet-stream"
+	payload = nil
+
+	rc, mdata, err := gcp.ReadStream(g, "big.bin")
+	if err != nil {
+		t.Fatalf("ReadStream: %v", err)
+	}
+	h := sha256.New()
+	n, err := io.Copy(h, rc)
+	if err != nil {
+		t.Fatalf("io.Copy: %v", err)
+	}
+	if err := rc.Close(); err != nil {
+		t.Errorf("Close: %v", err)
+	}
+	if n != 8<<20 || !bytes.Equal(h.Sum(nil), want[:]) {
+		t.Errorf("streamed %d bytes that do not match what was stored", n)
+	}
+	if mdata.Size != 8<<20 || mdata.ContentType != "application/octet-stream" || mdata.Name != "tenants/acme/big.bin" || mdata.Generation == 0 {
+		t.Errorf("metadata = %+v", mdata)
+	}
+	if s := gcp.Stats(g); s.InFlight != 0 {
+		t.Errorf("operation still in flight after Close: %+v", s)
+	}
+	if _, _, err := gcp.ReadStream(g, "missing.bin"); !errors.Is(err, models.ErrNotFound) {
+		t.Errorf("expected ErrNotFound, got %v", err)
+	}
+}
+
+func TestWriteStream(t *testing.T) {
+	gcp, g, f := newTestGCPFS(t, nil)
+	pr, pw := io.Pipe()
+	sum := md5.New()
+	go func() {
+		chunk := make([]byte, 64<<10)
+		for i := 0; i < 48; i++ {
+			rand.Read(chunk)
+			sum.Write(chunk)
+			if _, err := pw.Write(chunk); err != nil {
+				return
+			}
+		}
+		pw.Close()
+	}()
+
+	mdata, err := gcp.WriteStream(g, pr, "uploads/stream.bin", &models.FileMetaData{UserMetaData: map[string]string{"source": "pipe"}})
+	if err != nil {
+		t.Fatalf("WriteStream: %v", err)
+	}
+	if mdata.Size != 48*64<<10 {
+		t.Errorf("Size = %d", mdata.Size)
+	}
+	if want := hex.EncodeToString(sum.Sum(nil)); mdata.Md5Hash != want {
+		t.Errorf("Md5Hash = %s, want %s", mdata.Md5Hash, want)
+	}
+	if o := f.object(testBucket, "tenants/acme/uploads/stream.bin"); o == nil || o.attrs.Metadata["source"] != "pipe" {
+		t.Errorf("object not stored with its metadata")
+	}
+
+	pr, pw = io.Pipe()
+	go func() {
+		pw.Write([]byte("partial"))
+		pw.CloseWithError(errors.New("client went away"))
+	}()
+	if _, err := gcp.WriteStream(g, pr, "uploads/broken.bin", nil); err == nil {
+		t.Errorf("a failing reader should fail the upload")
+	}
+	if f.object(testBucket, "tenants/acme/uploads/broken.bin") != nil {
+		t.Errorf("a failed stream must not commit an object")
+	}
+}
+
+// slowTransport delays every request by delay, giving up early if the request is cancelled.
+type slowTransport struct {
+	next  http.RoundTripper
+	delay time.Duration
+}
+
+func (t *slowTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	select {
+	case <-time.After(t.delay):
+	case <-r.Context().Done():
+		return nil, r.Context().Err()
+	}
+	return t.next.RoundTrip(r)
+}
+
+func TestConfiguredTimeouts(t *testing.T) {
+	conf := &models.GCPFSConfig{ReadTimeout: 20 * time.Millisecond, MetadataTimeout: 20 * time.Millisecond, ListTimeout: models.NoTimeout}
+	gcp, g, f := newTestGCPFS(t, conf)
+	f.put(testBucket, "tenants/acme/slow.txt", []byte("slow"), nil)
+	g.client = f.client(func(rt http.RoundTripper) http.RoundTripper {
+		return &slowTransport{next: rt, delay: 200 * time.Millisecond}
+	})
+
+	if _, _, err := gcp.Read(g, "slow.txt"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Read: expected context.DeadlineExceeded, got %v", err)
+	}
+	if _, err := gcp.Stat(g, "slow.txt"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Stat: expected context.DeadlineExceeded, got %v", err)
+	}
+	if _, err := gcp.List(g, ""); err != nil {
+		t.Errorf("List without a timeout should outlast the slow transport: %v", err)
+	}
+
+	conf.ReadTimeout, conf.WriteTimeout = 0, models.NoTimeout
+	if err := conf.Validate(); err != nil {
+		t.Fatalf("Validate: %v", err)
+	}
+	if conf.ReadTimeout != models.DefaultReadTimeout || conf.WriteTimeout != models.NoTimeout || conf.ListTimeout != models.NoTimeout {
+		t.Errorf("Validate defaults: read %v, write %v, list %v", conf.ReadTimeout, conf.WriteTimeout, conf.ListTimeout)
+	}
+}
+
+func TestCallerContext(t *testing.T) {
+	gcp, g, f := newTestGCPFS(t, nil)
+	f.put(testBucket, "tenants/acme/slow.txt", []byte("slow"), nil)
+	g.client = f.client(func(rt http.RoundTripper) http.RoundTripper {
+		return &slowTransport{next: rt, delay: 5 * time.Second}
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+	start := time.Now()
+	_, _, err := gcp.ReadCtx(ctx, g, "slow.txt")
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("ReadCtx: expected context.Canceled, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("ReadCtx took %v to notice the cancellation", elapsed)
+	}
+
+	ctx, cancel = context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := gcp.List(g.With(WithContext(ctx)), ""); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("List: expected context.DeadlineExceeded, got %v", err)
+	}
+	if g.ctx != context.Background() {
+		t.Errorf("WithContext must not replace the original GCPFS context")
+	}
+}
+
+func TestFind(t *testing.T) {
+	gcp, g, f := newTestGCPFS(t, nil)
+	for _, name := range []string{
+		"logs/a.gz", "logs/b.txt", "logs/2024/01/c.gz", "logs/2024/02/d.gz", "logs/2024/02/e.txt",
+		"img/cat1.png", "img/cat22.png", "img/dog.png",
+	} {
+		f.put(testBucket, "tenants/acme/"+name, []byte(name), nil)
+	}
+	f.put(testBucket, "tenants/other/logs/z.gz", []byte("other tenant"), nil)
+
+	for pattern, want := range map[string][]string{
+		"logs/*.gz":          {"logs/a.gz"},
+		"logs/**/*.gz":       {"logs/a.gz", "logs/2024/01/c.gz", "logs/2024/02/d.gz"},
+		"**/*.txt":           {"logs/b.txt", "logs/2024/02/e.txt"},
+		"logs/2024/*/?.gz":   {"logs/2024/01/c.gz", "logs/2024/02/d.gz"},
+		"img/cat?.png":       {"img/cat1.png"},
+		"img/[cd]*.png":      {"img/cat1.png", "img/cat22.png", "img/dog.png"},
+		"logs/**":            {"logs/a.gz", "logs/b.txt", "logs/2024/01/c.gz", "logs/2024/02/d.gz", "logs/2024/02/e.txt"},
+		"videos/**/*.mp4":    nil,
+		"logs/2024/01/c.gz":  {"logs/2024/01/c.gz"},
+		"logs/**/**/e.txt":   {"logs/2024/02/e.txt"},
+		"logs/*/01/c.gz/**":  {"logs/2024/01/c.gz"},
+		"img/*.png/extra":    nil,
+		"logs/2024/0?/*.txt": {"logs/2024/02/e.txt"},
+	} {
+		got, err := gcp.Find(g, pattern)
+		if err != nil {
+			t.Errorf("Find(%q): %v", pattern, err)
+			continue
+		}
+		if got == nil || len(got) != len(want) {
+			t.Errorf("Find(%q) = %d results, want %v", pattern, len(got), want)
+			continue
+		}
+		for _, name := range want {
+			if got[name] == nil || got[name].Name != "tenants/acme/"+name {
+				t.Errorf("Find(%q) is missing %s", pattern, name)
+			}
+		}
+	}
+	if _, err := gcp.Find(g, "img/[cat.png"); err == nil {
+		t.Errorf("a malformed pattern should be rejected")
+	}
+}
+
+func TestSignedURL(t *testing.T) {
+	conf := &models.GCPFSConfig{SigningAccessID: "reader@example.iam.gserviceaccount.com", SigningPrivateKey: testSigningKey(t)}
+	gcp, g, _ := newTestGCPFS(t, conf)
+
+	signed, err := gcp.SignedURL(g, "reports/q1.pdf", time.Hour)
+	if err != nil {
+		t.Fatalf("SignedURL: %v", err)
+	}
+	u, err := url.Parse(signed)
+	if err != nil {
+		t.Fatalf("url.Parse: %v", err)
+	}
+	q := u.Query()
+	expires, _ := strconv.Atoi(q.Get("X-Goog-Expires"))
+	if !strings.HasSuffix(u.Path, "/test-bucket/tenants/acme/reports/q1.pdf") || q.Get("X-Goog-Algorithm") != "GOOG4-RSA-SHA256" ||
+		expires < 3590 || expires > 3600 || q.Get("X-Goog-Signature") == "" || !strings.HasPrefix(q.Get("X-Goog-Credential"), "reader@example.iam.gserviceaccount.com/") {
+		t.Errorf("unexpected V4 signed URL %s", signed)
+	}
+
+	if _, err := gcp.SignedURL(g, "reports/q1.pdf", 8*24*time.Hour); err == nil {
+		t.Errorf("V4 URLs valid for more than seven days should be rejected")
+	}
+	if _, err := gcp.SignedURL(g, "reports/q1.pdf", 0); err == nil {
+		t.Errorf("a non-positive expiry should be rejected")
+	}
+
+	conf.SigningScheme = models.SigningSchemeV2
+	signed, err = gcp.SignedURL(g, "reports/q1.pdf", time.Hour)
+	if err != nil {
+		t.Fatalf("SignedURL v2: %v", err)
+	}
+	if u, _ := url.Parse(signed); u.Query().Get("GoogleAccessId") != conf.SigningAccessID || u.Query().Get("Signature") == "" {
+		t.Errorf("unexpected V2 signed URL %s", signed)
+	}
+
+	conf.SigningPrivateKey = []byte("not a key")
+	if _, err := gcp.SignedURL(g, "reports/q1.pdf", time.Hour); err == nil || !strings.Contains(err.Error(), "SigningPrivateKey") {
+		t.Errorf("a signing failure should explain how to configure signing, got %v", err)
+	}
+}
+
+func TestListPage(t *testing.T) {
+	gcp, g, f := newTestGCPFS(t, nil)
+	for i := 0; i < 25; i++ {
+		f.put(testBucket, fmt.Sprintf("tenants/acme/items/%02d.txt", i), []byte("x"), nil)
+	}
+	f.put(testBucket, "tenants/acme/other.txt", []byte("x"), nil)
+
+	seen := make(map[string]bool)
+	var sizes []int
+	token := ""
+	for {
+		page, next, err := gcp.ListPage(g, "items/", token, 10)
+		if err != nil {
+			t.Fatalf("ListPage: %v", err)
+		}
+		sizes = append(sizes, len(page))
+		for name := range page {
+			if seen[name] {
+				t.Errorf("%s was listed twice", name)
+			}
+			seen[name] = true
+		}
+		if next == "" {
+			break
+		}
+		token = next
+	}
+	if fmt.Sprint(sizes) != "[10 10 5]" || len(seen) != 25 {
+		t.Errorf("pages of %v holding %d objects, want [10 10 5] holding 25", sizes, len(seen))
+	}
+	if !seen["items/00.txt"] || !seen["items/24.txt"] {
+		t.Errorf("unexpected keys %v", seen)
+	}
+	if _, _, err := gcp.ListPage(g, "items/", "", 0); err == nil {
+		t.Errorf("a non-positive pageSize should be rejected")
+	}
+}
+
+func TestListDir(t *testing.T) {
+	gcp, g, f := newTestGCPFS(t, nil)
+	for _, name := range []string{"a/", "a/b.txt", "a/c/d.txt", "a/c/e/f.txt", "a/g/h.txt", "ab.txt", "top.txt"} {
+		f.put(testBucket, "tenants/acme/"+name, []byte("x"), nil)
+	}
+
+	for _, prefix := range []string{"a/", "a"} {
+		files, prefixes, err := gcp.ListDir(g, prefix)
+		if err != nil {
+			t.Fatalf("ListDir(%q): %v", prefix, err)
+		}
+		if len(files) != 1 || files["b.txt"] == nil || files["b.txt"].Name != "tenants/acme/a/b.txt" {
+			t.Errorf("ListDir(%q) files = %v", prefix, files)
+		}
+		if fmt.Sprint(prefixes) != "[c/ g/]" {
+			t.Errorf("ListDir(%q) prefixes = %v, want [c/ g/]", prefix, prefixes)
+		}
+	}
+	files, prefixes, err := gcp.ListDir(g, "")
+	if err != nil || len(files) != 2 || files["ab.txt"] == nil || files["top.txt"] == nil || fmt.Sprint(prefixes) != "[a/]" {
+		t.Errorf("ListDir of ParentFolder = %v, %v, %v", files, prefixes, err)
+	}
+}
+
+func TestListByMetadata(t *testing.T) {
+	gcp, g, f := newTestGCPFS(t, nil)
+	f.put(testBucket, "tenants/acme/docs/a.txt", []byte("x"), map[string]string{"tenant": "red", "kind": "invoice"})
+	f.put(testBucket, "tenants/acme/docs/b.txt", []byte("x"), map[string]string{"tenant": "red", "kind": "receipt"})
+	f.put(testBucket, "tenants/acme/docs/c.txt", []byte("x"), map[string]string{"tenant": "blue", "kind": "invoice"})
+	f.put(testBucket, "tenants/acme/docs/d.txt", []byte("x"), nil)
+	f.put(testBucket, "tenants/acme/other/e.txt", []byte("x"), map[string]string{"tenant": "red"})
+
+	for _, tc := range []struct {
+		match map[string]string
+		want  []string
+	}{
+		{map[string]string{"tenant": "red"}, []string{"docs/a.txt", "docs/b.txt"}},
+		{map[string]string{"tenant": "red", "kind": "invoice"}, []string{"docs/a.txt"}},
+		{map[string]string{"tenant": "green"}, nil},
+		{nil, []string{"docs/a.txt", "docs/b.txt", "docs/c.txt", "docs/d.txt"}},
+	} {
+		got, err := gcp.ListByMetadata(g, "docs/", tc.match)
+		if err != nil {
+			t.Fatalf("ListByMetadata(%v): %v", tc.match, err)
+		}
+		var names []string
+		for name := range got {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		if fmt.Sprint(names) != fmt.Sprint(tc.want) {
+			t.Errorf("ListByMetadata(%v) = %v, want %v", tc.match, names, tc.want)
+		}
+	}
+}
+
+func TestPing(t *testing.T) {
+	gcp, g, f := newTestGCPFS(t, nil)
+	if err := gcp.Ping(g); err != nil {
+		t.Fatalf("Ping: %v", err)
+	}
+	f.deny(testBucket, "")
+	if err := gcp.Ping(g); !errors.Is(err, models.ErrPermissionDenied) {
+		t.Errorf("Ping without access: expected ErrPermissionDenied, got %v", err)
+	}
+	g.config.BucketName = "no-such-bucket"
+	if err := gcp.Ping(g); !errors.Is(err, models.ErrNotFound) {
+		t.Errorf("Ping of a missing bucket: expected ErrNotFound, got %v", err)
+	}
+}
+
+func TestSignedUploadURL(t *testing.T) {
+	conf := &models.GCPFSConfig{SigningAccessID: "uploader@example.iam.gserviceaccount.com", SigningPrivateKey: testSigningKey(t)}
+	gcp, g, f := newTestGCPFS(t, conf)
+
+	signed, err := gcp.SignedUploadURL(g, "uploads/photo.jpg", 15*time.Minute, "image/jpeg")
+	if err != nil {
+		t.Fatalf("SignedUploadURL: %v", err)
+	}
+	u, err := url.Parse(signed)
+	if err != nil {
+		t.Fatalf("url.Parse: %v", err)
+	}
+	if u.Path != "/test-bucket/tenants/acme/uploads/photo.jpg" || !strings.Contains(u.Query().Get("X-Goog-SignedHeaders"), "content-type") {
+		t.Errorf("unexpected signed upload URL %s", signed)
+	}
+	// Send the upload to the fake instead of storage.googleapis.com.
+	srv, _ := url.Parse(f.srv.URL)
+	u.Scheme, u.Host = srv.Scheme, srv.Host
+	req, _ := http.NewRequest(http.MethodPut, u.String(), strings.NewReader("jpeg bytes"))
+	req.Header.Set("Content-Type", "image/jpeg")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("PUT: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("PUT returned %s", resp.Status)
+	}
+	o := f.object(testBucket, "tenants/acme/uploads/photo.jpg")
+	if o == nil || string(o.data) != "jpeg bytes" || o.attrs.ContentType != "image/jpeg" {
+		t.Errorf("uploaded object: %+v", o)
+	}
+
+	if _, err := gcp.SignedUploadURL(g, "uploads/photo.jpg", -time.Minute, ""); err == nil {
+		t.Errorf("a non-positive expiry should be rejected")
+	}
+	g.config.SigningPrivateKey = nil
+	if _, err := gcp.SignedUploadURL(g, "uploads/photo.jpg", time.Hour, ""); err == nil || !strings.Contains(err.Error(), "SigningPrivateKey") {
+		t.Errorf("a signing failure should explain how to configure signing, got %v", err)
+	}
+}
+
+func TestWriteIfGeneration(t *testing.T) {
+	gcp, g, f := newTestGCPFS(t, nil)
+	created, err := gcp.WriteIfGeneration(g, []byte(`{"n":1}`), "counter.json", 0, nil)
+	if err != nil {
+		t.Fatalf("WriteIfGeneration(0): %v", err)
+	}
+	if created.Generation == 0 || created.Metageneration != 1 {
+		t.Errorf("generation %d, metageneration %d", created.Generation, created.Metageneration)
+	}
+	if _, err := gcp.WriteIfGeneration(g, []byte(`{"n":1}`), "counter.json", 0, nil); !errors.Is(err, models.ErrPreconditionFailed) {
+		t.Errorf("creating an existing object: expected ErrPreconditionFailed, got %v", err)
+	}
+
+	_, read, err := gcp.Read(g, "counter.json")
+	if err != nil {
+		t.Fatalf("Read: %v", err)
+	}
+	if read.Generation != created.Generation {
+		t.Errorf("Read reports generation %d, want %d", read.Generation, created.Generation)
+	}
+	// Someone else updates the object between our read and write.
+	if _, err := gcp.Write(g, []byte(`{"n":5}`), "counter.json", nil); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	if _, err := gcp.WriteIfGeneration(g, []byte(`{"n":2}`), "counter.json", read.Generation, nil); !errors.Is(err, models.ErrPreconditionFailed) {
+		t.Errorf("stale generation: expected ErrPreconditionFailed, got %v", err)
+	}
+	if o := f.object(testBucket, "tenants/acme/counter.json"); string(o.data) != `{"n":5}` {
+		t.Errorf("a failed conditional write replaced the object with %s", o.data)
+	}
+
+	current, err := gcp.Stat(g, "counter.json")
+	if err != nil {
+		t.Fatalf("Stat: %v", err)
+	}
+	updated, err := gcp.WriteIfGeneration(g, []byte(`{"n":6}`), "counter.json", current.Generation, &models.FileMetaData{UserMetaData: map[string]string{"by": "test"}})
+	if err != nil {
+		t.Fatalf("WriteIfGeneration: %v", err)
+	}
+	if updated.Generation == current.Generation || updated.UserMetaData["by"] != "test" {
+		t.Errorf("unexpected metadata after update: %+v", updated)
+	}
+}
+
+func TestListKeysRoundTrip(t *testing.T) {
+	gcp, g, _ := newTestGCPFS(t, nil)
+	for _, name := range []string{"invoices/jan.pdf", "invoices/2024/feb.pdf"} {
+		if _, err := gcp.Write(g, []byte(name), name, nil); err != nil {
+			t.Fatalf("Write: %v", err)
+		}
+	}
+	listed, err := gcp.List(g, "invoices/")
+	if err != nil {
+		t.Fatalf("List: %v", err)
+	}
+	if len(listed) != 2 {
+		t.Fatalf("List returned %d objects", len(listed))
+	}
+	for key, mdata := range listed {
+		if mdata.Name != "tenants/acme/"+key {
+			t.Errorf("key %s has Name %s", key, mdata.Name)
+		}
+		data, _, err := gcp.Read(g, key)
+		if err != nil || string(data) != key {
+			t.Errorf("Read(%s) = %q, %v", key, data, err)
+		}
+	}
+}
+
+func TestDeletePrefix(t *testing.T) {
+	gcp, g, f := newTestGCPFS(t, nil)
+	for i := 0; i < 12; i++ {
+		f.put(testBucket, fmt.Sprintf("tenants/acme/tmp/%d/file.txt", i), []byte("x"), nil)
+	}
+	f.put(testBucket, "tenants/acme/tmp/", nil, nil)
+	f.put(testBucket, "tenants/acme/tmpfile.txt", []byte("keep"), nil)
+	f.put(testBucket, "tenants/acme/tmp2024.txt", []byte("keep"), nil)
+	f.put(testBucket, "tenants/acme/tmparchive/a.txt", []byte("keep"), nil)
+	f.put(testBucket, "tenants/acme/tmp/locked.txt", []byte("x"), nil)
+	f.deny(testBucket, "tenants/acme/tmp/locked.txt")
+
+	// Without the trailing slash the prefix is still the tmp folder only.
+	n, err := gcp.DeletePrefix(g, "tmp")
+	var batch *BatchError
+	if !errors.As(err, &batch) || len(batch.Errors) != 1 || batch.Errors["tmp/locked.txt"] == nil {
+		t.Fatalf("expected only tmp/locked.txt to fail, got %v", err)
+	}
+	if !errors.Is(batch.Errors["tmp/locked.txt"], models.ErrPermissionDenied) {
+		t.Errorf("expected ErrPermissionDenied for tmp/locked.txt, got %v", batch.Errors["tmp/locked.txt"])
+	}
+	if n != 13 {
+		t.Errorf("deleted %d objects, want 13", n)
+	}
+	left, err := gcp.List(g, "tmp/")
+	if err != nil {
+		t.Fatalf("List: %v", err)
+	}
+	if len(left) != 1 || left["tmp/locked.txt"] == nil {
+		t.Errorf("objects left under tmp/: %v", left)
+	}
+	for _, name := range []string{"tmpfile.txt", "tmp2024.txt", "tmparchive/a.txt"} {
+		if f.object(testBucket, "tenants/acme/"+name) == nil {
+			t.Errorf("%s, outside the folder, was deleted", name)
+		}
+	}
+
+	if _, err := gcp.DeletePrefix(g, "/"); err == nil {
+		t.Errorf("an empty prefix should be refused")
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := gcp.DeletePrefix(g.With(WithContext(ctx)), "tmp/"); !errors.Is(err, context.Canceled) {
+		t.Errorf("expected context.Canceled, got %v", err)
+	}
+}
+
+func TestWriteIfNotExists(t *testing.T) {
+	gcp, g, f := newTestGCPFS(t, nil)
+	if _, err := gcp.WriteIfNotExists(g, []byte("first"), "once.txt", nil); err != nil {
+		t.Fatalf("first WriteIfNotExists: %v", err)
+	}
+	if _, err := gcp.WriteIfNotExists(g, []byte("second"), "once.txt", nil); !errors.Is(err, models.ErrAlreadyExists) {
+		t.Errorf("second WriteIfNotExists: expected ErrAlreadyExists, got %v", err)
+	}
+	if got := string(f.object(testBucket, "tenants/acme/once.txt").data); got != "first" {
+		t.Errorf("object holds %q after the refused write", got)
+	}
+}
+
+func TestCopyRewritesAttrs(t *testing.T) {
+	gcp, g, f := newTestGCPFS(t, nil)
+	src := f.put(testBucket, "tenants/acme/archive/report.csv", []byte("a,b"), map[string]string{"owner": "ann"})
+	src.attrs.StorageClass, src.attrs.ContentType, src.attrs.CacheControl = "COLDLINE", "text/csv", "no-store"
+
+	if err := gcp.CopyWithOptions(g, "archive/report.csv", "live/report.csv", &CopyOptions{StorageClass: "standard"}); err != nil {
+		t.Fatalf("CopyWithOptions: %v", err)
+	}
+	dst := f.object(testBucket, "tenants/acme/live/report.csv")
+	if dst.attrs.StorageClass != "STANDARD" || f.object(testBucket, "tenants/acme/archive/report.csv").attrs.StorageClass != "COLDLINE" {
+		t.Errorf("storage class: destination %s, source %s", dst.attrs.StorageClass, src.attrs.StorageClass)
+	}
+	if dst.attrs.ContentType != "text/csv" || dst.attrs.CacheControl != "no-store" || dst.attrs.Metadata["owner"] != "ann" {
+		t.Errorf("the source's other attributes should be kept: %+v", dst.attrs)
+	}
+
+	opts := &CopyOptions{ContentType: "application/vnd.ms-excel", CacheControl: "public, max-age=60", UserMetaData: map[string]string{"team": "ops"}}
+	if err := gcp.CopyWithOptions(g, "archive/report.csv", "shared/report.csv", opts); err != nil {
+		t.Fatalf("CopyWithOptions: %v", err)
+	}
+	dst = f.object(testBucket, "tenants/acme/shared/report.csv")
+	if dst.attrs.ContentType != opts.ContentType || dst.attrs.CacheControl != opts.CacheControl || dst.attrs.StorageClass != "COLDLINE" {
+		t.Errorf("rewritten attributes: %+v", dst.attrs)
+	}
+	if len(dst.attrs.Metadata) != 1 || dst.attrs.Metadata["team"] != "ops" {
+		t.Errorf("user metadata = %v, want it replaced", dst.attrs.Metadata)
+	}
+
+	if err := gcp.CopyWithOptions(g, "archive/report.csv", "x.csv", &CopyOptions{StorageClass: "FROZEN"}); err == nil {
+		t.Errorf("an unknown storage class should be rejected")
+	}
+	if err := gcp.CopyWithOptions(g, "archive/report.csv", "x.csv", &CopyOptions{StorageClass: "STANDARD", SourceGenerationMatch: src.attrs.Generation + 1}); !errors.Is(err, models.ErrPreconditionFailed) {
+		t.Errorf("expected ErrPreconditionFailed, got %v", err)
+	}
+	if err := gcp.CopyWithOptions(g, "archive/missing.csv", "x.csv", &CopyOptions{StorageClass: "STANDARD"}); !errors.Is(err, models.ErrNotFound) {
+		t.Errorf("expected ErrNotFound, got %v", err)
+	}
+	if f.object(testBucket, "tenants/acme/x.csv") != nil {
+		t.Errorf("a failed copy wrote the destination")
+	}
+}
+
+func TestCopyAndMoveOverwrite(t *testing.T) {
+	gcp, g, f := newTestGCPFS(t, nil)
+	f.put(testBucket, "tenants/acme/src.txt", []byte("new content"), nil)
+	f.put(testBucket, "tenants/acme/dst.txt", []byte("old content"), nil)
+
+	if err := gcp.Copy(g, "src.txt", "dst.txt"); !errors.Is(err, models.ErrAlreadyExists) {
+		t.Errorf("Copy onto an existing object: expected ErrAlreadyExists, got %v", err)
+	}
+	if err := gcp.CopyWithOptions(g, "src.txt", "dst.txt", &CopyOptions{Overwrite: false}); !errors.Is(err, models.ErrAlreadyExists) {
+		t.Errorf("Copy with overwrite=false: expected ErrAlreadyExists, got %v", err)
+	}
+	if string(f.object(testBucket, "tenants/acme/dst.txt").data) != "old content" {
+		t.Fatalf("a refused copy replaced the destination")
+	}
+	if err := gcp.CopyWithOptions(g, "src.txt", "dst.txt", &CopyOptions{Overwrite: true}); err != nil {
+		t.Fatalf("Copy with overwrite=true: %v", err)
+	}
+	if got := string(f.object(testBucket, "tenants/acme/dst.txt").data); got != "new content" {
+		t.Errorf("destination holds %q after overwrite", got)
+	}
+
+	f.put(testBucket, "tenants/acme/next.txt", []byte("moved content"), nil)
+	if err := gcp.Move(g, "next.txt", "dst.txt"); !errors.Is(err, models.ErrAlreadyExists) {
+		t.Errorf("Move onto an existing object: expected ErrAlreadyExists, got %v", err)
+	}
+	if err := gcp.MoveWithOptions(g, "next.txt", "dst.txt", &CopyOptions{Overwrite: true}); err != nil {
+		t.Fatalf("MoveWithOptions: %v", err)
+	}
+	if f.object(testBucket, "tenants/acme/next.txt") != nil || string(f.object(testBucket, "tenants/acme/dst.txt").data) != "moved content" {
+		t.Errorf("move with overwrite did not replace the destination")
+	}
+}
+
+func TestCopyToOtherBucket(t *testing.T) {
+	gcp, g, f := newTestGCPFS(t, nil)
+	f.createBucket("archive-bucket")
+	f.put(testBucket, "tenants/acme/src.txt", []byte("payload"), map[string]string{"owner": "ops"})
+
+	if err := gcp.CopyTo(g, "src.txt", "Bad_Bucket!", "copy.txt", false); err == nil {
+		t.Errorf("CopyTo accepted an invalid bucket name")
+	}
+	if err := gcp.CopyTo(g, "src.txt", "archive-bucket", "backups/copy.txt", false); err != nil {
+		t.Fatalf("CopyTo: %v", err)
+	}
+	dst := f.object("archive-bucket", "backups/copy.txt")
+	if dst == nil || string(dst.data) != "payload" || dst.attrs.Metadata["owner"] != "ops" {
+		t.Fatalf("copied object: %+v", dst)
+	}
+	if f.object(testBucket, "tenants/acme/src.txt") == nil {
+		t.Errorf("CopyTo removed the source")
+	}
+	if err := gcp.CopyTo(g, "src.txt", "archive-bucket", "backups/copy.txt", false); !errors.Is(err, models.ErrAlreadyExists) {
+		t.Errorf("CopyTo onto an existing object: expected ErrAlreadyExists, got %v", err)
+	}
+	if err := gcp.CopyTo(g, "src.txt", testBucket, "tenants/acme/src.txt", true); err == nil {
+		t.Errorf("CopyTo onto the source itself should fail")
+	}
+
+	if err := gcp.MoveTo(g, "src.txt", "archive-bucket", "backups/moved.txt", false); err != nil {
+		t.Fatalf("MoveTo: %v", err)
+	}
+	if f.object(testBucket, "tenants/acme/src.txt") != nil {
+		t.Errorf("the source should be gone after MoveTo")
+	}
+	if moved := f.object("archive-bucket", "backups/moved.txt"); moved == nil || string(moved.data) != "payload" || moved.attrs.Metadata["owner"] != "ops" {
+		t.Errorf("moved object: %+v", moved)
+	}
+}
+
+func TestWriteContentType(t *testing.T) {
+	gcp, g, _ := newTestGCPFS(t, nil)
+	png := append([]byte("\x89PNG\r\n\x1a\n"), make([]byte, 64)...)
+
+	for _, tc := range []struct {
+		path string
+		meta *models.FileMetaData
+		want string
+	}{
+		{"img/logo.png", nil, "image/png"},
+		{"img/no-extension", nil, "image/png"},
+		{"docs/readme.txt", nil, "text/plain; charset=utf-8"},
+		{"img/override.png", &models.FileMetaData{ContentType: "application/x-custom"}, "application/x-custom"},
+	} {
+		mdata, err := gcp.Write(g, png, tc.path, tc.meta)
+		if err != nil {
+			t.Fatalf("Write(%s): %v", tc.path, err)
+		}
+		if mdata.ContentType != tc.want {
+			t.Errorf("Write(%s) content type %q, want %q", tc.path, mdata.ContentType, tc.want)
+		}
+		if stat, err := gcp.Stat(g, tc.path); err != nil || stat.ContentType != tc.want {
+			t.Errorf("Stat(%s) content type %v, %v", tc.path, stat, err)
+		}
+	}
+}
+
+func TestUpdateMetadata(t *testing.T) {
+	gcp, g, f := newTestGCPFS(t, nil)
+	f.put(testBucket, "tenants/acme/doc.txt", []byte("x"), map[string]string{"owner": "ann", "team": "ops", "stale": "yes"})
+
+	mdata, err := gcp.UpdateMetadata(g, "doc.txt", map[string]string{"team": "dev", "reviewed": "true"}, []string{"stale", "never-set"})
+	if err != nil {
+		t.Fatalf("UpdateMetadata: %v", err)
+	}
+	want := map[string]string{"owner": "ann", "team": "dev", "reviewed": "true"}
+	for _, got := range []map[string]string{mdata.UserMetaData, f.object(testBucket, "tenants/acme/doc.txt").attrs.Metadata} {
+		if len(got) != len(want) {
+			t.Errorf("metadata = %v, want %v", got, want)
+		}
+		for k, v := range want {
+			if got[k] != v {
+				t.Errorf("metadata[%s] = %q, want %q", k, got[k], v)
+			}
+		}
+	}
+
+	if _, err := gcp.UpdateMetadata(g, "doc.txt", map[string]string{"a": "1"}, []string{"a"}); err == nil {
+		t.Errorf("setting and removing the same key should be rejected")
+	}
+	if _, err := gcp.UpdateMetadata(g, "missing.txt", map[string]string{"a": "1"}, nil); !errors.Is(err, models.ErrNotFound) {
+		t.Errorf("expected ErrNotFound, got %v", err)
+	}
+}
+
+func TestUpdateMetadataRemoveKeepsClassAndKey(t *testing.T) {
+	gcp, g, f := newTestGCPFS(t, nil)
+	const kmsKey = "projects/p/locations/l/keyRings/r/cryptoKeys/k"
+	stored := f.put(testBucket, "tenants/acme/doc.txt", []byte("x"), map[string]string{"owner": "ann", "stale": "yes"})
+	stored.attrs.StorageClass, stored.attrs.KmsKeyName = "NEARLINE", kmsKey+"/cryptoKeyVersions/3"
+
+	mdata, err := gcp.UpdateMetadata(g, "doc.txt", nil, []string{"stale"})
+	if err != nil {
+		t.Fatalf("UpdateMetadata: %v", err)
+	}
+	o := f.object(testBucket, "tenants/acme/doc.txt")
+	if mdata.StorageClass != "NEARLINE" || o.attrs.StorageClass != "NEARLINE" || o.attrs.KmsKeyName != kmsKey {
+		t.Errorf("class %s and key %s after removing a key", o.attrs.StorageClass, o.attrs.KmsKeyName)
+	}
+	if _, ok := mdata.UserMetaData["stale"]; ok || mdata.UserMetaData["owner"] != "ann" {
+		t.Errorf("metadata = %v", mdata.UserMetaData)
+	}
+
+	key := bytes.Repeat([]byte{7}, 32)
+	keyed := g.With(WithEncryptionKey(key))
+	if _, err := gcp.Write(keyed, []byte("secret"), "csek.txt", &models.FileMetaData{UserMetaData: map[string]string{"owner": "ann", "stale": "yes"}}); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	if _, err := gcp.UpdateMetadata(keyed, "csek.txt", nil, []string{"stale"}); err != nil {
+		t.Fatalf("UpdateMetadata of a CSEK object: %v", err)
+	}
+	if o := f.object(testBucket, "tenants/acme/csek.txt"); o.keySHA == "" || o.attrs.Metadata["stale"] != "" {
+		t.Errorf("CSEK object after removing a key: key %q, metadata %v", o.keySHA, o.attrs.Metadata)
+	}
+}
+
+func TestUpdateMetadataPatchKeepsGeneration(t *testing.T) {
+	gcp, g, f := newTestGCPFS(t, nil)
+	stored := f.put(testBucket, "tenants/acme/doc.txt", []byte("x"), map[string]string{"owner": "ann"})
+	stored.attrs.ContentType = "text/plain"
+
+	mdata, err := gcp.UpdateMetadata(g, "doc.txt", map[string]string{"team": "dev"}, nil)
+	if err != nil {
+		t.Fatalf("UpdateMetadata: %v", err)
+	}
+	if mdata.Generation != stored.attrs.Generation || mdata.Metageneration != 2 || mdata.UserMetaData["owner"] != "ann" {
+		t.Errorf("patch: %+v", mdata)
+	}
+	mdata, err = gcp.UpdateMetadata(g, "doc.txt", nil, []string{"owner"})
+	if err != nil {
+		t.Fatalf("UpdateMetadata: %v", err)
+	}
+	if mdata.ContentType != "text/plain" || mdata.UserMetaData["team"] != "dev" || len(mdata.UserMetaData) != 1 {
+		t.Errorf("remove: %+v", mdata)
+	}
+}
+
+func TestRetryWriteCopyDelete(t *testing.T) {
+	conf := &models.GCPFSConfig{MaxRetries: 3, RetryBaseDelay: time.Millisecond}
+	gcp, g, f := newTestGCPFS(t, conf)
+	flaky := &failingTransport{status: http.StatusServiceUnavailable}
+	g.client = f.client(func(rt http.RoundTripper) http.RoundTripper {
+		flaky.next = rt
+		return flaky
+	})
+
+	atomic.StoreInt32(&flaky.n, 2)
+	if _, err := gcp.Write(g, []byte("hello"), "file.txt", nil); err != nil {
+		t.Fatalf("Write should succeed after two 503s: %v", err)
+	}
+	if obj := f.object(testBucket, "tenants/acme/file.txt"); obj == nil || string(obj.data) != "hello" {
+		t.Fatalf("Write stored %+v", obj)
+	}
+	atomic.StoreInt32(&flaky.n, 2)
+	if err := gcp.Copy(g, "file.txt", "copy.txt"); err != nil {
+		t.Fatalf("Copy should succeed after two 503s: %v", err)
+	}
+	atomic.StoreInt32(&flaky.n, 2)
+	if err := gcp.Delete(g, "copy.txt"); err != nil {
+		t.Fatalf("Delete should succeed after two 503s: %v", err)
+	}
+	if f.object(testBucket, "tenants/acme/copy.txt") != nil {
+		t.Errorf("copy.txt should be gone")
+	}
+
+	atomic.StoreInt32(&flaky.n, 10)
+	if _, err := gcp.Write(g, []byte("again"), "file.txt", nil); err == nil {
+		t.Fatalf("Write should fail once MaxRetries is used up")
+	}
+
+	flaky.status = http.StatusForbidden
+	for name, op := range map[string]func() error{
+		"Write": func() error { _, err := gcp.Write(g, []byte("x"), "other.txt", nil); return err },
+		"Copy":  func() error { return gcp.Copy(g, "file.txt", "copy.txt") },
+	} {
+		atomic.StoreInt32(&flaky.n, 10)
+		if err := op(); err == nil {
+			t.Errorf("%s: a 403 is not retryable and should fail", name)
+		}
+		if left := atomic.LoadInt32(&flaky.n); left != 9 {
+			t.Errorf("%s: made %d requests on a 403, want 1", name, 10-left)
+		}
+	}
+}
+
+func TestNewGCPStorageWithClient(t *testing.T) {
+	f := newFakeGCS(t)
+	f.createBucket(testBucket)
+	gcp := &GCPController{}
+	if _, err := gcp.NewGCPStorageWithClient(&models.GCPFSConfig{BucketName: testBucket}, nil); err == nil {
+		t.Fatalf("a nil client should be rejected")
+	}
+	client := f.client(nil)
+	g, err := gcp.NewGCPStorageWithClient(&models.GCPFSConfig{BucketName: testBucket, FS: &models.FS{ParentFolder: "tenants/acme"}}, client)
+	if err != nil {
+		t.Fatalf("NewGCPStorageWithClient: %v", err)
+	}
+
+	if _, err := gcp.Write(g, []byte("end to end"), "e2e/file.txt", nil); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	if data, _, err := gcp.Read(g, "e2e/file.txt"); err != nil || string(data) != "end to end" {
+		t.Fatalf("Read: %q, %v", data, err)
+	}
+	list, err := gcp.List(g, "e2e")
+	if err != nil {
+		t.Fatalf("List: %v", err)
+	}
+	if _, ok := list["e2e/file.txt"]; !ok || len(list) != 1 {
+		t.Errorf("List: %v", list)
+	}
+	if err := gcp.Delete(g, "e2e/file.txt"); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if _, _, err := gcp.Read(g, "e2e/file.txt"); !errors.Is(err, storage.ErrObjectNotExist) {
+		t.Errorf("Read after Delete: %v", err)
+	}
+
+	if err := gcp.Close(g); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	if _, err := client.Bucket(testBucket).Attrs(context.Background()); err != nil {
+		t.Errorf("a supplied client should stay open after Close: %v", err)
+	}
+}
+
+func TestComposedObjectCrc32c(t *testing.T) {
+	gcp, g, _ := newTestGCPFS(t, nil)
+	for name, data := range map[string]string{"part1": "hello ", "part2": "world"} {
+		mdata, err := gcp.Write(g, []byte(data), name, nil)
+		if err != nil {
+			t.Fatalf("Write %s: %v", name, err)
+		}
+		if mdata.Md5Hash == "" || mdata.Crc32c == "" {
+			t.Errorf("%s: a plain upload should have both checksums: %+v", name, mdata)
+		}
+	}
+	mdata, err := gcp.Compose(g, "whole", []string{"part1", "part2"}, nil)
+	if err != nil {
+		t.Fatalf("Compose: %v", err)
+	}
+	if mdata.Md5Hash != "" {
+		t.Errorf("composite objects have no MD5, got %q", mdata.Md5Hash)
+	}
+	want := fmt.Sprintf("%08x", crc32.Checksum([]byte("hello world"), crc32.MakeTable(crc32.Castagnoli)))
+	if mdata.Crc32c != want {
+		t.Errorf("Crc32c = %q, want %q", mdata.Crc32c, want)
+	}
+}
+
+func TestWriteVerifiesSuppliedChecksum(t *testing.T) {
+	gcp, g, f := newTestGCPFS(t, nil)
+	data := []byte("checked content")
+	sum := md5.Sum(data)
+	crc := fmt.Sprintf("%08x", crc32.Checksum(data, crc32.MakeTable(crc32.Castagnoli)))
+
+	mdata, err := gcp.Write(g, data, "good.txt", &models.FileMetaData{Md5Hash: hex.EncodeToString(sum[:]), Crc32c: crc})
+	if err != nil {
+		t.Fatalf("Write with matching checksums: %v", err)
+	}
+	if mdata.Md5Hash != hex.EncodeToString(sum[:]) || mdata.Crc32c != crc {
+		t.Errorf("stored checksums: %+v", mdata)
+	}
+
+	wrong := md5.Sum([]byte("something else"))
+	_, err = gcp.Write(g, data, "bad.txt", &models.FileMetaData{Md5Hash: hex.EncodeToString(wrong[:])})
+	if !errors.Is(err, models.ErrChecksumMismatch) {
+		t.Fatalf("a wrong MD5 should fail with ErrChecksumMismatch, got %v", err)
+	}
+	_, err = gcp.WriteStream(g, bytes.NewReader(data), "bad.txt", &models.FileMetaData{Crc32c: "deadbeef"})
+	if !errors.Is(err, models.ErrChecksumMismatch) {
+		t.Fatalf("a wrong CRC32C should fail with ErrChecksumMismatch, got %v", err)
+	}
+	if f.object(testBucket, "tenants/acme/bad.txt") != nil {
+		t.Errorf("a rejected upload should not be stored")
+	}
+
+	if _, err := gcp.Write(g, data, "bad.txt", &models.FileMetaData{Md5Hash: "not-hex"}); err == nil || errors.Is(err, models.ErrChecksumMismatch) {
+		t.Errorf("a malformed Md5Hash should be rejected before uploading, got %v", err)
+	}
+}
+
+func TestWriteKMSKeyName(t *testing.T) {
+	const (
+		bucketKey = "projects/p/locations/global/keyRings/ring/cryptoKeys/default"
+		objectKey = "projects/p/locations/global/keyRings/ring/cryptoKeys/special"
+	)
+	gcp, g, f := newTestGCPFS(t, &models.GCPFSConfig{KMSKeyName: bucketKey})
+
+	mdata, err := gcp.Write(g, []byte("secret"), "a.txt", nil)
+	if err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	if !strings.HasPrefix(mdata.KMSKeyName, bucketKey) || !strings.HasPrefix(f.object(testBucket, "tenants/acme/a.txt").attrs.KmsKeyName, bucketKey) {
+		t.Errorf("the configured key should be used, got %q", mdata.KMSKeyName)
+	}
+	mdata, err = gcp.WriteStream(g, strings.NewReader("secret"), "b.txt", &models.FileMetaData{KMSKeyName: objectKey})
+	if err != nil {
+		t.Fatalf("WriteStream: %v", err)
+	}
+	if !strings.HasPrefix(mdata.KMSKeyName, objectKey) {
+		t.Errorf("the per-write key should win, got %q", mdata.KMSKeyName)
+	}
+	if _, err := gcp.Write(g, []byte("secret"), "c.txt", &models.FileMetaData{KMSKeyName: "my-key"}); err == nil {
+		t.Errorf("a malformed key name should be rejected")
+	}
+
+	conf := &models.GCPFSConfig{BucketName: testBucket, FS: &models.FS{ParentFolder: "p"}, KMSKeyName: "keyRings/ring/cryptoKeys/k"}
+	if err := conf.Validate(); err == nil {
+		t.Errorf("Validate should reject a malformed KMSKeyName")
+	}
+}
+
+func TestReadParallel(t *testing.T) {
+	gcp, g, f := newTestGCPFS(t, nil)
+	payload := make([]byte, 50<<20)
+	rand.Read(payload)
+	f.put(testBucket, "tenants/acme/big.bin", payload, nil)
+
+	sequential, _, err := gcp.Read(g, "big.bin")
+	if err != nil {
+		t.Fatalf("Read: %v", err)
+	}
+	before := f.count("GET media")
+	data, mdata, err := gcp.ReadParallel(g, "big.bin", 5<<20, 4)
+	if err != nil {
+		t.Fatalf("ReadParallel: %v", err)
+	}
+	if !bytes.Equal(data, sequential) || mdata.Size != int64(len(payload)) {
+		t.Errorf("parallel read differs from the sequential one (%d bytes)", len(data))
+	}
+	if n := f.count("GET media") - before; n != 10 {
+		t.Errorf("expected 10 range requests, got %d", n)
+	}
+
+	f.put(testBucket, "tenants/acme/small.txt", []byte("tiny"), nil)
+	if data, _, err := gcp.ReadParallel(g, "small.txt", 5<<20, 4); err != nil || string(data) != "tiny" {
+		t.Errorf("ReadParallel of a small object: %q, %v", data, err)
+	}
+	if _, _, err := gcp.ReadParallel(g, "missing.bin", 5<<20, 4); !errors.Is(err, models.ErrNotFound) {
+		t.Errorf("ReadParallel of a missing object: expected ErrNotFound, got %v", err)
+	}
+	if _, _, err := gcp.ReadParallel(g, "big.bin", 0, 4); err == nil {
+		t.Errorf("a non-positive partSize should be rejected")
+	}
+	if _, _, err := gcp.ReadParallel(g, "big.bin", 5<<20, 0); err == nil {
+		t.Errorf("a non-positive concurrency should be rejected")
+	}
+}
+
+func TestRequesterPays(t *testing.T) {
+	gcp, g, f := newTestGCPFS(t, nil)
+	f.put(testBucket, "tenants/acme/paid.txt", []byte("x"), nil)
+	f.bucket(testBucket).requesterPays = true
+
+	if _, _, err := gcp.Read(g, "paid.txt"); err == nil {
+		t.Fatalf("Read without a billing project should fail on a requester-pays bucket")
+	}
+	g.config.UserProject = "billing-project"
+	if _, err := gcp.Write(g, []byte("hello"), "new.txt", &models.FileMetaData{UserMetaData: map[string]string{"k": "v"}}); err != nil {
+		t.Errorf("Write: %v", err)
+	}
+	if data, mdata, err := gcp.Read(g, "new.txt"); err != nil || string(data) != "hello" || mdata.UserMetaData["k"] != "v" {
+		t.Errorf("Read: %q, %+v, %v", data, mdata, err)
+	}
+	if _, err := gcp.List(g, ""); err != nil {
+		t.Errorf("List: %v", err)
+	}
+	if err := gcp.Copy(g, "paid.txt", "copy.txt"); err != nil {
+		t.Errorf("Copy: %v", err)
+	}
+	if err := gcp.Delete(g, "paid.txt"); err != nil {
+		t.Errorf("Delete: %v", err)
+	}
+	if f.object(testBucket, "tenants/acme/paid.txt") != nil {
+		t.Errorf("Delete left the object behind")
+	}
+
+	conf := &models.GCPFSConfig{BucketName: testBucket, FS: &models.FS{ParentFolder: "p"}, UserProject: "Not A Project"}
+	if err := conf.Validate(); err == nil {
+		t.Errorf("Validate should reject an invalid UserProject")
+	}
+}
+
+func TestReadRange(t *testing.T) {
+	gcp, g, f := newTestGCPFS(t, nil)
+	data := make([]byte, 1000)
+	for i := range data {
+		data[i] = byte(i)
+	}
+	f.put(testBucket, "tenants/acme/video.bin", data, nil)
+
+	got, mdata, err := gcp.ReadRange(g, "video.bin", 100, 100)
+	if err != nil {
+		t.Fatalf("ReadRange: %v", err)
+	}
+	if !bytes.Equal(got, data[100:200]) {
+		t.Errorf("ReadRange [100,200) returned %d unexpected bytes", len(got))
+	}
+	if mdata.Size != int64(len(data)) {
+		t.Errorf("metadata Size = %d, want the full %d", mdata.Size, len(data))
+	}
+	if got, _, err := gcp.ReadRange(g, "video.bin", 900, -1); err != nil || !bytes.Equal(got, data[900:]) {
+		t.Errorf("length -1 should read to the end: %d bytes, %v", len(got), err)
+	}
+	if got, _, err := gcp.ReadRange(g, "video.bin", 950, 100); err != nil || !bytes.Equal(got, data[950:]) {
+		t.Errorf("a range overrunning the end should be cut short: %d bytes, %v", len(got), err)
+	}
+	if got, _, err := gcp.ReadRange(g, "video.bin", 1000, -1); err != nil || len(got) != 0 {
+		t.Errorf("a range starting at the end should be empty: %d bytes, %v", len(got), err)
+	}
+	if _, _, err := gcp.ReadRange(g, "video.bin", 1001, 1); err == nil {
+		t.Errorf("a range starting past the end should fail")
+	}
+	if _, _, err := gcp.ReadRange(g, "video.bin", -1, 1); err == nil {
+		t.Errorf("a negative offset should fail")
+	}
+	if _, _, err := gcp.ReadRange(g, "missing.bin", 0, 1); !errors.Is(err, models.ErrNotFound) {
+		t.Errorf("a missing object should be ErrNotFound, got %v", err)
+	}
+}
+
+func TestComposeWithMetaData(t *testing.T) {
+	gcp, g, f := newTestGCPFS(t, nil)
+	parts := []string{"alpha-", "beta-", "gamma"}
+	srcs := make([]string, len(parts))
+	for i, p := range parts {
+		srcs[i] = fmt.Sprintf("parts/%d", i)
+		f.put(testBucket, "tenants/acme/"+srcs[i], []byte(p), nil)
+	}
+
+	mdata, err := gcp.Compose(g, "whole.txt", srcs, &ComposeOptions{MetaData: &models.FileMetaData{
+		ContentType:  "text/plain",
+		UserMetaData: map[string]string{"parts": "3"},
+	}})
+	if err != nil {
+		t.Fatalf("Compose: %v", err)
+	}
+	if mdata.Size != int64(len("alpha-beta-gamma")) {
+		t.Errorf("composed Size = %d", mdata.Size)
+	}
+	if data, _, err := gcp.Read(g, "whole.txt"); err != nil || string(data) != "alpha-beta-gamma" {
+		t.Errorf("composed content %q, %v", data, err)
+	}
+	if mdata.ContentType != "text/plain" || mdata.UserMetaData["parts"] != "3" {
+		t.Errorf("metadata should be attached to the result: %+v", mdata)
+	}
+
+	tooMany := make([]string, maxComposeSources+1)
+	for i := range tooMany {
+		tooMany[i] = fmt.Sprintf("parts/%d", i)
+	}
+	if _, err := gcp.Compose(g, "whole.txt", tooMany, nil); err == nil || !strings.Contains(err.Error(), "at most 32") {
+		t.Errorf("more than 32 sources should be refused, got %v", err)
+	}
+}
+
+func TestComposeStampsDefaults(t *testing.T) {
+	gcp, g, f := newTestGCPFS(t, &models.GCPFSConfig{
+		DefaultMetadata: map[string]string{"app": "billing"},
+		Provenance:      []models.ProvenanceField{models.ProvenancePID},
+	})
+	f.put(testBucket, "tenants/acme/part-1", []byte("a"), nil)
+	f.put(testBucket, "tenants/acme/part-2", []byte("b"), nil)
+	f.put(testBucket, "tenants/acme/logs/app.log", []byte("line 1\n"), nil)
+
+	if _, err := gcp.Compose(g, "whole", []string{"part-1", "part-2"}, nil); err != nil {
+		t.Fatalf("Compose: %v", err)
+	}
+	if _, err := gcp.AppendData(g, []byte("line 2\n"), "logs/app.log"); err != nil {
+		t.Fatalf("AppendData: %v", err)
+	}
+	for _, name := range []string{"whole", "logs/app.log"} {
+		meta := f.object(testBucket, "tenants/acme/"+name).attrs.Metadata
+		if meta["app"] != "billing" || meta[string(models.ProvenancePID)] != strconv.Itoa(os.Getpid()) {
+			t.Errorf("%s: metadata = %v, want the defaults and provenance", name, meta)
+		}
+	}
+}
+
+func TestStorageInterface(t *testing.T) {
+	storagetest.Run(t, func(t *testing.T) ninjaStorage.Storage {
+		_, g, _ := newTestGCPFS(t, nil)
+		return NewStore(g)
+	})
+}
+
+func TestWriteBatch(t *testing.T) {
+	gcp, g, f := newTestGCPFS(t, nil)
+	items := make([]WriteItem, 50)
+	for i := range items {
+		items[i] = WriteItem{Data: bytes.Repeat([]byte("x"), i+1), FilePath: fmt.Sprintf("batch/%02d.bin", i)}
+	}
+	results, err := gcp.WriteBatch(g, items, 8)
+	if err != nil {
+		t.Fatalf("WriteBatch: %v", err)
+	}
+	for i, mdata := range results {
+		if mdata == nil || mdata.Name != "tenants/acme/"+items[i].FilePath || mdata.Size != int64(i+1) {
+			t.Fatalf("result %d out of order or wrong: %+v", i, mdata)
+		}
+		if obj := f.object(testBucket, mdata.Name); obj == nil || len(obj.data) != i+1 {
+			t.Fatalf("%s did not land with %d bytes", mdata.Name, i+1)
+		}
+	}
+
+	items = []WriteItem{{Data: []byte("ok"), FilePath: "good.txt"}, {FilePath: "empty.txt"}, {Data: []byte("ok"), FilePath: "also-good.txt"}}
+	results, err = gcp.WriteBatch(g, items, 0)
+	var batchErr *BatchError
+	if !errors.As(err, &batchErr) || len(batchErr.Errors) != 1 || batchErr.Errors["empty.txt"] == nil {
+		t.Fatalf("one failed item should be reported alone, got %v", err)
+	}
+	if results[0] == nil || results[1] != nil || results[2] == nil {
+		t.Errorf("the other items should still be written: %v", results)
+	}
+
+	if _, err := gcp.WriteBatch(g, []WriteItem{{FilePath: "a"}, {FilePath: "a"}}, 1); err == nil {
+		t.Errorf("duplicate paths should be rejected")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	results, err = gcp.WriteBatch(g.With(WithContext(ctx)), []WriteItem{{Data: []byte("x"), FilePath: "never.txt"}, {Data: []byte("y"), FilePath: "nor.txt"}}, 1)
+	if !errors.Is(err, context.Canceled) || results[0] != nil || f.object(testBucket, "tenants/acme/never.txt") != nil {
+		t.Errorf("a cancelled context should stop new uploads, got %v", err)
+	}
+	if !errors.As(err, &batchErr) || len(batchErr.Errors) != 2 || !errors.Is(batchErr.Errors["never.txt"], context.Canceled) || !errors.Is(batchErr.Errors["nor.txt"], context.Canceled) {
+		t.Errorf("every item left out should have its own entry, got %v", err)
+	}
+}
+
+func TestProgress(t *testing.T) {
+	gcp, g, _ := newTestGCPFS(t, nil)
+	data := bytes.Repeat([]byte("0123456789"), 1<<20)
+	size := int64(len(data))
+
+	type report struct{ n, total int64 }
+	var reports []report
+	record := func(n, total int64) { reports = append(reports, report{n, total}) }
+	check := func(op string, total int64) {
+		t.Helper()
+		if len(reports) < 2 {
+			t.Fatalf("%s: want periodic reports plus a final one, got %v", op, reports)
+		}
+		if last := reports[len(reports)-1]; last.n != size || last.total != total {
+			t.Errorf("%s: final report %+v, want %d of %d", op, last, size, total)
+		}
+		for i := 1; i < len(reports); i++ {
+			if reports[i].n < reports[i-1].n {
+				t.Errorf("%s: progress went backwards: %v", op, reports)
+			}
+		}
+		reports = nil
+	}
+
+	if _, err := gcp.WriteWithOptions(g, data, "big.bin", nil, &WriteOptions{Progress: record}); err != nil {
+		t.Fatalf("WriteWithOptions: %v", err)
+	}
+	check("write", size)
+	if _, err := gcp.WriteStreamWithOptions(g, bytes.NewReader(data), "big-stream.bin", nil, &WriteOptions{Progress: record}); err != nil {
+		t.Fatalf("WriteStreamWithOptions: %v", err)
+	}
+	check("stream write", -1)
+	if _, _, err := gcp.ReadWithOptions(g, "big.bin", &ReadOptions{Progress: record}); err != nil {
+		t.Fatalf("ReadWithOptions: %v", err)
+	}
+	check("read", size)
+}
+
+func TestVersionedReads(t *testing.T) {
+	gcp, g, f := newTestGCPFS(t, nil)
+	f.buckets[testBucket].versioning = true
+
+	var gens []int64
+	for _, content := range []string{"v1", "v2", "v3"} {
+		mdata, err := gcp.Write(g, []byte(content), "doc.txt", nil)
+		if err != nil {
+			t.Fatalf("Write %s: %v", content, err)
+		}
+		gens = append(gens, mdata.Generation)
+	}
+	for i, gen := range gens {
+		data, mdata, err := gcp.ReadGeneration(g, "doc.txt", gen)
+		if err != nil {
+			t.Fatalf("ReadGeneration(%d): %v", gen, err)
+		}
+		if want := fmt.Sprintf("v%d", i+1); string(data) != want || mdata.Generation != gen {
+			t.Errorf("generation %d: %q %+v, want %q", gen, data, mdata, want)
+		}
+	}
+	if data, _, _ := gcp.Read(g, "doc.txt"); string(data) != "v3" {
+		t.Errorf("Read should still return the live generation, got %q", data)
+	}
+	if _, _, err := gcp.ReadGeneration(g, "doc.txt", gens[2]+1000); !errors.Is(err, models.ErrNotFound) {
+		t.Errorf("an unknown generation should be ErrNotFound, got %v", err)
+	}
+
+	list, err := gcp.ListWithOptions(g, "", &ListOptions{Versions: true})
+	if err != nil {
+		t.Fatalf("ListWithOptions: %v", err)
+	}
+	if len(list) != 3 {
+		t.Errorf("every generation should be listed: %v", list)
+	}
+	for _, gen := range gens {
+		if mdata, ok := list[fmt.Sprintf("doc.txt#%d", gen)]; !ok || mdata.Generation != gen {
+			t.Errorf("generation %d missing from %v", gen, list)
+		}
+	}
+	if list, err := gcp.ListWithOptions(g, "", nil); err != nil || len(list) != 1 || list["doc.txt"] == nil {
+		t.Errorf("without Versions only the live object is listed: %v, %v", list, err)
+	}
+}
+
+func TestWriteStorageClass(t *testing.T) {
+	gcp, g, f := newTestGCPFS(t, &models.GCPFSConfig{DefaultStorageClass: "NEARLINE"})
+
+	mdata, err := gcp.Write(g, []byte("cold"), "archive/a.txt", &models.FileMetaData{StorageClass: "Coldline"})
+	if err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	if mdata.StorageClass != "COLDLINE" || f.object(testBucket, "tenants/acme/archive/a.txt").attrs.StorageClass != "COLDLINE" {
+		t.Errorf("the per-write class should be stored, got %q", mdata.StorageClass)
+	}
+	if mdata, err := gcp.Write(g, []byte("warm"), "b.txt", nil); err != nil || mdata.StorageClass != "NEARLINE" {
+		t.Errorf("the default class should be used: %+v, %v", mdata, err)
+	}
+	list, err := gcp.List(g, "archive/")
+	if err != nil || list["archive/a.txt"].StorageClass != "COLDLINE" {
+		t.Errorf("List should report the class: %v, %v", list, err)
+	}
+	if _, err := gcp.Write(g, []byte("x"), "c.txt", &models.FileMetaData{StorageClass: "FROZEN"}); err == nil {
+		t.Errorf("an unknown class should be rejected")
+	}
+	conf := &models.GCPFSConfig{BucketName: testBucket, FS: &models.FS{ParentFolder: "p"}, DefaultStorageClass: "glacier"}
+	if err := conf.Validate(); err == nil {
+		t.Errorf("Validate should reject an unknown DefaultStorageClass")
+	}
+}
+
+func TestUploadChunkSize(t *testing.T) {
+	gcp, g, f := newTestGCPFS(t, &models.GCPFSConfig{UploadChunkSize: 1 << 20})
+	data := bytes.Repeat([]byte("0123456789"), 2000000)
+	mdata, err := gcp.Write(g, data, "big.bin", nil)
+	if err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	if mdata.Size != int64(len(data)) || len(f.object(testBucket, "tenants/acme/big.bin").data) != len(data) {
+		t.Errorf("uploaded size %d, want %d", mdata.Size, len(data))
+	}
+	if n := f.count("POST upload"); n < 20 {
+		t.Errorf("expected a resumable upload in 1 MiB chunks, got %d upload requests", n)
+	}
+
+	for _, size := range []int{-1, 1000, 3 << 17} {
+		conf := &models.GCPFSConfig{BucketName: testBucket, FS: &models.FS{ParentFolder: "p"}, UploadChunkSize: size}
+		if err := conf.Validate(); err == nil {
+			t.Errorf("Validate should reject UploadChunkSize %d", size)
+		}
+	}
+}
+
+func TestWriteCacheControlAndDisposition(t *testing.T) {
+	gcp, g, f := newTestGCPFS(t, nil)
+	const disposition = `attachment; filename="report.pdf"`
+	_, err := gcp.Write(g, []byte("%PDF-1.4"), "report.pdf", &models.FileMetaData{ContentDisposition: disposition, CacheControl: "public, max-age=3600"})
+	if err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	mdata, err := gcp.Stat(g, "report.pdf")
+	if err != nil {
+		t.Fatalf("Stat: %v", err)
+	}
+	if mdata.ContentDisposition != disposition || mdata.CacheControl != "public, max-age=3600" {
+		t.Errorf("headers did not round-trip: %+v", mdata)
+	}
+
+	if _, err := gcp.Write(g, []byte("plain"), "plain.txt", nil); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	if obj := f.object(testBucket, "tenants/acme/plain.txt"); obj.attrs.CacheControl != "" || obj.attrs.ContentDisposition != "" {
+		t.Errorf("empty values should leave the defaults: %+v", obj.attrs)
+	}
+}
+
+func TestHolds(t *testing.T) {
+	gcp, g, f := newTestGCPFS(t, nil)
+	f.put(testBucket, "tenants/acme/audit.log", []byte("x"), nil)
+
+	for name, set := range map[string]func(hold bool) error{
+		"temporary":   func(hold bool) error { return gcp.SetHold(g, "audit.log", hold) },
+		"event-based": func(hold bool) error { return gcp.SetEventBasedHold(g, "audit.log", hold) },
+	} {
+		if err := set(true); err != nil {
+			t.Fatalf("%s: placing the hold: %v", name, err)
+		}
+		mdata, err := gcp.Stat(g, "audit.log")
+		if err != nil || mdata.TemporaryHold == mdata.EventBasedHold {
+			t.Errorf("%s: Stat after placing the hold: %+v, %v", name, mdata, err)
+		}
+		if err := gcp.Delete(g, "audit.log"); !errors.Is(err, models.ErrObjectHeld) {
+			t.Errorf("%s: Delete while held: expected ErrObjectHeld, got %v", name, err)
+		}
+		if f.object(testBucket, "tenants/acme/audit.log") == nil {
+			t.Fatalf("%s: a held object was deleted", name)
+		}
+		if err := set(false); err != nil {
+			t.Fatalf("%s: releasing the hold: %v", name, err)
+		}
+		if mdata, err := gcp.Stat(g, "audit.log"); err != nil || mdata.TemporaryHold || mdata.EventBasedHold {
+			t.Errorf("%s: Stat after releasing the hold: %+v, %v", name, mdata, err)
+		}
+	}
+	if err := gcp.Delete(g, "audit.log"); err != nil {
+		t.Errorf("Delete after releasing the holds: %v", err)
+	}
+	if err := gcp.SetHold(g, "audit.log", true); !errors.Is(err, models.ErrNotFound) {
+		t.Errorf("SetHold on a missing object: expected ErrNotFound, got %v", err)
+	}
+}
+
+func TestSentinelErrors(t *testing.T) {
+	gcp, g, f := newTestGCPFS(t, nil)
+	f.put(testBucket, "tenants/acme/exists.txt", []byte("x"), nil)
+	f.put(testBucket, "tenants/acme/other.txt", []byte("y"), nil)
+	f.put(testBucket, "tenants/acme/secret.txt", []byte("z"), nil)
+	f.deny(testBucket, "tenants/acme/secret.txt")
+
+	for _, tc := range []struct {
+		name string
+		err  func() error
+		want error
+	}{
+		{"Read missing", func() error { _, _, err := gcp.Read(g, "missing.txt"); return err }, models.ErrNotFound},
+		{"Stat missing", func() error { _, err := gcp.Stat(g, "missing.txt"); return err }, models.ErrNotFound},
+		{"Delete missing", func() error { return gcp.Delete(g, "missing.txt") }, models.ErrNotFound},
+		{"Read denied", func() error { _, _, err := gcp.Read(g, "secret.txt"); return err }, models.ErrPermissionDenied},
+		{"Delete denied", func() error { return gcp.Delete(g, "secret.txt") }, models.ErrPermissionDenied},
+		{"Write denied", func() error { _, err := gcp.Write(g, []byte("w"), "secret.txt", nil); return err }, models.ErrPermissionDenied},
+		{"Copy onto existing", func() error { return gcp.Copy(g, "exists.txt", "other.txt") }, models.ErrAlreadyExists},
+		{"Copy denied", func() error { return gcp.CopyWithOptions(g, "secret.txt", "copy.txt", &CopyOptions{Overwrite: true}) }, models.ErrPermissionDenied},
+		{"Write stale generation", func() error {
+			_, err := gcp.WriteIfGeneration(g, []byte("w"), "exists.txt", 1, nil)
+			return err
+		}, models.ErrPreconditionFailed},
+		{"Exists denied", func() error { _, err := gcp.Exists(g, "secret.txt"); return err }, models.ErrPermissionDenied},
+		{"ReadStream denied", func() error { _, _, err := gcp.ReadStream(g, "secret.txt"); return err }, models.ErrPermissionDenied},
+		{"OpenLineReader denied", func() error { _, _, err := gcp.OpenLineReader(g, "secret.txt"); return err }, models.ErrPermissionDenied},
+		{"Tail denied", func() error { _, _, err := gcp.Tail(g, "secret.txt", 1); return err }, models.ErrPermissionDenied},
+		{"ReadRangeVerified denied", func() error { _, _, err := gcp.ReadRangeVerified(g, "secret.txt", 0, -1); return err }, models.ErrPermissionDenied},
+		{"WriteStream denied", func() error {
+			_, err := gcp.WriteStream(g, strings.NewReader("w"), "secret.txt", nil)
+			return err
+		}, models.ErrPermissionDenied},
+		{"Update denied", func() error { _, err := gcp.Update(g, []byte("w"), "secret.txt", nil); return err }, models.ErrPermissionDenied},
+	} {
+		if err := tc.err(); !errors.Is(err, tc.want) {
+			t.Errorf("%s: got %v, want errors.Is(%v)", tc.name, err, tc.want)
+		}
+	}
+
+	// The underlying SDK error is still in the chain.
+	if _, _, err := gcp.Read(g, "missing.txt"); !errors.Is(err, storage.ErrObjectNotExist) {
+		t.Errorf("Read missing: %v should still match storage.ErrObjectNotExist", err)
+	}
+
+	f.deny(testBucket, "*")
+	if _, err := gcp.ListParallel(g, "", 2); !errors.Is(err, models.ErrPermissionDenied) {
+		t.Errorf("ListParallel denied: got %v, want errors.Is(%v)", err, models.ErrPermissionDenied)
+	}
+	if _, err := gcp.StorageClassBreakdown(g, ""); !errors.Is(err, models.ErrPermissionDenied) {
+		t.Errorf("StorageClassBreakdown denied: got %v, want errors.Is(%v)", err, models.ErrPermissionDenied)
+	}
+}
+
+func TestCustomerSuppliedEncryptionKey(t *testing.T) {
+	gcp, g, f := newTestGCPFS(t, nil)
+	key := bytes.Repeat([]byte{7}, 32)
+	if _, err := gcp.WriteWithOptions(g, []byte("secret"), "csek.txt", nil, &WriteOptions{EncryptionKey: key}); err != nil {
+		t.Fatalf("WriteWithOptions: %v", err)
+	}
+	if f.object(testBucket, "tenants/acme/csek.txt").keySHA == "" {
+		t.Fatalf("the object was not uploaded with the key")
+	}
+
+	if _, _, err := gcp.Read(g, "csek.txt"); err == nil || !strings.Contains(err.Error(), "ReadOptions.EncryptionKey") {
+		t.Errorf("Read without the key: expected an error naming ReadOptions.EncryptionKey, got %v", err)
+	}
+	data, _, err := gcp.ReadWithOptions(g, "csek.txt", &ReadOptions{EncryptionKey: key})
+	if err != nil || string(data) != "secret" {
+		t.Errorf("ReadWithOptions with the key: %q, %v", data, err)
+	}
+	if _, _, err := gcp.ReadWithOptions(g, "csek.txt", &ReadOptions{EncryptionKey: bytes.Repeat([]byte{8}, 32)}); !errors.Is(err, models.ErrPermissionDenied) {
+		t.Errorf("ReadWithOptions with the wrong key: expected ErrPermissionDenied, got %v", err)
+	}
+
+	if _, err := gcp.WriteWithOptions(g, []byte("x"), "short.txt", nil, &WriteOptions{EncryptionKey: key[:31]}); err == nil {
+		t.Errorf("a 31-byte key should be rejected")
+	}
+	if _, _, err := gcp.ReadWithOptions(g, "csek.txt", &ReadOptions{EncryptionKey: key[:31]}); err == nil {
+		t.Errorf("a 31-byte key should be rejected on read")
+	}
+
+	// The other read methods take the key through WithEncryptionKey.
+	stored := f.object(testBucket, "tenants/acme/csek.txt")
+	keyed := g.With(WithEncryptionKey(key))
+	reads := map[string]func(g *GCPFS) (string, error){
+		"ReadRange": func(g *GCPFS) (string, error) {
+			data, _, err := gcp.ReadRange(g, "csek.txt", 1, 3)
+			return string(data), err
+		},
+		"ReadParallel": func(g *GCPFS) (string, error) {
+			data, _, err := gcp.ReadParallel(g, "csek.txt", 2, 2)
+			return string(data), err
+		},
+		"Tail": func(g *GCPFS) (string, error) { data, _, err := gcp.Tail(g, "csek.txt", 3); return string(data), err },
+		"ReadGeneration": func(g *GCPFS) (string, error) {
+			data, _, err := gcp.ReadGeneration(g, "csek.txt", stored.attrs.Generation)
+			return string(data), err
+		},
+		"ReadRangeVerified": func(g *GCPFS) (string, error) {
+			data, _, err := gcp.ReadRangeVerified(g, "csek.txt", 0, -1)
+			return string(data), err
+		},
+		"OpenLineReader": func(g *GCPFS) (string, error) {
+			r, c, err := gcp.OpenLineReader(g, "csek.txt")
+			if err != nil {
+				return "", err
+			}
+			defer c.Close()
+			line, err := r.ReadString('\n')
+			if err == io.EOF {
+				err = nil
+			}
+			return line, err
+		},
+		"VerifyIntegrity": func(g *GCPFS) (string, error) { _, err := gcp.VerifyIntegrity(g, "csek.txt"); return "ok", err },
+	}
+	want := map[string]string{"ReadRange": "ecr", "Tail": "ret", "VerifyIntegrity": "ok"}
+	for name, read := range reads {
+		if _, err := read(g); err == nil || !strings.Contains(err.Error(), "WithEncryptionKey") {
+			t.Errorf("%s without the key: expected an error naming WithEncryptionKey, got %v", name, err)
+		}
+		w, ok := want[name]
+		if !ok {
+			w = "secret"
+		}
+		if got, err := read(keyed); err != nil || got != w {
+			t.Errorf("%s with the key: %q, %v", name, got, err)
+		}
+	}
+	// GCS only reports the hashes of such an object when the key comes with the metadata request,
+	// so the checksums compared above must have been fetched with it.
+	if _, mdata, err := gcp.ReadRange(keyed, "csek.txt", 0, 1); err != nil || mdata.Crc32c == "" || mdata.Md5Hash == "" {
+		t.Errorf("ReadRange with the key should report the hashes: %+v, %v", mdata, err)
+	}
+}
+
+func TestSoftDeleteAndRestore(t *testing.T) {
+	gcp, g, f := newTestGCPFS(t, nil)
+	f.put(testBucket, "tenants/acme/docs/a.txt", []byte("keep me"), map[string]string{"k": "v"})
+
+	if err := gcp.SoftDelete(g, "docs/a.txt"); err != nil {
+		t.Fatalf("SoftDelete: %v", err)
+	}
+	if _, err := gcp.Stat(g, "docs/a.txt"); !errors.Is(err, models.ErrNotFound) {
+		t.Errorf("Stat of the original path after SoftDelete: expected ErrNotFound, got %v", err)
+	}
+	trash, err := gcp.List(g, models.DefaultTrashPrefix)
+	if err != nil || len(trash) != 1 {
+		t.Fatalf("List of the trash: %v, %v", trash, err)
+	}
+	var trashedPath string
+	for name, mdata := range trash {
+		trashedPath = name
+		if !strings.HasSuffix(name, "/docs/a.txt") || mdata.UserMetaData[TrashedFromKey] != "docs/a.txt" || mdata.UserMetaData["k"] != "v" {
+			t.Errorf("trashed object %s: %v", name, mdata.UserMetaData)
+		}
+		if at, err := time.Parse(time.RFC3339, mdata.UserMetaData[TrashedAtKey]); err != nil || time.Since(at) > time.Minute {
+			t.Errorf("%s = %q", TrashedAtKey, mdata.UserMetaData[TrashedAtKey])
+		}
+	}
+	if err := gcp.SoftDelete(g, trashedPath); err == nil {
+		t.Errorf("SoftDelete of a trashed object should fail")
+	}
+	if err := gcp.Restore(g, "docs/a.txt", ""); err == nil {
+		t.Errorf("Restore of an object outside the trash should fail")
+	}
+
+	if err := gcp.Restore(g, trashedPath, ""); err != nil {
+		t.Fatalf("Restore: %v", err)
+	}
+	data, mdata, err := gcp.Read(g, "docs/a.txt")
+	if err != nil || string(data) != "keep me" {
+		t.Fatalf("Read after Restore: %q, %v", data, err)
+	}
+	if _, ok := mdata.UserMetaData[TrashedFromKey]; ok || mdata.UserMetaData["k"] != "v" {
+		t.Errorf("metadata after Restore: %v", mdata.UserMetaData)
+	}
+	if trash, _ := gcp.List(g, models.DefaultTrashPrefix); len(trash) != 0 {
+		t.Errorf("the trash still holds %v", trash)
+	}
+
+	if err := gcp.SoftDelete(g, "docs/a.txt"); err != nil {
+		t.Fatalf("second SoftDelete: %v", err)
+	}
+	trash, _ = gcp.List(g, models.DefaultTrashPrefix)
+	for name := range trash {
+		if err := gcp.Restore(g, name, "restored/a.txt"); err != nil {
+			t.Fatalf("Restore to another path: %v", err)
+		}
+	}
+	if ok, _ := gcp.Exists(g, "restored/a.txt"); !ok {
+		t.Errorf("restored/a.txt was not restored")
+	}
+}
+
+func TestSoftDeleteHeldObject(t *testing.T) {
+	gcp, g, f := newTestGCPFS(t, nil)
+	f.put(testBucket, "tenants/acme/docs/a.txt", []byte("keep me"), nil)
+	for _, hold := range []func(*GCPFS, string, bool) error{gcp.SetHold, gcp.SetEventBasedHold} {
+		if err := hold(g, "docs/a.txt", true); err != nil {
+			t.Fatalf("hold: %v", err)
+		}
+		for attempt := 0; attempt < 2; attempt++ {
+			if err := gcp.SoftDelete(g, "docs/a.txt"); !errors.Is(err, models.ErrObjectHeld) {
+				t.Errorf("SoftDelete of a held object: expected ErrObjectHeld, got %v", err)
+			}
+		}
+		if names := f.objectNames(testBucket); len(names) != 1 || names[0] != "tenants/acme/docs/a.txt" {
+			t.Errorf("objects after SoftDelete of a held object: %v", names)
+		}
+		if err := hold(g, "docs/a.txt", false); err != nil {
+			t.Fatalf("release: %v", err)
+		}
+	}
+
+	// The original cannot be deleted, e.g. under a retention policy: the trash copy goes again.
+	g.client = f.client(func(next http.RoundTripper) http.RoundTripper {
+		return &failingTransport{next: next, status: http.StatusForbidden, n: 1, only: "/storage/v1/b/" + testBucket + "/o/tenants/acme/docs/a.txt", method: http.MethodDelete}
+	})
+	if err := gcp.SoftDelete(g, "docs/a.txt"); !errors.Is(err, models.ErrPermissionDenied) {
+		t.Errorf("SoftDelete with a failing delete: expected ErrPermissionDenied, got %v", err)
+	}
+	if names := f.objectNames(testBucket); len(names) != 1 || names[0] != "tenants/acme/docs/a.txt" {
+		t.Errorf("objects after the failed SoftDelete: %v", names)
+	}
+}
+func TestMaxOpsPerSecond(t *testing.T) {
+	if testing.Short() {
+		t.Skip("takes about 10s")
+	}
+	f := newFakeGCS(t)
+	f.createBucket(testBucket)
+	gcp := &GCPController{}
+	g, err := gcp.NewGCPStorageWithClient(&models.GCPFSConfig{BucketName: testBucket, MaxOpsPerSecond: 10, FS: &models.FS{ParentFolder: "tenants/acme"}}, f.client(nil))
+	if err != nil {
+		t.Fatalf("NewGCPStorageWithClient: %v", err)
+	}
+
+	start := time.Now()
+	var wg sync.WaitGroup
+	errs := make(chan error, 100)
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if _, err := gcp.Write(g, []byte("x"), fmt.Sprintf("limited/%d.txt", i), nil); err != nil {
+				errs <- err
+			}
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Errorf("Write: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < 9*time.Second {
+		t.Errorf("100 writes at 10/s took %v, want at least 9s", elapsed)
+	}
+	if n := f.count("POST upload"); n != 100 {
+		t.Errorf("%d uploads, want 100", n)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := gcp.Delete(g.With(WithContext(ctx)), "limited/0.txt"); !errors.Is(err, context.Canceled) {
+		t.Errorf("Delete under a cancelled context: expected context.Canceled, got %v", err)
+	}
+}
+
+func TestMoveLeavesOneObject(t *testing.T) {
+	gcp, g, f := newTestGCPFS(t, nil)
+	f.put(testBucket, "tenants/acme/src.txt", []byte("content"), map[string]string{"k": "v"})
+	if err := gcp.Move(g, "src.txt", "dst.txt"); err != nil {
+		t.Fatalf("Move: %v", err)
+	}
+	if names := f.objectNames(testBucket); len(names) != 1 || names[0] != "tenants/acme/dst.txt" {
+		t.Errorf("objects after Move: %v", names)
+	}
+
+	// The source cannot be deleted: the copy must be removed again.
+	g.client = f.client(func(next http.RoundTripper) http.RoundTripper {
+		return &failingTransport{next: next, status: http.StatusForbidden, n: 1, only: "/storage/v1/b/" + testBucket + "/o/tenants/acme/dst.txt", method: http.MethodDelete}
+	})
+	if err := gcp.Move(g, "dst.txt", "again.txt"); !errors.Is(err, models.ErrPermissionDenied) {
+		t.Errorf("Move with a failing delete: expected ErrPermissionDenied, got %v", err)
+	}
+	if names := f.objectNames(testBucket); len(names) != 1 || names[0] != "tenants/acme/dst.txt" {
+		t.Errorf("objects after the failed Move: %v", names)
+	}
+
+	// With Overwrite the copy replaced an object that deleting it would lose, so both are kept.
+	f.put(testBucket, "tenants/acme/taken.txt", []byte("earlier"), nil)
+	g.client = f.client(func(next http.RoundTripper) http.RoundTripper {
+		return &failingTransport{next: next, status: http.StatusForbidden, n: 1, only: "/storage/v1/b/" + testBucket + "/o/tenants/acme/dst.txt", method: http.MethodDelete}
+	})
+	err := gcp.MoveWithOptions(g, "dst.txt", "taken.txt", &CopyOptions{Overwrite: true})
+	if !errors.Is(err, models.ErrPermissionDenied) || !strings.Contains(err.Error(), "tenants/acme/taken.txt") {
+		t.Errorf("Move with Overwrite and a failing delete: expected ErrPermissionDenied naming the copy, got %v", err)
+	}
+	if o := f.object(testBucket, "tenants/acme/taken.txt"); o == nil || string(o.data) != "content" {
+		t.Errorf("the copy over taken.txt was removed: %+v", o)
+	}
+	if o := f.object(testBucket, "tenants/acme/dst.txt"); o == nil || string(o.data) != "content" {
+		t.Errorf("the source of the failed move is gone: %+v", o)
+	}
+	g.client = f.client(nil)
+	if err := gcp.Delete(g, "taken.txt"); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+
+	if err := gcp.SetHold(g, "dst.txt", true); err != nil {
+		t.Fatalf("SetHold: %v", err)
+	}
+	if err := gcp.Move(g, "dst.txt", "held.txt"); !errors.Is(err, models.ErrObjectHeld) {
+		t.Errorf("Move of a held object: expected ErrObjectHeld, got %v", err)
+	}
+	if _, err := gcp.Stat(g, "held.txt"); !errors.Is(err, models.ErrNotFound) {
+		t.Errorf("a held object was copied: %v", err)
+	}
+}
+
+func TestSanitizePath(t *testing.T) {
+	for in, want := range map[string]string{
+		"a.txt":           "a.txt",
+		"docs/2024/a.txt": "docs/2024/a.txt",
+		"docs//a.txt":     "docs/a.txt",
+		"docs/./a.txt":    "docs/a.txt",
+		"docs/a/":         "docs/a",
+		"..a/b..":         "..a/b..",
+	} {
+		if got, err := sanitizePath(in); err != nil || got != want {
+			t.Errorf("sanitizePath(%q) = %q, %v, want %q", in, got, err, want)
+		}
+	}
+	for _, in := range []string{"", "../", "./", "/", ".", "..", "../otherTenant/secret", "a/../../b", "docs/../a.txt", "/etc/passwd", "//a.txt"} {
+		if got, err := sanitizePath(in); !errors.Is(err, models.ErrInvalidPath) {
+			t.Errorf("sanitizePath(%q) = %q, %v, want ErrInvalidPath", in, got, err)
+		}
+	}
+}
+
+func TestPathsCannotEscapeParentFolder(t *testing.T) {
+	gcp, g, f := newTestGCPFS(t, nil)
+	f.put(testBucket, "tenants/other/secret.txt", []byte("secret"), nil)
+	f.put(testBucket, "tenants/acme/docs/a.txt", []byte("mine"), nil)
+
+	for _, p := range []string{"../other/secret.txt", "docs/../../other/secret.txt", "/tenants/other/secret.txt", "", "./"} {
+		if _, _, err := gcp.Read(g, p); !errors.Is(err, models.ErrInvalidPath) {
+			t.Errorf("Read(%q): expected ErrInvalidPath, got %v", p, err)
+		}
+		if _, err := gcp.Write(g, []byte("x"), p, nil); !errors.Is(err, models.ErrInvalidPath) {
+			t.Errorf("Write(%q): expected ErrInvalidPath, got %v", p, err)
+		}
+		if _, err := gcp.Stat(g, p); !errors.Is(err, models.ErrInvalidPath) {
+			t.Errorf("Stat(%q): expected ErrInvalidPath, got %v", p, err)
+		}
+		if err := gcp.Delete(g, p); !errors.Is(err, models.ErrInvalidPath) {
+			t.Errorf("Delete(%q): expected ErrInvalidPath, got %v", p, err)
+		}
+		if err := gcp.Copy(g, "docs/a.txt", p); !errors.Is(err, models.ErrInvalidPath) {
+			t.Errorf("Copy to %q: expected ErrInvalidPath, got %v", p, err)
+		}
+	}
+	for _, prefix := range []string{"../", "../other/", "/tenants/"} {
+		if _, err := gcp.List(g, prefix); !errors.Is(err, models.ErrInvalidPath) {
+			t.Errorf("List(%q): expected ErrInvalidPath, got %v", prefix, err)
+		}
+	}
+	if got := string(f.object(testBucket, "tenants/other/secret.txt").data); got != "secret" {
+		t.Errorf("the other tenant's object holds %q", got)
+	}
+	if names := f.objectNames(testBucket); len(names) != 2 {
+		t.Errorf("objects were written outside the tenant: %v", names)
+	}
+
+	if _, err := gcp.Write(g, []byte("nested"), "docs//2024/./report.txt", nil); err != nil {
+		t.Fatalf("Write of a nested path: %v", err)
+	}
+	if data, _, err := gcp.Read(g, "docs/2024/report.txt"); err != nil || string(data) != "nested" {
+		t.Errorf("Read of the normalized path: %q, %v", data, err)
+	}
+	if list, err := gcp.List(g, "docs/"); err != nil || len(list) != 2 {
+		t.Errorf("List(docs/): %v, %v", list, err)
+	}
+}
+
+func TestReadWriteJSON(t *testing.T) {
+	type report struct {
+		Name  string   `json:"name"`
+		Count int      `json:"count"`
+		Tags  []string `json:"tags"`
+	}
+	_, g, f := newTestGCPFS(t, nil)
+	in := report{Name: "daily", Count: 3, Tags: []string{"a", "b"}}
+	mdata, err := WriteJSON(g, "reports/daily.json", in, &models.FileMetaData{UserMetaData: map[string]string{"k": "v"}})
+	if err != nil {
+		t.Fatalf("WriteJSON: %v", err)
+	}
+	if mdata.ContentType != JSONContentType {
+		t.Errorf("ContentType = %q, want %q", mdata.ContentType, JSONContentType)
+	}
+	if o := f.object(testBucket, "tenants/acme/reports/daily.json"); o.attrs.ContentType != JSONContentType || string(o.data) != `{"name":"daily","count":3,"tags":["a","b"]}` {
+		t.Errorf("stored %q as %q", o.data, o.attrs.ContentType)
+	}
+
+	out, mdata, err := ReadJSON[report](g, "reports/daily.json")
+	if err != nil {
+		t.Fatalf("ReadJSON: %v", err)
+	}
+	if out.Name != in.Name || out.Count != in.Count || strings.Join(out.Tags, ",") != "a,b" || mdata.UserMetaData["k"] != "v" {
+		t.Errorf("ReadJSON = %+v, %v", out, mdata.UserMetaData)
+	}
+
+	f.put(testBucket, "tenants/acme/reports/bad.json", []byte(`{"name": 1}`), nil)
+	var typeErr *json.UnmarshalTypeError
+	if _, _, err := ReadJSON[report](g, "reports/bad.json"); !errors.As(err, &typeErr) {
+		t.Errorf("ReadJSON of mistyped content: expected a *json.UnmarshalTypeError, got %v", err)
+	}
+	if _, _, err := ReadJSON[report](g, "reports/missing.json"); !errors.Is(err, models.ErrNotFound) {
+		t.Errorf("ReadJSON of a missing object: expected ErrNotFound, got %v", err)
+	}
+	var unsupported *json.UnsupportedTypeError
+	if _, err := WriteJSON(g, "reports/func.json", func() {}, nil); !errors.As(err, &unsupported) {
+		t.Errorf("WriteJSON of a func: expected a *json.UnsupportedTypeError, got %v", err)
+	}
+}
+
+func TestCustomTime(t *testing.T) {
+	gcp, g, f := newTestGCPFS(t, nil)
+	expiresFrom := time.Date(2026, 3, 1, 12, 30, 0, 0, time.UTC)
+	if _, err := gcp.Write(g, []byte("x"), "invoice.pdf", &models.FileMetaData{CustomTime: expiresFrom, UserMetaData: map[string]string{"draft": "yes"}}); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	mdata, err := gcp.Stat(g, "invoice.pdf")
+	if err != nil {
+		t.Fatalf("Stat: %v", err)
+	}
+	if !mdata.CustomTime.Equal(expiresFrom) {
+		t.Errorf("CustomTime = %v, want %v", mdata.CustomTime, expiresFrom)
+	}
+
+	// Rewriting the object to drop a metadata key keeps the custom time.
+	if _, err := gcp.UpdateMetadata(g, "invoice.pdf", nil, []string{"draft"}); err != nil {
+		t.Fatalf("UpdateMetadata: %v", err)
+	}
+	if o := f.object(testBucket, "tenants/acme/invoice.pdf"); o.attrs.CustomTime != expiresFrom.Format(time.RFC3339) {
+		t.Errorf("CustomTime after rewriting = %q, want %v", o.attrs.CustomTime, expiresFrom)
+	}
+	later := expiresFrom.Add(48 * time.Hour)
+	mdata, err = gcp.SetCustomTime(g, "invoice.pdf", later)
+	if err != nil {
+		t.Fatalf("SetCustomTime: %v", err)
+	}
+	if !mdata.CustomTime.Equal(later) || f.object(testBucket, "tenants/acme/invoice.pdf").attrs.CustomTime != later.Format(time.RFC3339) {
+		t.Errorf("CustomTime after SetCustomTime = %v, want %v", mdata.CustomTime, later)
+	}
+
+	if _, err := gcp.SetCustomTime(g, "invoice.pdf", time.Time{}); err == nil {
+		t.Errorf("clearing the custom time should be rejected")
+	}
+	if _, err := gcp.SetCustomTime(g, "missing.pdf", later); !errors.Is(err, models.ErrNotFound) {
+		t.Errorf("expected ErrNotFound, got %v", err)
+	}
+}
+
+func TestStatBatch(t *testing.T) {
+	gcp, g, f := newTestGCPFS(t, nil)
+	var paths []string
+	for i := 0; i < 20; i++ {
+		p := fmt.Sprintf("docs/%02d.txt", i)
+		paths = append(paths, p)
+		if i%2 == 0 {
+			f.put(testBucket, "tenants/acme/"+p, []byte(p), nil)
+		}
+	}
+	f.deny(testBucket, "tenants/acme/docs/04.txt")
+	paths = append(paths, "docs/00.txt", "../escape.txt")
+
+	found, failed := gcp.StatBatch(g, paths, 4)
+	if len(found) != 9 || len(failed) != 12 {
+		t.Fatalf("found %d and failed %d path(s), want 9 and 12: %v", len(found), len(failed), failed)
+	}
+	for i := 0; i < 20; i++ {
+		p := fmt.Sprintf("docs/%02d.txt", i)
+		switch {
+		case i == 4:
+			if !errors.Is(failed[p], models.ErrPermissionDenied) {
+				t.Errorf("%s: expected ErrPermissionDenied, got %v", p, failed[p])
+			}
+		case i%2 == 0:
+			if found[p] == nil || found[p].Size != int64(len(p)) {
+				t.Errorf("%s: metadata = %+v", p, found[p])
+			}
+		default:
+			if !errors.Is(failed[p], models.ErrNotFound) {
+				t.Errorf("%s: expected ErrNotFound, got %v", p, failed[p])
+			}
+		}
+	}
+	if !errors.Is(failed["../escape.txt"], models.ErrInvalidPath) {
+		t.Errorf("expected ErrInvalidPath, got %v", failed["../escape.txt"])
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	before := f.count("GET json")
+	found, failed = gcp.StatBatch(g.With(WithContext(ctx)), []string{"docs/00.txt", "docs/01.txt"}, 1)
+	if len(found) != 0 || !errors.Is(failed["docs/00.txt"], context.Canceled) || !errors.Is(failed["docs/01.txt"], context.Canceled) {
+		t.Errorf("a cancelled context should stop new lookups, got %v, %v", found, failed)
+	}
+	if n := f.count("GET json") - before; n != 0 {
+		t.Errorf("%d request(s) made after cancellation", n)
+	}
+}
+
+func TestAppendData(t *testing.T) {
+	gcp, g, f := newTestGCPFS(t, nil)
+	for _, chunk := range []string{"line 1\n", "line 2\n", "line 3\n"} {
+		if _, err := gcp.AppendData(g, []byte(chunk), "logs/app.log"); err != nil {
+			t.Fatalf("AppendData(%q): %v", chunk, err)
+		}
+	}
+	o := f.object(testBucket, "tenants/acme/logs/app.log")
+	if string(o.data) != "line 1\nline 2\nline 3\n" {
+		t.Errorf("content = %q", o.data)
+	}
+	if o.attrs.ContentType != "text/x-log; charset=utf-8" || o.attrs.Md5Hash != "" {
+		t.Errorf("content type %q, MD5 %q", o.attrs.ContentType, o.attrs.Md5Hash)
+	}
+	if names := f.objectNames(testBucket); len(names) != 1 {
+		t.Errorf("temporary objects were left behind: %v", names)
+	}
+
+	// Another writer replaces the object while the data is being uploaded.
+	var raced int32
+	g.client = f.client(func(next http.RoundTripper) http.RoundTripper {
+		return &hookTransport{next: next, hook: func(r *http.Request) {
+			if r.Method == http.MethodPost && strings.HasPrefix(r.URL.Path, "/upload/") && atomic.AddInt32(&raced, 1) == 1 {
+				f.put(testBucket, "tenants/acme/logs/app.log", []byte("rotated\n"), nil)
+			}
+		}}
+	})
+	if _, err := gcp.AppendData(g, []byte("line 4\n"), "logs/app.log"); !errors.Is(err, models.ErrPreconditionFailed) {
+		t.Errorf("expected ErrPreconditionFailed, got %v", err)
+	}
+	if o := f.object(testBucket, "tenants/acme/logs/app.log"); string(o.data) != "rotated\n" {
+		t.Errorf("content after the conflict = %q", o.data)
+	}
+	if names := f.objectNames(testBucket); len(names) != 1 {
+		t.Errorf("temporary objects were left behind: %v", names)
+	}
+
+	if _, err := gcp.AppendData(g, nil, "logs/app.log"); err == nil {
+		t.Errorf("appending nothing should be rejected")
+	}
+}
+
+// hookTransport calls hook before passing each request on.
+type hookTransport struct {
+	next http.RoundTripper
+	hook func(r *http.Request)
+}
+
+func (t *hookTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	t.hook(r)
+	return t.next.RoundTrip(r)
+}
+
+func TestTransformPrefix(t *testing.T) {
+	gcp, g, f := newTestGCPFS(t, nil)
+	f.put(testBucket, "tenants/acme/src/", nil, nil)
+	f.put(testBucket, "tenants/acme/src/sub/", nil, nil)
+	f.put(testBucket, "tenants/acme/src/a.txt", []byte("a"), map[string]string{"k": "v"})
+	f.put(testBucket, "tenants/acme/src/sub/b.txt", []byte("b"), nil)
+	f.put(testBucket, "tenants/acme/src/skip.txt", []byte("s"), nil)
+	f.put(testBucket, "tenants/acme/src2/c.txt", []byte("c"), nil)
+
+	var idle int32
+	n, err := gcp.TransformPrefix(g, "src", "dst", func(name string, data []byte) ([]byte, error) {
+		if name == "skip.txt" {
+			return nil, SkipObject
+		}
+		if gcp.Stats(g).InFlight == 0 {
+			atomic.AddInt32(&idle, 1)
+		}
+		return bytes.ToUpper(data), nil
+	})
+	if err != nil || n != 2 {
+		t.Fatalf("TransformPrefix: %d, %v", n, err)
+	}
+	if idle != 0 {
+		t.Errorf("TransformPrefix is not counted as in flight while it runs")
+	}
+	if o := f.object(testBucket, "tenants/acme/dst/a.txt"); o == nil || string(o.data) != "A" || o.attrs.Metadata["k"] != "v" {
+		t.Errorf("dst/a.txt = %+v", o)
+	}
+	if o := f.object(testBucket, "tenants/acme/dst/sub/b.txt"); o == nil || string(o.data) != "B" {
+		t.Errorf("dst/sub/b.txt = %+v", o)
+	}
+	if o := f.object(testBucket, "tenants/acme/dst/2/c.txt"); o != nil {
+		t.Errorf("sibling src2/c.txt was transformed as dst/2/c.txt")
+	}
+	if s := gcp.Stats(g); s.InFlight != 0 {
+		t.Errorf("operation still in flight after TransformPrefix: %+v", s)
+	}
+}
+
+// lateTransport answers every ranged request in full but only after delay, ignoring its
+// cancellation, like a read completing just as its operation's context ends.
+type lateTransport struct {
+	next  http.RoundTripper
+	delay time.Duration
+}
+
+func (t *lateTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	if r.Header.Get("Range") == "" {
+		return t.next.RoundTrip(r)
+	}
+	resp, err := t.next.RoundTrip(r.WithContext(context.Background()))
+	if err != nil {
+		return nil, err
+	}
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	time.Sleep(t.delay)
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+	return resp, nil
+}
+
+func TestReadParallelTimeout(t *testing.T) {
+	gcp, g, f := newTestGCPFS(t, &models.GCPFSConfig{ReadTimeout: 100 * time.Millisecond})
+	f.put(testBucket, "tenants/acme/big.bin", make([]byte, 4<<10), nil)
+
+	// Every part that is requested arrives, but the deadline passes before the last one is, so the
+	// read must end as a timeout rather than as a checksum mismatch on the incomplete data.
+	g.client = f.client(func(next http.RoundTripper) http.RoundTripper {
+		return &lateTransport{next: next, delay: 60 * time.Millisecond}
+	})
+	_, _, err := gcp.ReadParallel(g, "big.bin", 1<<10, 1)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected context.DeadlineExceeded, got %v", err)
+	}
+	if errors.Is(err, models.ErrChecksumMismatch) {
+		t.Errorf("a timed-out read should not be reported as a checksum mismatch: %v", err)
+	}
+}
@@ -0,0 +1,57 @@
+package gcpFS
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/ninjamarcus/ninjaStorage/models"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/api/iterator"
+)
+
+// ListBuckets returns every bucket in the configured ProjectID, following all result pages.
+// The caller needs storage.buckets.list on the project.
+func (gcp *GCPController) ListBuckets(g *GCPFS) ([]models.BucketInfo, error) {
+	defer g.startOp()()
+	if g.config.ProjectID == "" {
+		return nil, fmt.Errorf("ProjectID has not been set, it is required to list buckets")
+	}
+	ctx, cancel := g.opContext(g.config.ListTimeout)
+	defer cancel()
+	var buckets []models.BucketInfo
+	it := g.client.Buckets(ctx, g.config.ProjectID)
+	for {
+		attrs, err := it.Next()
+		if err == iterator.Done {
+			return buckets, nil
+		}
+		var gErr *googleapi.Error
+		if errors.As(err, &gErr) && gErr.Code == http.StatusForbidden {
+			return nil, fmt.Errorf("cannot list buckets of project:%s (requires storage.buckets.list) reason: %v", g.config.ProjectID, err)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("cannot list buckets of project:%s reason: %v", g.config.ProjectID, err)
+		}
+		buckets = append(buckets, models.BucketInfo{
+			Name:         attrs.Name,
+			Location:     attrs.Location,
+			StorageClass: attrs.StorageClass,
+		})
+	}
+}
+
+// Ping checks that the configured bucket is reachable with the current credentials by fetching its
+// attributes, without touching any object, for use in readiness probes. The error matches
+// models.ErrNotFound when the bucket does not exist and models.ErrPermissionDenied when the
+// credentials are rejected or lack storage.buckets.get. It is bounded by MetadataTimeout and not
+// retried, so a probe fails fast.
+func (gcp *GCPController) Ping(g *GCPFS) error {
+	defer g.startOp()()
+	ctx, cancel := g.opContext(g.config.MetadataTimeout)
+	defer cancel()
+	if _, err := g.rawBucket(g.config.BucketName).Attrs(ctx); err != nil {
+		return fmt.Errorf("bucket:%s is not reachable: %w", g.config.BucketName, wrapGCSError(ctx, err))
+	}
+	return nil
+}
@@ -0,0 +1,409 @@
+package gcpFS
+
+import (
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+
+	"cloud.google.com/go/storage"
+	"github.com/ninjamarcus/ninjaStorage/models"
+)
+
+// bulkConcurrency bounds the worker pool of bulk operations that do not take a concurrency argument.
+const bulkConcurrency = 8
+
+// SkipObject can be returned by a TransformPrefix callback to leave an object out of the destination.
+var SkipObject = errors.New("skip object")
+
+// BatchError reports the objects a bulk operation failed on, keyed by path.
+type BatchError struct {
+	Errors map[string]error
+}
+
+func (b *BatchError) Error() string {
+	keys := make([]string, 0, len(b.Errors))
+	for k := range b.Errors {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	msgs := make([]string, 0, len(keys))
+	for _, k := range keys {
+		msgs = append(msgs, fmt.Sprintf("%s: %v", k, b.Errors[k]))
+	}
+	return fmt.Sprintf("%d object(s) failed: %s", len(keys), strings.Join(msgs, "; "))
+}
+
+// stoppedBatchError is the error of a batch its context cut short. It unwraps to the *BatchError of
+// the items that failed or were never started and matches the context's error with errors.Is.
+type stoppedBatchError struct {
+	msg    string
+	batch  *BatchError
+	ctxErr error
+}
+
+func (e *stoppedBatchError) Error() string        { return e.msg + ": " + e.batch.Error() }
+func (e *stoppedBatchError) Unwrap() error        { return e.batch }
+func (e *stoppedBatchError) Is(target error) bool { return target == e.ctxErr }
+
+// batchErrors is a concurrency safe BatchError builder.
+type batchErrors struct {
+	mu   sync.Mutex
+	errs map[string]error
+}
+
+func (b *batchErrors) add(key string, err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.errs == nil {
+		b.errs = make(map[string]error)
+	}
+	b.errs[key] = err
+}
+
+// err returns nil when nothing failed so callers can return it directly.
+func (b *batchErrors) err() error {
+	if len(b.errs) == 0 {
+		return nil
+	}
+	return &BatchError{Errors: b.errs}
+}
+
+// TransformPrefix reads every object under srcPrefix, passes it through transform and writes the
+// result to the same relative path under dstPrefix, keeping the source's user metadata. transform
+// receives the object's path relative to srcPrefix and may return SkipObject to leave it out.
+// Objects are processed concurrently; the count of objects written is returned along with a
+// *BatchError naming any that failed. "Folder" placeholders, whose names end in "/", are skipped.
+func (gcp *GCPController) TransformPrefix(g *GCPFS, srcPrefix, dstPrefix string, transform func(name string, data []byte) ([]byte, error)) (int, error) {
+	defer g.startOp()()
+	if transform == nil {
+		return 0, fmt.Errorf("transform cannot be nil")
+	}
+	srcPrefix = strings.TrimRight(srcPrefix, "/") + "/"
+	objects, err := gcp.List(g, srcPrefix)
+	if err != nil {
+		return 0, err
+	}
+
+	var (
+		mu      sync.Mutex
+		count   int
+		failed  batchErrors
+		wg      sync.WaitGroup
+		workers = make(chan struct{}, bulkConcurrency)
+	)
+	for name := range objects {
+		if strings.HasSuffix(name, "/") {
+			continue
+		}
+		srcPath := name
+		rel := strings.TrimPrefix(srcPath, srcPrefix)
+		wg.Add(1)
+		workers <- struct{}{}
+		go func() {
+			defer wg.Done()
+			defer func() { <-workers }()
+			data, mdata, err := gcp.Read(g, srcPath)
+			if err != nil {
+				failed.add(srcPath, err)
+				return
+			}
+			out, err := transform(rel, data)
+			if err == SkipObject {
+				return
+			}
+			if err != nil {
+				failed.add(srcPath, fmt.Errorf("transform: %w", err))
+				return
+			}
+			if _, err := gcp.Write(g, out, path.Join(dstPrefix, rel), &models.FileMetaData{UserMetaData: mdata.UserMetaData}); err != nil {
+				failed.add(srcPath, err)
+				return
+			}
+			mu.Lock()
+			count++
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+	return count, failed.err()
+}
+
+// FixContentTypesOptions tunes FixContentTypes.
+type FixContentTypesOptions struct {
+	// DryRun only counts the objects that would be changed.
+	DryRun bool
+	// Sniff reads the first 512 bytes of objects whose extension is unknown and detects their type
+	// from the content. Without it such objects are left alone.
+	Sniff bool
+}
+
+// FixContentTypes lists everything under prefix and corrects content types that do not match the
+// one detected from the object name's extension (or its content, with Sniff). Types are compared
+// without parameters, so "text/html" is not replaced by "text/html; charset=utf-8". Each update is
+// conditional on the metageneration listed, so concurrent metadata changes are not overwritten.
+// opts may be nil. Returns how many objects were (or, with DryRun, would be) fixed along with a
+// *BatchError naming any that failed.
+func (gcp *GCPController) FixContentTypes(g *GCPFS, prefix string, opts *FixContentTypesOptions) (int, error) {
+	if opts == nil {
+		opts = &FixContentTypesOptions{}
+	}
+	objects, err := gcp.List(g, strings.TrimRight(prefix, "/")+"/")
+	if err != nil {
+		return 0, err
+	}
+	defer g.startOp()()
+
+	var (
+		mu      sync.Mutex
+		count   int
+		failed  batchErrors
+		wg      sync.WaitGroup
+		workers = make(chan struct{}, bulkConcurrency)
+	)
+	bucket := g.rawBucket(g.config.BucketName)
+	for name, mdata := range objects {
+		if strings.HasSuffix(name, "/") {
+			continue
+		}
+		wg.Add(1)
+		workers <- struct{}{}
+		go func(name string, mdata *models.FileMetaData) {
+			defer wg.Done()
+			defer func() { <-workers }()
+			ctx, cancel := g.opContext(g.config.MetadataTimeout)
+			defer cancel()
+			o := bucket.Object(mdata.Name)
+			want := mime.TypeByExtension(path.Ext(name))
+			if want == "" && opts.Sniff {
+				head, err := readRange(ctx, o.Generation(mdata.Generation), 0, minInt64(512, mdata.Size))
+				if err != nil {
+					failed.add(name, err)
+					return
+				}
+				want = http.DetectContentType(head)
+			}
+			if want == "" || sameMediaType(want, mdata.ContentType) {
+				return
+			}
+			if !opts.DryRun {
+				o = o.If(storage.Conditions{MetagenerationMatch: mdata.Metageneration})
+				if _, err := o.Update(ctx, storage.ObjectAttrsToUpdate{ContentType: want}); err != nil {
+					failed.add(name, fmt.Errorf("ObjectHandle(%q) update failed: %v", name, err))
+					return
+				}
+			}
+			mu.Lock()
+			count++
+			mu.Unlock()
+		}(name, mdata)
+	}
+	wg.Wait()
+	return count, failed.err()
+}
+
+// sameMediaType compares two content types ignoring parameters and case.
+func sameMediaType(a, b string) bool {
+	ma, _, errA := mime.ParseMediaType(a)
+	mb, _, errB := mime.ParseMediaType(b)
+	if errA != nil || errB != nil {
+		return strings.EqualFold(a, b)
+	}
+	return ma == mb
+}
+
+func minInt64(a, b int64) int64 {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+// DeletePrefix deletes every object in the folder prefix, "folder" placeholders included, and returns
+// how many were removed. prefix is always taken as a folder: "logs" deletes logs/ and everything in
+// it, but not siblings such as logs2024.txt. Each delete is conditional on the generation listed, so an object rewritten in the
+// meantime survives. Failures do not stop the others and are returned as a *BatchError; cancelling
+// the GCPFS context stops further deletes and returns its error. An empty prefix is refused, since it
+// would empty the whole ParentFolder.
+func (gcp *GCPController) DeletePrefix(g *GCPFS, prefix string) (int, error) {
+	if strings.Trim(prefix, "/") == "" {
+		return 0, fmt.Errorf("prefix cannot be empty, it would delete everything under ParentFolder")
+	}
+	fullPrefix, err := g.objectPath(prefix)
+	if err != nil {
+		return 0, err
+	}
+	objects, err := gcp.List(g, strings.TrimRight(prefix, "/")+"/")
+	if err != nil {
+		return 0, err
+	}
+	defer g.startOp()()
+
+	var (
+		mu      sync.Mutex
+		count   int
+		failed  batchErrors
+		wg      sync.WaitGroup
+		workers = make(chan struct{}, bulkConcurrency)
+	)
+	bucket := g.rawBucket(g.config.BucketName)
+	for name, mdata := range objects {
+		if g.ctx.Err() != nil {
+			break
+		}
+		wg.Add(1)
+		workers <- struct{}{}
+		go func(name string, mdata *models.FileMetaData) {
+			defer wg.Done()
+			defer func() { <-workers }()
+			if err := g.throttle(); err != nil {
+				failed.add(name, err)
+				return
+			}
+			ctx, cancel := g.opContext(g.config.MetadataTimeout)
+			defer cancel()
+			defer g.exists.forget(mdata.Name)
+			err := bucket.Object(mdata.Name).If(storage.Conditions{GenerationMatch: mdata.Generation}).Delete(ctx)
+			if err == storage.ErrObjectNotExist {
+				return
+			}
+			if err != nil {
+				failed.add(name, fmt.Errorf("cannot delete object:%s reason: %w", mdata.Name, wrapGCSError(ctx, err)))
+				return
+			}
+			mu.Lock()
+			count++
+			mu.Unlock()
+		}(name, mdata)
+	}
+	wg.Wait()
+	if err := g.ctx.Err(); err != nil {
+		return count, fmt.Errorf("delete of prefix %s stopped after %d object(s): %w", prefix, count, err)
+	}
+	if err := failed.err(); err != nil {
+		return count, err
+	}
+	if g.config.CleanupFolderPlaceholders {
+		ctx, cancel := g.opContext(g.config.ListTimeout)
+		defer cancel()
+		return count, g.cleanupFolderPlaceholders(ctx, fullPrefix)
+	}
+	return count, nil
+}
+
+// WriteItem is one upload of a WriteBatch.
+type WriteItem struct {
+	Data     []byte
+	FilePath string
+	MetaData *models.FileMetaData
+}
+
+// WriteBatch writes every item like Write, running up to concurrency uploads at once (bulkConcurrency
+// when it is not positive). A failed item does not stop the others: results holds each item's
+// metadata in input order, nil for those that failed, and the error is a *BatchError keyed by
+// FilePath. Cancelling the GCPFS context stops new uploads being started: the items left out get a
+// BatchError entry of their own and the error also matches the context's error. Each path may only
+// appear once.
+func (gcp *GCPController) WriteBatch(g *GCPFS, items []WriteItem, concurrency int) ([]*models.FileMetaData, error) {
+	defer g.startOp()()
+	if concurrency <= 0 {
+		concurrency = bulkConcurrency
+	}
+	paths := make([]string, len(items))
+	for i, item := range items {
+		paths[i] = item.FilePath
+	}
+	if dups := duplicates(paths); len(dups) > 0 {
+		return nil, fmt.Errorf("duplicate paths in batch: %s", strings.Join(dups, ", "))
+	}
+
+	var (
+		failed  batchErrors
+		wg      sync.WaitGroup
+		workers = make(chan struct{}, concurrency)
+		results = make([]*models.FileMetaData, len(items))
+		started int
+	)
+	for i := range items {
+		if g.ctx.Err() != nil {
+			break
+		}
+		wg.Add(1)
+		workers <- struct{}{}
+		started++
+		go func(i int) {
+			defer wg.Done()
+			defer func() { <-workers }()
+			mdata, err := gcp.write(g, items[i].Data, items[i].FilePath, items[i].MetaData, nil, nil)
+			if err != nil {
+				failed.add(items[i].FilePath, err)
+				return
+			}
+			results[i] = mdata
+		}(i)
+	}
+	wg.Wait()
+	if err := g.ctx.Err(); err != nil && started < len(items) {
+		for _, item := range items[started:] {
+			failed.add(item.FilePath, fmt.Errorf("not written: %w", err))
+		}
+		return results, &stoppedBatchError{
+			msg:    fmt.Sprintf("batch write stopped after starting %d of %d item(s)", started, len(items)),
+			batch:  &BatchError{Errors: failed.errs},
+			ctxErr: err,
+		}
+	}
+	return results, failed.err()
+}
+
+// StatBatch stats every path like Stat, running up to concurrency requests at once (bulkConcurrency
+// when it is not positive). Each path lands in exactly one of the two maps: found holds the metadata
+// of the objects that exist, failed the error of the rest, wrapping ErrNotFound for those that are
+// simply missing. Once the GCPFS context is cancelled no further requests are started and the
+// remaining paths fail with the context's error. Repeated paths are only looked up once.
+func (gcp *GCPController) StatBatch(g *GCPFS, paths []string, concurrency int) (found map[string]*models.FileMetaData, failed map[string]error) {
+	defer g.startOp()()
+	if concurrency <= 0 {
+		concurrency = bulkConcurrency
+	}
+	found = make(map[string]*models.FileMetaData, len(paths))
+	failed = make(map[string]error)
+	var (
+		mu      sync.Mutex
+		wg      sync.WaitGroup
+		workers = make(chan struct{}, concurrency)
+		seen    = make(map[string]bool, len(paths))
+	)
+	for _, filePath := range paths {
+		if seen[filePath] {
+			continue
+		}
+		seen[filePath] = true
+		if err := g.ctx.Err(); err != nil {
+			mu.Lock()
+			failed[filePath] = err
+			mu.Unlock()
+			continue
+		}
+		wg.Add(1)
+		workers <- struct{}{}
+		go func(filePath string) {
+			defer wg.Done()
+			defer func() { <-workers }()
+			mdata, err := gcp.stat(g, filePath)
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				failed[filePath] = err
+				return
+			}
+			found[filePath] = mdata
+		}(filePath)
+	}
+	wg.Wait()
+	return found, failed
+}
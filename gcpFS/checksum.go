@@ -0,0 +1,297 @@
+package gcpFS
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"strconv"
+	"strings"
+
+	"cloud.google.com/go/storage"
+	"github.com/ninjamarcus/ninjaStorage/models"
+)
+
+// Custom metadata keys written by WriteWithChunkChecksums.
+const (
+	// ChunkSizeKey holds the chunk size, in bytes, the checksums were computed over.
+	ChunkSizeKey = "chunk-size"
+	// ChunkCRC32CKey holds the comma separated hex CRC32C of each chunk, in order.
+	ChunkCRC32CKey = "chunk-crc32c"
+)
+
+// maxChunkChecksums keeps the checksum list well inside GCS's 8 KiB custom metadata limit.
+const maxChunkChecksums = 512
+
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+// WriteWithChunkChecksums writes data like Write and additionally stores a CRC32C for every
+// chunkSize bytes of it in the object's user metadata, which is what lets ReadRangeVerified check
+// partial reads. The last chunk may be short. At most 512 chunks are allowed, so pick chunkSize
+// accordingly.
+func (gcp *GCPController) WriteWithChunkChecksums(g *GCPFS, data []byte, filePath string, metaData *models.FileMetaData, chunkSize int64) (*models.FileMetaData, error) {
+	defer g.startOp()()
+	if chunkSize <= 0 {
+		return nil, fmt.Errorf("chunkSize must be positive")
+	}
+	if n := (int64(len(data)) + chunkSize - 1) / chunkSize; n > maxChunkChecksums {
+		return nil, fmt.Errorf("chunkSize %d gives %d chunks, at most %d are allowed", chunkSize, n, maxChunkChecksums)
+	}
+	sums := make([]string, 0, (int64(len(data))+chunkSize-1)/chunkSize)
+	for off := int64(0); off < int64(len(data)); off += chunkSize {
+		end := off + chunkSize
+		if end > int64(len(data)) {
+			end = int64(len(data))
+		}
+		sums = append(sums, fmt.Sprintf("%08x", crc32.Checksum(data[off:end], castagnoli)))
+	}
+
+	withSums := &models.FileMetaData{UserMetaData: map[string]string{}}
+	if metaData != nil {
+		*withSums = *metaData
+		withSums.UserMetaData = map[string]string{}
+		for k, v := range metaData.UserMetaData {
+			withSums.UserMetaData[k] = v
+		}
+	}
+	withSums.UserMetaData[ChunkSizeKey] = strconv.FormatInt(chunkSize, 10)
+	withSums.UserMetaData[ChunkCRC32CKey] = strings.Join(sums, ",")
+	return gcp.write(g, data, filePath, withSums, nil, nil)
+}
+
+// ReadRangeVerified reads length bytes from offset (-1 meaning to the end) and checks them before
+// returning. GCS only keeps checksums for whole objects, so a range can only be verified when the
+// object was written with WriteWithChunkChecksums: the range is widened to chunk boundaries, every
+// chunk is checked against its stored CRC32C and the requested bytes are cut back out. Without chunk
+// checksums only a read of the whole object can be verified (against the object's CRC32C); other
+// ranges are refused. A mismatch returns an error wrapping ErrChecksumMismatch.
+func (gcp *GCPController) ReadRangeVerified(g *GCPFS, filePath string, offset, length int64) ([]byte, *models.FileMetaData, error) {
+	defer g.startOp()()
+	if offset < 0 {
+		return nil, nil, fmt.Errorf("offset cannot be negative: %d", offset)
+	}
+	fullPath, err := g.objectPath(filePath)
+	if err != nil {
+		return nil, nil, err
+	}
+	ctx, cancel := g.opContext(g.config.ReadTimeout)
+	defer cancel()
+	o := g.rawBucket(g.config.BucketName).Object(fullPath)
+	attrs, err := g.keyed(o).Attrs(ctx)
+	if err == storage.ErrObjectNotExist {
+		return nil, nil, fmt.Errorf("object(%s) cannot be found: %w", fullPath, models.ErrNotFound)
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("object.Attrs: %w", wrapGCSError(ctx, err))
+	}
+	end := attrs.Size
+	if length >= 0 && offset+length < end {
+		end = offset + length
+	}
+	if offset > end || (offset == end && length != 0) {
+		return nil, nil, fmt.Errorf("object(%s) range starts at %d past its end at %d", fullPath, offset, attrs.Size)
+	}
+	// Pin the generation so the content read is the one the checksums describe.
+	o = g.keyed(o.Generation(attrs.Generation))
+
+	chunkSize, sums, err := chunkChecksums(attrs.Metadata)
+	if err != nil {
+		return nil, nil, fmt.Errorf("object(%s) has malformed chunk checksums: %v", fullPath, err)
+	}
+	if chunkSize == 0 {
+		if offset != 0 || end != attrs.Size {
+			return nil, nil, fmt.Errorf("object(%s) was not written with chunk checksums, only whole reads can be verified", fullPath)
+		}
+		data, err := readRange(ctx, o, 0, attrs.Size)
+		if err != nil {
+			return nil, nil, readError(ctx, fullPath, err)
+		}
+		if crc32.Checksum(data, castagnoli) != attrs.CRC32C {
+			return nil, nil, fmt.Errorf("object(%s) CRC32C does not match: %w", fullPath, models.ErrChecksumMismatch)
+		}
+		return data, g.parseMetaData(attrs), nil
+	}
+
+	if offset == end {
+		return []byte{}, g.parseMetaData(attrs), nil
+	}
+	first, last := offset/chunkSize, (end-1)/chunkSize
+	if last >= int64(len(sums)) {
+		return nil, nil, fmt.Errorf("object(%s) has %d chunk checksums, too few for its size: %w", fullPath, len(sums), models.ErrChecksumMismatch)
+	}
+	start := first * chunkSize
+	stop := (last + 1) * chunkSize
+	if stop > attrs.Size {
+		stop = attrs.Size
+	}
+	data, err := readRange(ctx, o, start, stop-start)
+	if err != nil {
+		return nil, nil, readError(ctx, fullPath, err)
+	}
+	for i := first; i <= last; i++ {
+		lo := (i - first) * chunkSize
+		hi := lo + chunkSize
+		if hi > int64(len(data)) {
+			hi = int64(len(data))
+		}
+		if crc32.Checksum(data[lo:hi], castagnoli) != sums[i] {
+			return nil, nil, fmt.Errorf("object(%s) chunk %d CRC32C does not match: %w", fullPath, i, models.ErrChecksumMismatch)
+		}
+	}
+	return data[offset-start : end-start], g.parseMetaData(attrs), nil
+}
+
+// chunkChecksums parses the metadata written by WriteWithChunkChecksums. A zero chunk size means the
+// object has none.
+func chunkChecksums(meta map[string]string) (int64, []uint32, error) {
+	rawSize, ok := meta[ChunkSizeKey]
+	if !ok {
+		return 0, nil, nil
+	}
+	size, err := strconv.ParseInt(rawSize, 10, 64)
+	if err != nil || size <= 0 {
+		return 0, nil, fmt.Errorf("invalid %s %q", ChunkSizeKey, rawSize)
+	}
+	var sums []uint32
+	if raw := meta[ChunkCRC32CKey]; raw != "" {
+		for _, s := range strings.Split(raw, ",") {
+			sum, err := strconv.ParseUint(s, 16, 32)
+			if err != nil {
+				return 0, nil, fmt.Errorf("invalid %s entry %q", ChunkCRC32CKey, s)
+			}
+			sums = append(sums, uint32(sum))
+		}
+	}
+	return size, sums, nil
+}
+
+// readRange reads exactly length bytes from offset.
+func readRange(ctx context.Context, o *storage.ObjectHandle, offset, length int64) ([]byte, error) {
+	if length == 0 {
+		return []byte{}, nil
+	}
+	rc, err := o.NewRangeReader(ctx, offset, length)
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	return io.ReadAll(rc)
+}
+
+// VerifyIntegrity downloads the object at filePath, as stored, and checks it against every checksum
+// kept for it: the CRC32C GCS computed on upload, the MD5 (composite objects have none) and, for
+// objects written with WriteWithChunkChecksums, each chunk's CRC32C. The content is streamed through
+// the hashes rather than held in memory. A mismatch returns an error wrapping ErrChecksumMismatch.
+func (gcp *GCPController) VerifyIntegrity(g *GCPFS, filePath string) (*models.FileMetaData, error) {
+	defer g.startOp()()
+	fullPath, err := g.objectPath(filePath)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := g.opContext(g.config.ReadTimeout)
+	defer cancel()
+	return gcp.verifyIntegrity(ctx, g, fullPath)
+}
+
+func (gcp *GCPController) verifyIntegrity(ctx context.Context, g *GCPFS, fullPath string) (*models.FileMetaData, error) {
+	o := g.rawBucket(g.config.BucketName).Object(fullPath)
+	attrs, err := g.keyed(o).Attrs(ctx)
+	if err == storage.ErrObjectNotExist {
+		return nil, fmt.Errorf("object(%s) cannot be found: %w", fullPath, models.ErrNotFound)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("object.Attrs: %w", wrapGCSError(ctx, err))
+	}
+	chunkSize, sums, err := chunkChecksums(attrs.Metadata)
+	if err != nil {
+		return nil, fmt.Errorf("object(%s) has malformed chunk checksums: %v", fullPath, err)
+	}
+
+	// Pin the generation so the content read is the one the checksums describe, and read it
+	// compressed since the checksums cover the stored bytes.
+	crc := crc32.New(castagnoli)
+	sum := md5.New()
+	chunks := &chunkVerifier{size: chunkSize, sums: sums, crc: crc32.New(castagnoli)}
+	var n int64
+	if attrs.Size > 0 {
+		rc, err := g.keyed(o.Generation(attrs.Generation)).ReadCompressed(true).NewRangeReader(ctx, 0, attrs.Size)
+		if err != nil {
+			return nil, readError(ctx, fullPath, err)
+		}
+		defer rc.Close()
+		if n, err = io.Copy(io.MultiWriter(crc, sum, chunks), rc); err != nil {
+			return nil, fmt.Errorf("object(%s) cannot be read: %w", fullPath, wrapGCSError(ctx, err))
+		}
+	}
+	if n != attrs.Size {
+		return nil, fmt.Errorf("object(%s) read %d bytes of %d: %w", fullPath, n, attrs.Size, models.ErrChecksumMismatch)
+	}
+	if crc.Sum32() != attrs.CRC32C {
+		return nil, fmt.Errorf("object(%s) CRC32C does not match: %w", fullPath, models.ErrChecksumMismatch)
+	}
+	if len(attrs.MD5) > 0 && !bytes.Equal(sum.Sum(nil), attrs.MD5) {
+		return nil, fmt.Errorf("object(%s) MD5 does not match: %w", fullPath, models.ErrChecksumMismatch)
+	}
+	if err := chunks.finish(); err != nil {
+		return nil, fmt.Errorf("object(%s) %v: %w", fullPath, err, models.ErrChecksumMismatch)
+	}
+	return g.parseMetaData(attrs), nil
+}
+
+// chunkVerifier checks the bytes written to it against per-chunk CRC32Cs as they stream past. The
+// first mismatch is kept and reported by finish. A zero size checks nothing.
+type chunkVerifier struct {
+	size   int64
+	sums   []uint32
+	crc    hash.Hash32
+	filled int64
+	index  int
+	err    error
+}
+
+func (c *chunkVerifier) Write(p []byte) (int, error) {
+	n := len(p)
+	for c.size > 0 && c.err == nil && len(p) > 0 {
+		take := c.size - c.filled
+		if take > int64(len(p)) {
+			take = int64(len(p))
+		}
+		c.crc.Write(p[:take])
+		c.filled += take
+		p = p[take:]
+		if c.filled == c.size {
+			c.check()
+		}
+	}
+	return n, nil
+}
+
+// check compares the chunk just completed and starts the next one.
+func (c *chunkVerifier) check() {
+	switch {
+	case c.index >= len(c.sums):
+		c.err = fmt.Errorf("has %d chunk checksums, too few for its size", len(c.sums))
+	case c.crc.Sum32() != c.sums[c.index]:
+		c.err = fmt.Errorf("chunk %d CRC32C does not match", c.index)
+	}
+	c.index++
+	c.filled = 0
+	c.crc.Reset()
+}
+
+// finish checks the trailing short chunk, if any, and that no checksums were left over.
+func (c *chunkVerifier) finish() error {
+	if c.size == 0 || c.err != nil {
+		return c.err
+	}
+	if c.filled > 0 {
+		c.check()
+	}
+	if c.err == nil && c.index != len(c.sums) {
+		c.err = fmt.Errorf("has %d chunk checksums for %d chunks", len(c.sums), c.index)
+	}
+	return c.err
+}
@@ -0,0 +1,267 @@
+package gcpFS
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"github.com/ninjamarcus/ninjaStorage/models"
+)
+
+// maxComposeSources is the most source objects GCS accepts in a single compose request.
+const maxComposeSources = 32
+
+// ComposeOptions tunes Compose and Append.
+type ComposeOptions struct {
+	// AllowDuplicateSources lets the same source appear more than once, e.g. to repeat a chunk.
+	// By default a repeated source is treated as a caller mistake and rejected.
+	AllowDuplicateSources bool
+	// MetaData sets the composed object's ContentType, ContentEncoding, ContentDisposition,
+	// CacheControl, StorageClass, KMSKeyName and UserMetaData, which GCS does not carry over from
+	// the sources. Nil leaves the result without any, apart from the configured defaults, which
+	// include DefaultMetadata and the provenance fields.
+	MetaData *models.FileMetaData
+}
+
+// Compose concatenates srcs, in order, into dst. All paths are relative to ParentFolder and in the
+// configured bucket. Every source is checked to exist before the compose is issued, so a missing
+// source is reported by name (wrapping ErrNotFound) instead of as GCS's generic compose failure.
+// opts may be nil.
+func (gcp *GCPController) Compose(g *GCPFS, dst string, srcs []string, opts *ComposeOptions) (*models.FileMetaData, error) {
+	defer g.startOp()()
+	return gcp.compose(g, dst, srcs, opts)
+}
+
+// Append composes srcs onto the end of the existing object at filePath, replacing it in place.
+func (gcp *GCPController) Append(g *GCPFS, filePath string, srcs []string, opts *ComposeOptions) (*models.FileMetaData, error) {
+	defer g.startOp()()
+	return gcp.compose(g, filePath, append([]string{filePath}, srcs...), opts)
+}
+
+// AppendData appends data to the object at filePath, creating it when there is none yet. GCS objects
+// are immutable, so data is uploaded to a temporary object next to filePath, composed onto the end
+// of the existing one and the temporary object deleted again. The result keeps the object's content
+// type, encoding, user metadata and other attributes but, being composite, no longer has an MD5.
+// Appends racing with each other or with any other change to the object fail, with an error
+// wrapping ErrPreconditionFailed, rather than losing data; the caller can retry. GCS limits an
+// object to 1024 composed components, so very long-lived logs should roll over to new objects.
+func (gcp *GCPController) AppendData(g *GCPFS, data []byte, filePath string) (*models.FileMetaData, error) {
+	defer g.startOp()()
+	fullPath, err := g.objectPath(filePath)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) == 0 {
+		return nil, fmt.Errorf("length of data is 0 nothing to append")
+	}
+	ctx, cancel := g.opContext(g.config.WriteTimeout)
+	defer cancel()
+	bucket := g.rawBucket(g.config.BucketName)
+	var attrs *storage.ObjectAttrs
+	err = g.retry(ctx, func() (err error) {
+		attrs, err = bucket.Object(fullPath).Attrs(ctx)
+		return err
+	})
+	if err == storage.ErrObjectNotExist {
+		return gcp.write(g, data, filePath, nil, &storage.Conditions{DoesNotExist: true}, nil)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("object.Attrs: %w", wrapGCSError(ctx, err))
+	}
+
+	if err := g.throttle(); err != nil {
+		return nil, err
+	}
+	tmp := g.keyed(bucket.Object(fmt.Sprintf("%s.append-%d", fullPath, time.Now().UnixNano())))
+	dst := g.keyed(bucket.Object(fullPath))
+	wc := tmp.If(storage.Conditions{DoesNotExist: true}).NewWriter(ctx)
+	wc.ContentType = attrs.ContentType
+	if _, err := wc.Write(data); err != nil {
+		wc.Close()
+		return nil, fmt.Errorf("cannot upload the data to append to object(%s): %w", fullPath, wrapGCSError(ctx, err))
+	}
+	if err := wc.Close(); err != nil {
+		return nil, fmt.Errorf("cannot upload the data to append to object(%s): %w", fullPath, wrapGCSError(ctx, err))
+	}
+	defer func() {
+		err := g.retry(ctx, func() error {
+			err := tmp.Delete(ctx)
+			if err == storage.ErrObjectNotExist {
+				return nil
+			}
+			return err
+		})
+		if err != nil {
+			g.logf("append cleanup", "object", tmp.ObjectName(), "err", err)
+		}
+	}()
+
+	defer g.exists.forget(fullPath)
+	// Compose exactly the generation seen above, and only if the object is still at it.
+	composer := dst.If(storage.Conditions{GenerationMatch: attrs.Generation, MetagenerationMatch: attrs.Metageneration}).
+		ComposerFrom(bucket.Object(fullPath).Generation(attrs.Generation), tmp)
+	composer.ObjectAttrs = storage.ObjectAttrs{
+		ContentType:        attrs.ContentType,
+		ContentEncoding:    attrs.ContentEncoding,
+		ContentLanguage:    attrs.ContentLanguage,
+		ContentDisposition: attrs.ContentDisposition,
+		CacheControl:       attrs.CacheControl,
+		CustomTime:         attrs.CustomTime,
+		StorageClass:       attrs.StorageClass,
+		Metadata:           g.withProvenance(g.withDefaultMetadata(attrs.Metadata)),
+	}
+	composer.KMSKeyName = cryptoKeyName(attrs.KMSKeyName)
+	composed, err := composer.Run(ctx)
+	// Without versioning the generation seen is gone, rather than not current, once it is replaced.
+	if isPreconditionFailed(err) || errors.Is(wrapGCSError(ctx, err), models.ErrNotFound) {
+		return nil, fmt.Errorf("object(%s) changed while appending to it: %w", fullPath, models.ErrPreconditionFailed)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("cannot append to object(%s): %w", fullPath, wrapGCSError(ctx, err))
+	}
+	return g.parseMetaData(composed), nil
+}
+
+func (gcp *GCPController) compose(g *GCPFS, dst string, srcs []string, opts *ComposeOptions) (*models.FileMetaData, error) {
+	if opts == nil {
+		opts = &ComposeOptions{}
+	}
+	fullPath, err := g.objectPath(dst)
+	if err != nil {
+		return nil, err
+	}
+	if len(srcs) == 0 {
+		return nil, fmt.Errorf("compose needs at least one source")
+	}
+	if len(srcs) > maxComposeSources {
+		return nil, fmt.Errorf("compose accepts at most %d sources, got %d", maxComposeSources, len(srcs))
+	}
+	if !opts.AllowDuplicateSources {
+		if dups := duplicates(srcs); len(dups) > 0 {
+			return nil, fmt.Errorf("duplicate compose sources: %s", strings.Join(dups, ", "))
+		}
+	}
+	srcPaths := make([]string, len(srcs))
+	for i, src := range srcs {
+		if srcPaths[i], err = g.objectPath(src); err != nil {
+			return nil, err
+		}
+	}
+
+	dstAttrs := storage.ObjectAttrs{ContentDisposition: g.config.DefaultContentDisposition, StorageClass: g.config.DefaultStorageClass}
+	kmsKeyName := g.config.KMSKeyName
+	if m := opts.MetaData; m != nil {
+		if err := g.config.MetadataSchema.Validate(g.withDefaultMetadata(m.UserMetaData)); err != nil {
+			return nil, err
+		}
+		dstAttrs.ContentType = m.ContentType
+		dstAttrs.ContentEncoding = m.ContentEncoding
+		dstAttrs.CacheControl = m.CacheControl
+		dstAttrs.Metadata = m.UserMetaData
+		if m.ContentDisposition != "" {
+			dstAttrs.ContentDisposition = m.ContentDisposition
+		}
+		if m.KMSKeyName != "" {
+			kmsKeyName = m.KMSKeyName
+		}
+		if m.StorageClass != "" {
+			dstAttrs.StorageClass = m.StorageClass
+		}
+	}
+	dstAttrs.Metadata = g.withProvenance(g.withDefaultMetadata(dstAttrs.Metadata))
+	if err := models.ValidateStorageClass(dstAttrs.StorageClass); err != nil {
+		return nil, err
+	}
+	dstAttrs.StorageClass = strings.ToUpper(dstAttrs.StorageClass)
+	if err := models.ValidateContentDisposition(dstAttrs.ContentDisposition); err != nil {
+		return nil, err
+	}
+	if err := models.ValidateKMSKeyName(kmsKeyName); err != nil {
+		return nil, err
+	}
+
+	if err := g.throttle(); err != nil {
+		return nil, err
+	}
+	ctx, cancel := g.opContext(g.config.WriteTimeout)
+	defer cancel()
+	bucket := g.rawBucket(g.config.BucketName)
+	if missing, err := g.missingObjects(ctx, srcPaths); err != nil {
+		return nil, err
+	} else if len(missing) > 0 {
+		return nil, fmt.Errorf("compose sources %s: %w", strings.Join(missing, ", "), models.ErrNotFound)
+	}
+
+	handles := make([]*storage.ObjectHandle, 0, len(srcPaths))
+	for _, src := range srcPaths {
+		handles = append(handles, bucket.Object(src))
+	}
+	defer g.exists.forget(fullPath)
+	composer := bucket.Object(fullPath).ComposerFrom(handles...)
+	composer.ObjectAttrs = dstAttrs
+	composer.KMSKeyName = kmsKeyName
+	attrs, err := composer.Run(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("object(%s) cannot be composed: %w", fullPath, wrapGCSError(ctx, err))
+	}
+	return g.parseMetaData(attrs), nil
+}
+
+// missingObjects stats the full object names concurrently and returns, sorted and relative to
+// ParentFolder, the ones that do not exist. Each distinct name is only checked once.
+func (g *GCPFS) missingObjects(ctx context.Context, names []string) ([]string, error) {
+	var (
+		mu      sync.Mutex
+		missing []string
+		failed  batchErrors
+		wg      sync.WaitGroup
+		workers = make(chan struct{}, bulkConcurrency)
+		seen    = make(map[string]bool, len(names))
+	)
+	bucket := g.rawBucket(g.config.BucketName)
+	for _, name := range names {
+		if seen[name] {
+			continue
+		}
+		seen[name] = true
+		wg.Add(1)
+		workers <- struct{}{}
+		go func(name string) {
+			defer wg.Done()
+			defer func() { <-workers }()
+			_, err := bucket.Object(name).Attrs(ctx)
+			switch {
+			case err == storage.ErrObjectNotExist:
+				mu.Lock()
+				missing = append(missing, g.relativeName(name))
+				mu.Unlock()
+			case err != nil:
+				failed.add(name, err)
+			}
+		}(name)
+	}
+	wg.Wait()
+	if err := failed.err(); err != nil {
+		return nil, err
+	}
+	sort.Strings(missing)
+	return missing, nil
+}
+
+// duplicates returns, in first-seen order, the names that appear more than once.
+func duplicates(names []string) []string {
+	counts := make(map[string]int, len(names))
+	var dups []string
+	for _, name := range names {
+		counts[name]++
+		if counts[name] == 2 {
+			dups = append(dups, name)
+		}
+	}
+	return dups
+}
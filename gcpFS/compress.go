@@ -0,0 +1,113 @@
+package gcpFS
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"mime"
+	"strings"
+
+	"github.com/ninjamarcus/ninjaStorage/models"
+)
+
+// compressedTypes are media types whose content is already compressed, gzipping them again only
+// costs CPU. image/*, audio/* and video/* are covered separately.
+var compressedTypes = map[string]bool{
+	"application/gzip":             true,
+	"application/x-gzip":           true,
+	"application/zip":              true,
+	"application/x-bzip2":          true,
+	"application/x-xz":             true,
+	"application/zstd":             true,
+	"application/x-7z-compressed":  true,
+	"application/vnd.rar":          true,
+	"application/x-rar-compressed": true,
+	"font/woff":                    true,
+	"font/woff2":                   true,
+}
+
+// isCompressedType reports whether contentType names an already compressed format.
+func isCompressedType(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		mediaType = strings.ToLower(contentType)
+	}
+	if mediaType == "image/svg+xml" || mediaType == "image/bmp" {
+		return false
+	}
+	if compressedTypes[mediaType] {
+		return true
+	}
+	for _, prefix := range []string{"image/", "audio/", "video/"} {
+		if strings.HasPrefix(mediaType, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// compressedMetaData decides whether an upload to filePath starting with head should be gzipped
+// for WriteOptions.Compress. If so it returns the metadata to store it with: the original content
+// type, detected from head when not given, and Content-Encoding gzip, so GCS decompresses it again
+// for readers. Content that already has a Content-Encoding or is of a compressed type is left
+// alone, and nil is returned.
+func compressedMetaData(filePath string, head []byte, metaData *models.FileMetaData) (*models.FileMetaData, error) {
+	if metaData != nil && (metaData.Md5Hash != "" || metaData.Crc32c != "") {
+		return nil, fmt.Errorf("Md5Hash and Crc32c cannot be verified on a compressed upload, they would have to be those of the gzipped bytes")
+	}
+	if metaData != nil && metaData.ContentEncoding != "" {
+		return nil, nil
+	}
+	contentType := ""
+	if metaData != nil {
+		contentType = metaData.ContentType
+	}
+	if contentType == "" {
+		contentType = detectContentType(filePath, head)
+	}
+	if isCompressedType(contentType) {
+		return nil, nil
+	}
+	gzipped := &models.FileMetaData{}
+	if metaData != nil {
+		*gzipped = *metaData
+	}
+	gzipped.ContentType = contentType
+	gzipped.ContentEncoding = "gzip"
+	return gzipped, nil
+}
+
+// gzipBytes compresses data in one go.
+func gzipBytes(data []byte) []byte {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	zw.Write(data)
+	zw.Close()
+	return buf.Bytes()
+}
+
+// gzipReader returns a reader producing r gzipped, compressing in a goroutine as it is read. A read
+// error from r is passed on to the reader. Close it once done, read to the end or not, to stop the
+// goroutine.
+func gzipReader(r io.Reader) *io.PipeReader {
+	pr, pw := io.Pipe()
+	go func() {
+		zw := gzip.NewWriter(pw)
+		_, err := io.Copy(zw, r)
+		if cerr := zw.Close(); err == nil {
+			err = cerr
+		}
+		pw.CloseWithError(err)
+	}()
+	return pr
+}
+
+// peekHead returns r's first 512 bytes, for content type detection, and a reader still producing
+// all of r.
+func peekHead(r io.Reader) ([]byte, io.Reader) {
+	br := bufio.NewReaderSize(r, 512)
+	head, _ := br.Peek(512)
+	return head, br
+}
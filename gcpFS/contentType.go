@@ -0,0 +1,62 @@
+package gcpFS
+
+import (
+	"fmt"
+	"mime"
+	"net/http"
+	"path"
+	"strings"
+
+	"github.com/ninjamarcus/ninjaStorage/models"
+)
+
+// detectContentType works out the content type for an upload, preferring the file extension and
+// falling back to sniffing the first 512 bytes of data.
+func detectContentType(filePath string, data []byte) string {
+	if ct := mime.TypeByExtension(path.Ext(filePath)); ct != "" {
+		return ct
+	}
+	return http.DetectContentType(data)
+}
+
+// checkContentType enforces models.GCPFSConfig.AllowedContentTypes for the object at filePath.
+// The longest configured prefix matching filePath decides; paths under no configured prefix are
+// unrestricted.
+func (g *GCPFS) checkContentType(filePath string, contentType string) error {
+	if len(g.config.AllowedContentTypes) == 0 {
+		return nil
+	}
+	var matched string
+	var allowed []string
+	found := false
+	for prefix, types := range g.config.AllowedContentTypes {
+		if strings.HasPrefix(filePath, prefix) && (!found || len(prefix) > len(matched)) {
+			matched, allowed, found = prefix, types, true
+		}
+	}
+	if !found {
+		return nil
+	}
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		mediaType = contentType
+	}
+	for _, pattern := range allowed {
+		if contentTypeMatches(pattern, mediaType) {
+			return nil
+		}
+	}
+	return fmt.Errorf("content type %q is not allowed under %q: %w", contentType, matched, models.ErrContentTypeNotAllowed)
+}
+
+// contentTypeMatches matches a media type against a pattern such as "image/png", "image/*" or "*/*".
+func contentTypeMatches(pattern string, mediaType string) bool {
+	pattern = strings.ToLower(pattern)
+	if pattern == "*/*" || pattern == mediaType {
+		return true
+	}
+	if strings.HasSuffix(pattern, "/*") {
+		return strings.HasPrefix(mediaType, strings.TrimSuffix(pattern, "*"))
+	}
+	return false
+}
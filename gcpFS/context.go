@@ -0,0 +1,35 @@
+package gcpFS
+
+import (
+	"context"
+
+	"github.com/ninjamarcus/ninjaStorage/models"
+)
+
+// The Ctx variants run the most common operations under a caller-supplied context. They are
+// shorthand for passing g.With(WithContext(ctx)); use that directly for the other operations.
+
+// ReadCtx is Read under ctx.
+func (gcp *GCPController) ReadCtx(ctx context.Context, g *GCPFS, filePath string) ([]byte, *models.FileMetaData, error) {
+	return gcp.Read(g.With(WithContext(ctx)), filePath)
+}
+
+// WriteCtx is Write under ctx.
+func (gcp *GCPController) WriteCtx(ctx context.Context, g *GCPFS, data []byte, filePath string, metaData *models.FileMetaData) (*models.FileMetaData, error) {
+	return gcp.Write(g.With(WithContext(ctx)), data, filePath, metaData)
+}
+
+// StatCtx is Stat under ctx.
+func (gcp *GCPController) StatCtx(ctx context.Context, g *GCPFS, filePath string) (*models.FileMetaData, error) {
+	return gcp.Stat(g.With(WithContext(ctx)), filePath)
+}
+
+// ListCtx is List under ctx.
+func (gcp *GCPController) ListCtx(ctx context.Context, g *GCPFS, prefix string) (map[string]*models.FileMetaData, error) {
+	return gcp.List(g.With(WithContext(ctx)), prefix)
+}
+
+// DeleteCtx is Delete under ctx.
+func (gcp *GCPController) DeleteCtx(ctx context.Context, g *GCPFS, filePath string) error {
+	return gcp.Delete(g.With(WithContext(ctx)), filePath)
+}
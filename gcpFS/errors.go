@@ -0,0 +1,122 @@
+package gcpFS
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"cloud.google.com/go/storage"
+	"github.com/ninjamarcus/ninjaStorage/models"
+	"google.golang.org/api/googleapi"
+)
+
+// isPreconditionFailed reports whether GCS rejected the request because a generation or
+// metageneration condition did not hold.
+func isPreconditionFailed(err error) bool {
+	var gErr *googleapi.Error
+	return errors.As(err, &gErr) && gErr.Code == http.StatusPreconditionFailed
+}
+
+// isUniformAccessError reports whether GCS refused an object ACL because the bucket uses uniform
+// bucket-level access.
+func isUniformAccessError(err error) bool {
+	var gErr *googleapi.Error
+	return errors.As(err, &gErr) && gErr.Code == http.StatusBadRequest &&
+		strings.Contains(strings.ToLower(gErr.Message), "uniform bucket-level access")
+}
+
+// isChecksumMismatch reports whether GCS rejected an upload because its content did not match the
+// MD5 or CRC32C sent with it.
+func isChecksumMismatch(err error) bool {
+	var gErr *googleapi.Error
+	return errors.As(err, &gErr) && gErr.Code == http.StatusBadRequest &&
+		strings.Contains(strings.ToLower(gErr.Message), "doesn't match calculated")
+}
+
+// contextError is an error caused by its operation's context ending. It reads as the original error
+// but matches context.DeadlineExceeded or context.Canceled with errors.Is, which the SDK's own retry
+// errors do not.
+type contextError struct {
+	err    error
+	ctxErr error
+}
+
+func (e *contextError) Error() string { return e.err.Error() }
+func (e *contextError) Unwrap() error { return e.ctxErr }
+
+// statusError is a GCS error that also matches the models sentinel for its status with errors.Is,
+// so callers need not know about googleapi. The original error stays in the chain.
+type statusError struct {
+	err      error
+	sentinel error
+}
+
+func (e *statusError) Error() string        { return e.err.Error() }
+func (e *statusError) Unwrap() error        { return e.err }
+func (e *statusError) Is(target error) bool { return target == e.sentinel }
+
+// statusSentinel maps a GCS error onto the models sentinel callers check for, or nil.
+func statusSentinel(err error) error {
+	if errors.Is(err, storage.ErrObjectNotExist) || errors.Is(err, storage.ErrBucketNotExist) {
+		return models.ErrNotFound
+	}
+	var gErr *googleapi.Error
+	if !errors.As(err, &gErr) {
+		return nil
+	}
+	switch gErr.Code {
+	case http.StatusNotFound:
+		return models.ErrNotFound
+	case http.StatusUnauthorized, http.StatusForbidden:
+		return models.ErrPermissionDenied
+	case http.StatusConflict:
+		return models.ErrAlreadyExists
+	case http.StatusPreconditionFailed:
+		return models.ErrPreconditionFailed
+	}
+	return nil
+}
+
+// wrapGCSError prepares an error from GCS to be wrapped with %w. When ctx has ended it becomes a
+// contextError; otherwise errors with a meaningful status also match the models sentinel for it
+// (ErrNotFound, ErrPermissionDenied, ErrAlreadyExists or ErrPreconditionFailed).
+func wrapGCSError(ctx context.Context, err error) error {
+	if err == nil {
+		return nil
+	}
+	if ctx.Err() != nil && !errors.Is(err, ctx.Err()) {
+		return &contextError{err: err, ctxErr: ctx.Err()}
+	}
+	if sentinel := statusSentinel(err); sentinel != nil && !errors.Is(err, sentinel) {
+		return &statusError{err: err, sentinel: sentinel}
+	}
+	return err
+}
+
+// readError reports a failed download of fullPath, pointing at the missing key when the object is
+// encrypted with a customer-supplied one.
+func readError(ctx context.Context, fullPath string, err error) error {
+	if isEncryptionKeyRequired(err) {
+		return fmt.Errorf("object(%s) is encrypted with a customer-supplied key, pass it as ReadOptions.EncryptionKey or with WithEncryptionKey: %w", fullPath, wrapGCSError(ctx, err))
+	}
+	return fmt.Errorf("object(%s) cannot be read: %w", fullPath, wrapGCSError(ctx, err))
+}
+
+// isEncryptionKeyRequired reports whether GCS refused a download because the object is encrypted
+// with a customer-supplied key and none was sent.
+func isEncryptionKeyRequired(err error) bool {
+	var gErr *googleapi.Error
+	if !errors.As(err, &gErr) || gErr.Code != http.StatusBadRequest {
+		return false
+	}
+	const reason = "resourceisencryptedwithcustomerencryptionkey"
+	for _, item := range gErr.Errors {
+		if strings.ToLower(item.Reason) == reason {
+			return true
+		}
+	}
+	// Download errors are not parsed, so look for the reason, in either API's casing, in the body.
+	return strings.Contains(strings.ToLower(gErr.Body), reason)
+}
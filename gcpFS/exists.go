@@ -0,0 +1,139 @@
+package gcpFS
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/storage"
+)
+
+// existsCache remembers recent Exists answers, shared by pointer across copies of a GCPFS.
+type existsCache struct {
+	mu      sync.Mutex
+	entries map[string]existsEntry
+	// lookups tracks the paths with an Exists round-trip in progress, so an answer that a change
+	// made meanwhile has made stale is not cached.
+	lookups map[string]*existsLookup
+}
+
+type existsLookup struct {
+	pending int
+	epoch   uint64
+}
+
+type existsEntry struct {
+	exists  bool
+	expires time.Time
+}
+
+func (c *existsCache) get(fullPath string) (exists, ok bool) {
+	if c == nil {
+		return false, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.entries[fullPath]
+	if !ok || time.Now().After(e.expires) {
+		delete(c.entries, fullPath)
+		return false, false
+	}
+	return e.exists, true
+}
+
+// begin registers a lookup of fullPath and returns the epoch to pass to set and end.
+func (c *existsCache) begin(fullPath string) uint64 {
+	if c == nil {
+		return 0
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.lookups == nil {
+		c.lookups = make(map[string]*existsLookup)
+	}
+	l := c.lookups[fullPath]
+	if l == nil {
+		l = &existsLookup{}
+		c.lookups[fullPath] = l
+	}
+	l.pending++
+	return l.epoch
+}
+
+// end unregisters a lookup started with begin.
+func (c *existsCache) end(fullPath string) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if l := c.lookups[fullPath]; l != nil {
+		if l.pending--; l.pending <= 0 {
+			delete(c.lookups, fullPath)
+		}
+	}
+}
+
+// set caches the answer of a lookup started at epoch, unless fullPath was forgotten since.
+func (c *existsCache) set(fullPath string, epoch uint64, exists bool, ttl time.Duration) {
+	if c == nil || ttl <= 0 {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if l := c.lookups[fullPath]; l == nil || l.epoch != epoch {
+		return
+	}
+	if c.entries == nil {
+		c.entries = make(map[string]existsEntry)
+	}
+	c.entries[fullPath] = existsEntry{exists: exists, expires: time.Now().Add(ttl)}
+}
+
+// forget drops the cached answer for fullPath; every operation that creates or removes an object
+// calls it once the change has been made.
+func (c *existsCache) forget(fullPath string) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.entries, fullPath)
+	if l := c.lookups[fullPath]; l != nil {
+		l.epoch++
+	}
+}
+
+// Exists reports whether an object is present at filePath without downloading it. A missing object
+// is (false, nil); any other failure, such as a permission error, is returned as an error.
+//
+// With ExistsCacheTTL set, answers are cached for that long. Changes made through this GCPFS
+// invalidate the entry straight away, but changes made by anyone else can go unseen for up to the TTL.
+func (gcp *GCPController) Exists(g *GCPFS, filePath string) (bool, error) {
+	defer g.startOp()()
+	fullPath, err := g.objectPath(filePath)
+	if err != nil {
+		return false, err
+	}
+	if exists, ok := g.exists.get(fullPath); ok {
+		return exists, nil
+	}
+	epoch := g.exists.begin(fullPath)
+	defer g.exists.end(fullPath)
+	ctx, cancel := g.opContext(g.config.MetadataTimeout)
+	defer cancel()
+	o := g.bucket(g.config.BucketName).Object(fullPath)
+	err = g.retry(ctx, func() error {
+		_, err := o.Attrs(ctx)
+		return err
+	})
+	if err == storage.ErrObjectNotExist {
+		g.exists.set(fullPath, epoch, false, g.config.ExistsCacheTTL)
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("object(%s) existence cannot be checked: %w", fullPath, wrapGCSError(ctx, err))
+	}
+	g.exists.set(fullPath, epoch, true, g.config.ExistsCacheTTL)
+	return true, nil
+}
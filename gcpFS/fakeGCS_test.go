@@ -0,0 +1,973 @@
+package gcpFS
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"google.golang.org/api/option"
+	raw "google.golang.org/api/storage/v1"
+)
+
+// fakeGCS is a small in-memory implementation of the parts of the GCS JSON and XML APIs the
+// package uses. It honours generation/metageneration preconditions, versioning, customer
+// supplied keys and holds so the precondition paths can be tested without a real bucket.
+type fakeGCS struct {
+	t   testing.TB
+	srv *httptest.Server
+
+	mu      sync.Mutex
+	buckets map[string]*fakeBucket
+	uploads map[string]*fakeUpload
+	denied  map[string]bool
+	nextGen int64
+	nextID  int
+
+	// mediaBytes counts object body bytes served by downloads.
+	mediaBytes int64
+	// requests counts requests by method and API ("GET media", "PATCH json" ...).
+	requests map[string]int
+}
+
+type fakeBucket struct {
+	attrs         raw.Bucket
+	live          map[string]*fakeObject
+	archived      []*fakeObject
+	versioning    bool
+	requesterPays bool
+	uniformAccess bool
+	policy        *raw.Policy
+	granted       []string
+}
+
+type fakeObject struct {
+	attrs  raw.Object
+	data   []byte
+	keySHA string
+}
+
+type fakeUpload struct {
+	bucket string
+	obj    raw.Object
+	query  url.Values
+	header http.Header
+	data   []byte
+}
+
+func newFakeGCS(t testing.TB) *fakeGCS {
+	f := &fakeGCS{
+		t:        t,
+		buckets:  make(map[string]*fakeBucket),
+		uploads:  make(map[string]*fakeUpload),
+		denied:   make(map[string]bool),
+		requests: make(map[string]int),
+		nextGen:  1000,
+	}
+	f.srv = httptest.NewServer(http.HandlerFunc(f.serveHTTP))
+	t.Cleanup(f.srv.Close)
+	return f
+}
+
+// client returns a storage client talking to the fake. The optional transport wrapper lets
+// tests inject failures in front of the fake.
+func (f *fakeGCS) client(wrap func(http.RoundTripper) http.RoundTripper) *storage.Client {
+	var rt http.RoundTripper = http.DefaultTransport.(*http.Transport).Clone()
+	if wrap != nil {
+		rt = wrap(rt)
+	}
+	c, err := storage.NewClient(context.Background(),
+		option.WithEndpoint(f.srv.URL+"/storage/v1/"),
+		option.WithHTTPClient(&http.Client{Transport: rt}))
+	if err != nil {
+		f.t.Fatalf("storage.NewClient: %v", err)
+	}
+	return c
+}
+
+func (f *fakeGCS) createBucket(name string) *fakeBucket {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.createBucketLocked(name, "US", "STANDARD")
+}
+
+func (f *fakeGCS) createBucketLocked(name, location, class string) *fakeBucket {
+	b := &fakeBucket{
+		attrs: raw.Bucket{Name: name, Location: location, StorageClass: class, TimeCreated: time.Now().UTC().Format(time.RFC3339Nano)},
+		live:  make(map[string]*fakeObject),
+	}
+	f.buckets[name] = b
+	return b
+}
+
+func (f *fakeGCS) bucket(name string) *fakeBucket {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.buckets[name]
+}
+
+// deny makes every request for bucket/object answer 403.
+func (f *fakeGCS) deny(bucket, object string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.denied[bucket+"/"+object] = true
+}
+
+// object returns a copy of the live object, or nil.
+func (f *fakeGCS) object(bucket, name string) *fakeObject {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	b := f.buckets[bucket]
+	if b == nil || b.live[name] == nil {
+		return nil
+	}
+	o := *b.live[name]
+	return &o
+}
+
+func (f *fakeGCS) objectNames(bucket string) []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var names []string
+	for n := range f.buckets[bucket].live {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// put seeds an object directly, bypassing the API.
+func (f *fakeGCS) put(bucket, name string, data []byte, meta map[string]string) *fakeObject {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	b := f.buckets[bucket]
+	o := &fakeObject{data: data, attrs: raw.Object{Name: name, Metadata: meta}}
+	f.storeLocked(b, o, false)
+	return o
+}
+
+func (f *fakeGCS) count(key string) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.requests[key]
+}
+
+func (f *fakeGCS) newGenLocked() int64 {
+	f.nextGen++
+	return f.nextGen
+}
+
+// storeLocked finalises o as the new live generation of its name.
+func (f *fakeGCS) storeLocked(b *fakeBucket, o *fakeObject, composite bool) {
+	now := time.Now().UTC().Format(time.RFC3339Nano)
+	o.attrs.Bucket = b.attrs.Name
+	o.attrs.Size = uint64(len(o.data))
+	o.attrs.Generation = f.newGenLocked()
+	o.attrs.Metageneration = 1
+	o.attrs.TimeCreated = now
+	o.attrs.Updated = now
+	if o.attrs.StorageClass == "" {
+		o.attrs.StorageClass = b.attrs.StorageClass
+	}
+	sum := crc32.Checksum(o.data, crc32.MakeTable(crc32.Castagnoli))
+	var crc [4]byte
+	binary.BigEndian.PutUint32(crc[:], sum)
+	o.attrs.Crc32c = base64.StdEncoding.EncodeToString(crc[:])
+	o.attrs.Md5Hash = ""
+	if !composite {
+		m := md5.Sum(o.data)
+		o.attrs.Md5Hash = base64.StdEncoding.EncodeToString(m[:])
+	} else {
+		o.attrs.ComponentCount = 1
+	}
+	o.attrs.Etag = strconv.FormatInt(o.attrs.Generation, 10)
+	if prev := b.live[o.attrs.Name]; prev != nil && b.versioning {
+		archived := *prev
+		archived.attrs.TimeDeleted = now
+		b.archived = append(b.archived, &archived)
+	}
+	b.live[o.attrs.Name] = o
+}
+
+type fakeError struct {
+	code   int
+	reason string
+	msg    string
+}
+
+func (f *fakeGCS) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	segs := strings.Split(strings.TrimPrefix(r.URL.EscapedPath(), "/"), "/")
+	for i, s := range segs {
+		segs[i], _ = url.PathUnescape(s)
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	var (
+		res  interface{}
+		ferr *fakeError
+	)
+	switch {
+	case len(segs) >= 3 && segs[0] == "upload" && segs[1] == "storage":
+		f.requests[r.Method+" upload"]++
+		res, ferr = f.upload(r, segs[4])
+	case len(segs) >= 2 && segs[0] == "storage" && segs[1] == "v1":
+		f.requests[r.Method+" json"]++
+		res, ferr = f.json(r, segs[2:])
+	default:
+		f.requests[r.Method+" media"]++
+		bucket, object := segs[0], strings.Join(segs[1:], "/")
+		if r.Method == http.MethodPut {
+			res, ferr = f.xmlPut(r, bucket, object)
+			break
+		}
+		if ferr = f.media(w, r, bucket, object); ferr == nil {
+			return
+		}
+	}
+	if ferr != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(ferr.code)
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"error": map[string]interface{}{
+			"code": ferr.code, "message": ferr.msg,
+			"errors": []map[string]string{{"reason": ferr.reason, "message": ferr.msg}},
+		}})
+		return
+	}
+	if loc, ok := res.(fakeLocation); ok {
+		w.Header().Set("Location", string(loc))
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	if inc, ok := res.(fakeIncomplete); ok {
+		if inc > 0 {
+			w.Header().Set("Range", fmt.Sprintf("bytes=0-%d", inc-1))
+		}
+		// The client sends X-GUploader-No-308, asking for 200 plus an override header.
+		w.Header().Set("X-Http-Status-Code-Override", "308")
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if res == nil {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	_ = json.NewEncoder(w).Encode(res)
+}
+
+type fakeLocation string
+type fakeIncomplete int64
+
+func errNotFound(what string) *fakeError {
+	return &fakeError{code: 404, reason: "notFound", msg: what + " not found"}
+}
+
+func errPrecondition() *fakeError {
+	return &fakeError{code: 412, reason: "conditionNotMet", msg: "Precondition Failed"}
+}
+
+func (f *fakeGCS) checkBucket(r *http.Request, name, object string) (*fakeBucket, *fakeError) {
+	b := f.buckets[name]
+	if b == nil {
+		return nil, errNotFound("bucket " + name)
+	}
+	if f.denied[name+"/"+object] || f.denied[name+"/*"] {
+		return nil, &fakeError{code: 403, reason: "forbidden", msg: "caller does not have storage.objects access"}
+	}
+	if b.requesterPays && r.URL.Query().Get("userProject") == "" && r.Header.Get("X-Goog-User-Project") == "" {
+		return nil, &fakeError{code: 400, reason: "required", msg: "Bucket is a requester pays bucket but no user project provided."}
+	}
+	return b, nil
+}
+
+// checkConds evaluates the JSON API preconditions in q (with the given prefix, "" or "Source")
+// against the live object.
+func checkConds(q url.Values, prefix string, o *fakeObject) *fakeError {
+	get := func(name string) (int64, bool) {
+		v := q.Get("if" + prefix + name)
+		if v == "" {
+			return 0, false
+		}
+		n, _ := strconv.ParseInt(v, 10, 64)
+		return n, true
+	}
+	var gen, mgen int64
+	if o != nil {
+		gen, mgen = o.attrs.Generation, o.attrs.Metageneration
+	}
+	if v, ok := get("GenerationMatch"); ok && v != gen {
+		return errPrecondition()
+	}
+	if v, ok := get("GenerationNotMatch"); ok && (o == nil || v == gen) {
+		return errPrecondition()
+	}
+	if v, ok := get("MetagenerationMatch"); ok && (o == nil || v != mgen) {
+		return errPrecondition()
+	}
+	if v, ok := get("MetagenerationNotMatch"); ok && (o == nil || v == mgen) {
+		return errPrecondition()
+	}
+	return nil
+}
+
+func keySHA(h http.Header, prefix string) string {
+	return h.Get("X-Goog-" + prefix + "Encryption-Key-Sha256")
+}
+
+func (b *fakeBucket) find(name string, q url.Values) *fakeObject {
+	if g := q.Get("generation"); g != "" {
+		gen, _ := strconv.ParseInt(g, 10, 64)
+		if o := b.live[name]; o != nil && o.attrs.Generation == gen {
+			return o
+		}
+		for _, o := range b.archived {
+			if o.attrs.Name == name && o.attrs.Generation == gen {
+				return o
+			}
+		}
+		return nil
+	}
+	return b.live[name]
+}
+
+func (o *fakeObject) resource() *raw.Object {
+	a := o.attrs
+	if o.keySHA != "" {
+		a.CustomerEncryption = &raw.ObjectCustomerEncryption{EncryptionAlgorithm: "AES256", KeySha256: o.keySHA}
+	}
+	return &a
+}
+
+func (f *fakeGCS) json(r *http.Request, segs []string) (interface{}, *fakeError) {
+	q := r.URL.Query()
+	if len(segs) == 0 || segs[0] != "b" {
+		return nil, errNotFound("resource")
+	}
+	// Bucket collection.
+	if len(segs) == 1 {
+		switch r.Method {
+		case http.MethodGet:
+			var out raw.Buckets
+			var names []string
+			for n := range f.buckets {
+				names = append(names, n)
+			}
+			sort.Strings(names)
+			for _, n := range names {
+				a := f.buckets[n].attrs
+				out.Items = append(out.Items, &a)
+			}
+			return &out, nil
+		case http.MethodPost:
+			var a raw.Bucket
+			_ = json.NewDecoder(r.Body).Decode(&a)
+			if f.buckets[a.Name] != nil {
+				return nil, &fakeError{code: 409, reason: "conflict", msg: "bucket already exists"}
+			}
+			if a.Location == "" {
+				a.Location = "US"
+			}
+			if a.StorageClass == "" {
+				a.StorageClass = "STANDARD"
+			}
+			b := f.createBucketLocked(a.Name, strings.ToUpper(a.Location), a.StorageClass)
+			return &b.attrs, nil
+		}
+	}
+	b, ferr := f.checkBucket(r, segs[1], "")
+	if ferr != nil {
+		return nil, ferr
+	}
+	if len(segs) == 2 {
+		a := b.attrs
+		if b.uniformAccess {
+			a.IamConfiguration = &raw.BucketIamConfiguration{UniformBucketLevelAccess: &raw.BucketIamConfigurationUniformBucketLevelAccess{Enabled: true}}
+		}
+		if b.versioning {
+			a.Versioning = &raw.BucketVersioning{Enabled: true}
+		}
+		return &a, nil
+	}
+	switch segs[2] {
+	case "iam":
+		if len(segs) == 4 && segs[3] == "testPermissions" {
+			var held []string
+			for _, p := range q["permissions"] {
+				for _, g := range b.granted {
+					if p == g {
+						held = append(held, p)
+					}
+				}
+			}
+			return &raw.TestIamPermissionsResponse{Permissions: held}, nil
+		}
+		if b.policy == nil {
+			return &raw.Policy{Etag: "CAE="}, nil
+		}
+		return b.policy, nil
+	case "o":
+	default:
+		return nil, errNotFound("resource")
+	}
+	if len(segs) == 3 {
+		return f.list(b, q), nil
+	}
+	name := segs[3]
+	if _, ferr := f.checkBucket(r, b.attrs.Name, name); ferr != nil {
+		return nil, ferr
+	}
+	if len(segs) > 4 {
+		switch segs[4] {
+		case "compose":
+			return f.compose(r, b, name)
+		case "rewriteTo":
+			return f.rewrite(r, b, name, segs[6], strings.Join(segs[8:], "/"))
+		case "acl":
+			o := b.live[name]
+			if o == nil {
+				return nil, errNotFound("object")
+			}
+			if b.uniformAccess {
+				return nil, &fakeError{code: 400, reason: "invalid", msg: "Cannot use ACL API to update object policy when uniform bucket-level access is enabled."}
+			}
+			var acl raw.ObjectAccessControl
+			_ = json.NewDecoder(r.Body).Decode(&acl)
+			if len(segs) > 5 {
+				acl.Entity = segs[5]
+			}
+			o.attrs.Acl = append(o.attrs.Acl, &raw.ObjectAccessControl{Entity: acl.Entity, Role: acl.Role})
+			return &acl, nil
+		}
+		return nil, errNotFound("resource")
+	}
+	o := b.find(name, q)
+	switch r.Method {
+	case http.MethodGet:
+		if o == nil {
+			return nil, errNotFound("object")
+		}
+		if ferr := checkConds(q, "", o); ferr != nil {
+			return nil, ferr
+		}
+		res := o.resource()
+		// Like GCS, leave out the hashes of an object encrypted with a customer-supplied key unless
+		// the key is sent along.
+		if o.keySHA != "" && keySHA(r.Header, "") != o.keySHA {
+			res.Crc32c, res.Md5Hash = "", ""
+		}
+		return res, nil
+	case http.MethodDelete:
+		if o == nil {
+			return nil, errNotFound("object")
+		}
+		if ferr := checkConds(q, "", o); ferr != nil {
+			return nil, ferr
+		}
+		if o.attrs.TemporaryHold || o.attrs.EventBasedHold {
+			return nil, &fakeError{code: 403, reason: "retentionPolicyNotMet", msg: "Object is under active hold and cannot be deleted."}
+		}
+		if b.live[name] == o {
+			delete(b.live, name)
+			if b.versioning {
+				o.attrs.TimeDeleted = time.Now().UTC().Format(time.RFC3339Nano)
+				b.archived = append(b.archived, o)
+			}
+		} else {
+			for i, a := range b.archived {
+				if a == o {
+					b.archived = append(b.archived[:i], b.archived[i+1:]...)
+					break
+				}
+			}
+		}
+		return nil, nil
+	case http.MethodPatch:
+		if o == nil {
+			return nil, errNotFound("object")
+		}
+		if ferr := checkConds(q, "", o); ferr != nil {
+			return nil, ferr
+		}
+		var patch map[string]json.RawMessage
+		_ = json.NewDecoder(r.Body).Decode(&patch)
+		if err := applyPatch(&o.attrs, patch); err != nil {
+			return nil, &fakeError{code: 400, reason: "invalid", msg: err.Error()}
+		}
+		o.attrs.Metageneration++
+		o.attrs.Updated = time.Now().UTC().Format(time.RFC3339Nano)
+		return o.resource(), nil
+	}
+	return nil, &fakeError{code: 405, reason: "method", msg: "method not allowed"}
+}
+
+func applyPatch(a *raw.Object, patch map[string]json.RawMessage) error {
+	for k, v := range patch {
+		isNull := string(v) == "null"
+		switch k {
+		case "metadata":
+			if isNull {
+				a.Metadata = nil
+				continue
+			}
+			var m map[string]*string
+			if err := json.Unmarshal(v, &m); err != nil {
+				return err
+			}
+			if a.Metadata == nil {
+				a.Metadata = make(map[string]string)
+			}
+			for mk, mv := range m {
+				if mv == nil {
+					delete(a.Metadata, mk)
+				} else {
+					a.Metadata[mk] = *mv
+				}
+			}
+		case "contentType", "contentEncoding", "contentDisposition", "cacheControl", "contentLanguage", "customTime":
+			var s string
+			if !isNull {
+				if err := json.Unmarshal(v, &s); err != nil {
+					return err
+				}
+			}
+			switch k {
+			case "contentType":
+				a.ContentType = s
+			case "contentEncoding":
+				a.ContentEncoding = s
+			case "contentDisposition":
+				a.ContentDisposition = s
+			case "cacheControl":
+				a.CacheControl = s
+			case "contentLanguage":
+				a.ContentLanguage = s
+			case "customTime":
+				a.CustomTime = s
+			}
+		case "temporaryHold", "eventBasedHold":
+			var h bool
+			if err := json.Unmarshal(v, &h); err != nil {
+				return err
+			}
+			if k == "temporaryHold" {
+				a.TemporaryHold = h
+			} else {
+				a.EventBasedHold = h
+			}
+		case "acl":
+			var acl []*raw.ObjectAccessControl
+			if err := json.Unmarshal(v, &acl); err != nil {
+				return err
+			}
+			a.Acl = acl
+		}
+	}
+	return nil
+}
+
+func (f *fakeGCS) list(b *fakeBucket, q url.Values) *raw.Objects {
+	prefix, delim := q.Get("prefix"), q.Get("delimiter")
+	var all []*fakeObject
+	for _, o := range b.live {
+		all = append(all, o)
+	}
+	if q.Get("versions") == "true" {
+		all = append(all, b.archived...)
+	}
+	sort.Slice(all, func(i, j int) bool {
+		if all[i].attrs.Name != all[j].attrs.Name {
+			return all[i].attrs.Name < all[j].attrs.Name
+		}
+		return all[i].attrs.Generation < all[j].attrs.Generation
+	})
+	type entry struct {
+		key    string
+		obj    *fakeObject
+		prefix string
+	}
+	var entries []entry
+	seen := make(map[string]bool)
+	for _, o := range all {
+		n := o.attrs.Name
+		if !strings.HasPrefix(n, prefix) || n < q.Get("startOffset") {
+			continue
+		}
+		if delim != "" {
+			if i := strings.Index(n[len(prefix):], delim); i >= 0 {
+				p := n[:len(prefix)+i+len(delim)]
+				if !seen[p] {
+					seen[p] = true
+					entries = append(entries, entry{key: p, prefix: p})
+				}
+				continue
+			}
+		}
+		entries = append(entries, entry{key: n + "#" + strconv.FormatInt(o.attrs.Generation, 10), obj: o})
+	}
+	sort.SliceStable(entries, func(i, j int) bool { return entries[i].key < entries[j].key })
+	start := 0
+	if tok := q.Get("pageToken"); tok != "" {
+		start, _ = strconv.Atoi(tok)
+	}
+	end := len(entries)
+	if m, _ := strconv.Atoi(q.Get("maxResults")); m > 0 && start+m < end {
+		end = start + m
+	}
+	out := &raw.Objects{}
+	if start < len(entries) {
+		for _, e := range entries[start:end] {
+			if e.obj != nil {
+				out.Items = append(out.Items, e.obj.resource())
+			} else {
+				out.Prefixes = append(out.Prefixes, e.prefix)
+			}
+		}
+	}
+	if end < len(entries) {
+		out.NextPageToken = strconv.Itoa(end)
+	}
+	return out
+}
+
+func (f *fakeGCS) compose(r *http.Request, b *fakeBucket, name string) (interface{}, *fakeError) {
+	var req raw.ComposeRequest
+	_ = json.NewDecoder(r.Body).Decode(&req)
+	if len(req.SourceObjects) > 32 {
+		return nil, &fakeError{code: 400, reason: "invalid", msg: "too many source objects"}
+	}
+	var data []byte
+	for _, s := range req.SourceObjects {
+		src := b.live[s.Name]
+		if s.Generation != 0 {
+			src = b.find(s.Name, url.Values{"generation": {strconv.FormatInt(s.Generation, 10)}})
+		}
+		if src == nil {
+			return nil, errNotFound("source object " + s.Name)
+		}
+		if p := s.ObjectPreconditions; p != nil && p.IfGenerationMatch != 0 && p.IfGenerationMatch != src.attrs.Generation {
+			return nil, errPrecondition()
+		}
+		data = append(data, src.data...)
+	}
+	if ferr := checkConds(r.URL.Query(), "", b.live[name]); ferr != nil {
+		return nil, ferr
+	}
+	o := &fakeObject{data: data, keySHA: keySHA(r.Header, "")}
+	if req.Destination != nil {
+		o.attrs = *req.Destination
+	}
+	o.attrs.Name = name
+	f.storeLocked(b, o, true)
+	return o.resource(), nil
+}
+
+func (f *fakeGCS) rewrite(r *http.Request, sb *fakeBucket, sname, dbucket, dname string) (interface{}, *fakeError) {
+	q := r.URL.Query()
+	src := sb.find(sname, url.Values{"generation": {q.Get("sourceGeneration")}})
+	if q.Get("sourceGeneration") == "" {
+		src = sb.live[sname]
+	}
+	if src == nil {
+		return nil, errNotFound("source object")
+	}
+	if src.keySHA != "" && keySHA(r.Header, "Copy-Source-") != src.keySHA {
+		return nil, &fakeError{code: 400, reason: "resourceIsEncryptedWithCustomerEncryptionKey", msg: "The source object is encrypted by a customer-supplied encryption key."}
+	}
+	if ferr := checkConds(q, "Source", src); ferr != nil {
+		return nil, ferr
+	}
+	db, ferr := f.checkBucket(r, dbucket, dname)
+	if ferr != nil {
+		return nil, ferr
+	}
+	if ferr := checkConds(q, "", db.live[dname]); ferr != nil {
+		return nil, ferr
+	}
+	var body raw.Object
+	_ = json.NewDecoder(r.Body).Decode(&body)
+	dst := &fakeObject{data: append([]byte(nil), src.data...), keySHA: keySHA(r.Header, ""), attrs: src.attrs}
+	dst.attrs.Acl = nil
+	overrideAttrs(&dst.attrs, &body)
+	if k := q.Get("destinationKmsKeyName"); k != "" {
+		dst.attrs.KmsKeyName = k
+	}
+	dst.attrs.Name = dname
+	f.storeLocked(db, dst, src.attrs.ComponentCount > 0)
+	return &raw.RewriteResponse{Done: true, ObjectSize: int64(len(dst.data)), TotalBytesRewritten: int64(len(dst.data)), Resource: dst.resource()}, nil
+}
+
+func overrideAttrs(dst, src *raw.Object) {
+	if src.ContentType != "" {
+		dst.ContentType = src.ContentType
+	}
+	if src.ContentEncoding != "" {
+		dst.ContentEncoding = src.ContentEncoding
+	}
+	if src.ContentDisposition != "" {
+		dst.ContentDisposition = src.ContentDisposition
+	}
+	if src.CacheControl != "" {
+		dst.CacheControl = src.CacheControl
+	}
+	if src.StorageClass != "" {
+		dst.StorageClass = src.StorageClass
+	}
+	if src.Metadata != nil {
+		dst.Metadata = src.Metadata
+	}
+	if src.CustomTime != "" {
+		dst.CustomTime = src.CustomTime
+	}
+	if src.KmsKeyName != "" {
+		dst.KmsKeyName = src.KmsKeyName
+	}
+	if src.TemporaryHold {
+		dst.TemporaryHold = true
+	}
+	if src.EventBasedHold {
+		dst.EventBasedHold = true
+	}
+}
+
+func (f *fakeGCS) upload(r *http.Request, bucket string) (interface{}, *fakeError) {
+	q := r.URL.Query()
+	if id := q.Get("upload_id"); id != "" {
+		return f.resumeUpload(r, id)
+	}
+	b, ferr := f.checkBucket(r, bucket, q.Get("name"))
+	if ferr != nil {
+		return nil, ferr
+	}
+	var obj raw.Object
+	var data []byte
+	switch q.Get("uploadType") {
+	case "multipart":
+		_, params, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+		if err != nil {
+			return nil, &fakeError{code: 400, reason: "invalid", msg: err.Error()}
+		}
+		mr := multipart.NewReader(r.Body, params["boundary"])
+		meta, err := mr.NextPart()
+		if err != nil {
+			return nil, &fakeError{code: 400, reason: "invalid", msg: err.Error()}
+		}
+		_ = json.NewDecoder(meta).Decode(&obj)
+		media, err := mr.NextPart()
+		if err != nil {
+			return nil, &fakeError{code: 400, reason: "invalid", msg: err.Error()}
+		}
+		// A body cut short (an aborted upload) must not be committed.
+		if data, err = io.ReadAll(media); err != nil {
+			return nil, &fakeError{code: 400, reason: "invalid", msg: "incomplete upload body: " + err.Error()}
+		}
+		if _, err := mr.NextPart(); err != io.EOF {
+			return nil, &fakeError{code: 400, reason: "invalid", msg: "incomplete multipart body"}
+		}
+		if obj.ContentType == "" {
+			obj.ContentType = media.Header.Get("Content-Type")
+		}
+	case "resumable":
+		_ = json.NewDecoder(r.Body).Decode(&obj)
+		if obj.ContentType == "" {
+			obj.ContentType = r.Header.Get("X-Upload-Content-Type")
+		}
+		f.nextID++
+		id := strconv.Itoa(f.nextID)
+		f.uploads[id] = &fakeUpload{bucket: b.attrs.Name, obj: obj, query: q, header: r.Header.Clone()}
+		return fakeLocation(fmt.Sprintf("%s/upload/storage/v1/b/%s/o?uploadType=resumable&upload_id=%s", f.srv.URL, bucket, id)), nil
+	default:
+		var err error
+		if data, err = io.ReadAll(r.Body); err != nil {
+			return nil, &fakeError{code: 400, reason: "invalid", msg: "incomplete upload body: " + err.Error()}
+		}
+	}
+	return f.finishUpload(b, obj, q, r.Header, data)
+}
+
+func (f *fakeGCS) resumeUpload(r *http.Request, id string) (interface{}, *fakeError) {
+	u := f.uploads[id]
+	if u == nil {
+		return nil, errNotFound("upload")
+	}
+	if r.Method == http.MethodDelete {
+		delete(f.uploads, id)
+		return nil, &fakeError{code: 499, reason: "cancelled", msg: "upload cancelled"}
+	}
+	chunk, err := io.ReadAll(r.Body)
+	if err != nil {
+		return nil, &fakeError{code: 400, reason: "invalid", msg: "incomplete upload body: " + err.Error()}
+	}
+	cr := r.Header.Get("Content-Range")
+	total := cr[strings.LastIndex(cr, "/")+1:]
+	if !strings.HasPrefix(cr, "bytes */") {
+		startEnd := strings.TrimPrefix(cr[:strings.LastIndex(cr, "/")], "bytes ")
+		start, _ := strconv.ParseInt(strings.Split(startEnd, "-")[0], 10, 64)
+		if start == int64(len(u.data)) {
+			u.data = append(u.data, chunk...)
+		}
+	}
+	if total == "*" {
+		return fakeIncomplete(len(u.data)), nil
+	}
+	delete(f.uploads, id)
+	return f.finishUpload(f.buckets[u.bucket], u.obj, u.query, u.header, u.data)
+}
+
+func (f *fakeGCS) finishUpload(b *fakeBucket, obj raw.Object, q url.Values, h http.Header, data []byte) (interface{}, *fakeError) {
+	if obj.Name == "" {
+		obj.Name = q.Get("name")
+	}
+	if ferr := checkConds(q, "", b.live[obj.Name]); ferr != nil {
+		return nil, ferr
+	}
+	if obj.Md5Hash != "" {
+		m := md5.Sum(data)
+		if obj.Md5Hash != base64.StdEncoding.EncodeToString(m[:]) {
+			return nil, &fakeError{code: 400, reason: "invalid", msg: "Provided MD5 hash doesn't match calculated MD5 hash."}
+		}
+	}
+	if obj.Crc32c != "" {
+		var crc [4]byte
+		binary.BigEndian.PutUint32(crc[:], crc32.Checksum(data, crc32.MakeTable(crc32.Castagnoli)))
+		if obj.Crc32c != base64.StdEncoding.EncodeToString(crc[:]) {
+			return nil, &fakeError{code: 400, reason: "invalid", msg: "Provided CRC32C \"" + obj.Crc32c + "\" doesn't match calculated CRC32C."}
+		}
+	}
+	if acl := q.Get("predefinedAcl"); acl != "" {
+		if b.uniformAccess {
+			return nil, &fakeError{code: 400, reason: "invalid", msg: "Cannot insert legacy ACL for an object when uniform bucket-level access is enabled."}
+		}
+		obj.Acl = []*raw.ObjectAccessControl{{Entity: "predefined-" + acl, Role: "READER"}}
+		if acl == "publicRead" {
+			obj.Acl = []*raw.ObjectAccessControl{{Entity: "allUsers", Role: "READER"}}
+		}
+	}
+	if k := q.Get("kmsKeyName"); k != "" {
+		obj.KmsKeyName = k
+	}
+	o := &fakeObject{attrs: obj, data: data, keySHA: keySHA(h, "")}
+	o.attrs.Md5Hash, o.attrs.Crc32c = "", ""
+	f.storeLocked(b, o, false)
+	return o.resource(), nil
+}
+
+func (f *fakeGCS) xmlPut(r *http.Request, bucket, name string) (interface{}, *fakeError) {
+	b, ferr := f.checkBucket(r, bucket, name)
+	if ferr != nil {
+		return nil, ferr
+	}
+	if r.URL.Query().Get("X-Goog-Signature") == "" {
+		return nil, &fakeError{code: 403, reason: "forbidden", msg: "anonymous caller"}
+	}
+	data, _ := io.ReadAll(r.Body)
+	return f.finishUpload(b, raw.Object{Name: name, ContentType: r.Header.Get("Content-Type")}, url.Values{}, r.Header, data)
+}
+
+func (f *fakeGCS) media(w http.ResponseWriter, r *http.Request, bucket, name string) *fakeError {
+	b, ferr := f.checkBucket(r, bucket, name)
+	if ferr != nil {
+		return ferr
+	}
+	o := b.find(name, r.URL.Query())
+	if o == nil {
+		return errNotFound("object")
+	}
+	conds := url.Values{}
+	for _, c := range []string{"GenerationMatch", "GenerationNotMatch", "MetagenerationMatch", "MetagenerationNotMatch"} {
+		if v := r.Header.Get("X-Goog-If-" + strings.Replace(strings.Replace(c, "Not", "-Not", 1), "Match", "-Match", 1)); v != "" {
+			conds.Set("if"+c, v)
+		}
+	}
+	if ferr := checkConds(conds, "", o); ferr != nil {
+		return ferr
+	}
+	if o.keySHA != "" && keySHA(r.Header, "") != o.keySHA {
+		if keySHA(r.Header, "") == "" {
+			return &fakeError{code: 400, reason: "resourceIsEncryptedWithCustomerEncryptionKey", msg: "The target object is encrypted by a customer-supplied encryption key."}
+		}
+		return &fakeError{code: 403, reason: "forbidden", msg: "The provided encryption key is incorrect."}
+	}
+	data := o.data
+	h := w.Header()
+	gzipped := o.attrs.ContentEncoding == "gzip"
+	if gzipped {
+		h.Set("X-Goog-Stored-Content-Encoding", "gzip")
+		if strings.Contains(r.Header.Get("Accept-Encoding"), "gzip") {
+			h.Set("Content-Encoding", "gzip")
+		} else {
+			zr, err := gzip.NewReader(bytes.NewReader(data))
+			if err != nil {
+				return &fakeError{code: 500, reason: "backendError", msg: err.Error()}
+			}
+			data, _ = io.ReadAll(zr)
+		}
+	}
+	h.Set("Content-Type", o.attrs.ContentType)
+	if o.attrs.CacheControl != "" {
+		h.Set("Cache-Control", o.attrs.CacheControl)
+	}
+	h.Set("X-Goog-Generation", strconv.FormatInt(o.attrs.Generation, 10))
+	h.Set("X-Goog-Metageneration", strconv.FormatInt(o.attrs.Metageneration, 10))
+	if t, err := time.Parse(time.RFC3339Nano, o.attrs.Updated); err == nil {
+		h.Set("Last-Modified", t.Format(http.TimeFormat))
+	}
+	status := http.StatusOK
+	if rng := r.Header.Get("Range"); rng != "" && !gzipped {
+		spec := strings.TrimPrefix(rng, "bytes=")
+		size := int64(len(data))
+		var start, end int64
+		if strings.HasPrefix(spec, "-") {
+			n, _ := strconv.ParseInt(spec[1:], 10, 64)
+			start, end = size-n, size-1
+			if start < 0 {
+				start = 0
+			}
+		} else {
+			parts := strings.SplitN(spec, "-", 2)
+			start, _ = strconv.ParseInt(parts[0], 10, 64)
+			end = size - 1
+			if len(parts) == 2 && parts[1] != "" {
+				end, _ = strconv.ParseInt(parts[1], 10, 64)
+			}
+			if end >= size {
+				end = size - 1
+			}
+		}
+		if start >= size {
+			return &fakeError{code: 416, reason: "requestedRangeNotSatisfiable", msg: "The requested range cannot be satisfied."}
+		}
+		h.Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end, size))
+		data = data[start : end+1]
+		status = http.StatusPartialContent
+	} else {
+		var crc [4]byte
+		binary.BigEndian.PutUint32(crc[:], crc32.Checksum(o.data, crc32.MakeTable(crc32.Castagnoli)))
+		h.Add("X-Goog-Hash", "crc32c="+base64.StdEncoding.EncodeToString(crc[:]))
+	}
+	h.Set("Content-Length", strconv.Itoa(len(data)))
+	w.WriteHeader(status)
+	if r.Method != http.MethodHead {
+		f.mediaBytes += int64(len(data))
+		_, _ = w.Write(data)
+	}
+	return nil
+}
@@ -0,0 +1,119 @@
+package gcpFS
+
+import (
+	"fmt"
+	"path"
+	"strings"
+
+	"cloud.google.com/go/storage"
+	"github.com/ninjamarcus/ninjaStorage/models"
+)
+
+// Find returns the objects whose path relative to ParentFolder matches the glob pattern, keyed by that
+// relative path. Within a path segment "*" matches any run of characters and "?" any single one, as
+// do character classes like "[a-z]" (see path.Match); none of them cross a "/". A "**" segment matches
+// any number of segments, including none, so "logs/**/*.gz" finds "logs/a.gz" and "logs/2024/01/b.gz".
+// Only the part of the bucket below the pattern's literal leading directories is listed. A pattern
+// matching nothing returns an empty map.
+func (gcp *GCPController) Find(g *GCPFS, pattern string) (map[string]*models.FileMetaData, error) {
+	defer g.startOp()()
+	pattern = strings.TrimPrefix(pattern, "/")
+	if g.config.LowercaseKeys {
+		pattern = strings.ToLower(pattern)
+	}
+	segments := strings.Split(pattern, "/")
+	for _, seg := range segments {
+		if seg != "**" {
+			// path.Match only reports a malformed pattern when it gets to it, so check each segment upfront.
+			if _, err := path.Match(seg, ""); err != nil {
+				return nil, fmt.Errorf("invalid pattern %q: %v", pattern, err)
+			}
+		}
+	}
+	// Leading segments without wildcards narrow the listing.
+	var literal []string
+	for _, seg := range segments[:len(segments)-1] {
+		if strings.ContainsAny(seg, `*?[\`) {
+			break
+		}
+		literal = append(literal, seg)
+	}
+
+	fullPrefix, err := g.listPrefix(strings.Join(literal, "/"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid pattern %q: %w", pattern, err)
+	}
+	ctx, cancel := g.opContext(g.config.ListTimeout)
+	defer cancel()
+	results := make(map[string]*models.FileMetaData)
+	err = g.listQuery(ctx, &storage.Query{Prefix: fullPrefix}, func(attrs *storage.ObjectAttrs) {
+		name := g.relativeName(attrs.Name)
+		if matchSegments(segments, strings.Split(name, "/")) {
+			results[name] = g.parseMetaData(attrs)
+		}
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Bucket(%s).Objects: %w", g.config.BucketName, wrapGCSError(ctx, err))
+	}
+	return results, nil
+}
+
+// ListByMetadata lists prefix like List but keeps only the objects whose user metadata holds every
+// key of match with the same value, e.g. {"tenant": "acme"}. GCS cannot filter on metadata, so the
+// whole prefix is listed and filtered here: narrow the prefix where possible. An empty match
+// returns everything under prefix.
+func (gcp *GCPController) ListByMetadata(g *GCPFS, prefix string, match map[string]string) (map[string]*models.FileMetaData, error) {
+	defer g.startOp()()
+	fullPrefix, err := g.listPrefix(prefix)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := g.opContext(g.config.ListTimeout)
+	defer cancel()
+	results := make(map[string]*models.FileMetaData)
+	err = g.listQuery(ctx, &storage.Query{Prefix: fullPrefix}, func(attrs *storage.ObjectAttrs) {
+		if matchMetadata(attrs.Metadata, match) {
+			results[g.relativeName(attrs.Name)] = g.parseMetaData(attrs)
+		}
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Bucket(%s).Objects: %w", g.config.BucketName, wrapGCSError(ctx, err))
+	}
+	return results, nil
+}
+
+// matchMetadata reports whether meta holds every pair of match.
+func matchMetadata(meta, match map[string]string) bool {
+	for k, want := range match {
+		if v, ok := meta[k]; !ok || v != want {
+			return false
+		}
+	}
+	return true
+}
+
+// matchSegments matches a name split on "/" against a pattern split the same way, with "**" standing
+// for zero or more whole segments.
+func matchSegments(pattern, name []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			for rest := pattern[1:]; len(rest) > 0 && rest[0] == "**"; rest = rest[1:] {
+				pattern = rest
+			}
+			for i := 0; i <= len(name); i++ {
+				if matchSegments(pattern[1:], name[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(name) == 0 {
+			return false
+		}
+		if ok, _ := path.Match(pattern[0], name[0]); !ok {
+			return false
+		}
+		pattern, name = pattern[1:], name[1:]
+	}
+	return len(name) == 0
+}
@@ -0,0 +1,81 @@
+package gcpFS
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"strings"
+
+	"cloud.google.com/go/storage"
+	"github.com/ninjamarcus/ninjaStorage/models"
+	"google.golang.org/api/iterator"
+)
+
+// ListDir lists one level of the folder prefix, like a file browser: files holds the objects
+// directly in it and prefixes the sub-folders below it, in order, each ending in "/". Both are
+// relative to prefix itself, so listing "a/" over a/b.txt and a/c/d.txt gives the file "b.txt"
+// and the prefix "c/". prefix is always taken as a folder, "a" lists the same as "a/", and the
+// empty prefix lists ParentFolder. The folder's own placeholder object, if any, is left out.
+func (gcp *GCPController) ListDir(g *GCPFS, prefix string) (map[string]*models.FileMetaData, []string, error) {
+	defer g.startOp()()
+	dir, err := g.listPrefix(prefix)
+	if err != nil {
+		return nil, nil, err
+	}
+	ctx, cancel := g.opContext(g.config.ListTimeout)
+	defer cancel()
+	if !strings.HasSuffix(dir, "/") {
+		dir += "/"
+	}
+
+	files := make(map[string]*models.FileMetaData)
+	var prefixes []string
+	err = g.listQuery(ctx, &storage.Query{Prefix: dir, Delimiter: "/"}, func(attrs *storage.ObjectAttrs) {
+		switch {
+		case attrs.Prefix != "":
+			prefixes = append(prefixes, strings.TrimPrefix(attrs.Prefix, dir))
+		case attrs.Name != dir:
+			files[strings.TrimPrefix(attrs.Name, dir)] = g.parseMetaData(attrs)
+		}
+	})
+	if err != nil {
+		return nil, nil, fmt.Errorf("Bucket(%s).Objects: %w", g.config.BucketName, wrapGCSError(ctx, err))
+	}
+	return files, prefixes, nil
+}
+
+// cleanupFolderPlaceholders removes the "folder/" placeholder objects left behind once the last real
+// object under a logical folder has been deleted, working up from the folder of fullPath until it
+// reaches a folder that still has content or the ParentFolder.
+func (g *GCPFS) cleanupFolderPlaceholders(ctx context.Context, fullPath string) error {
+	root := path.Join(g.config.ParentFolder)
+	bucket := g.rawBucket(g.config.BucketName)
+	for dir := path.Dir(fullPath); dir != root && dir != "." && dir != "/" && strings.HasPrefix(dir, root+"/"); dir = path.Dir(dir) {
+		placeholder := dir + "/"
+		it := bucket.Objects(ctx, &storage.Query{Prefix: placeholder})
+		// The placeholder sorts before everything under it, so two results are enough to tell
+		// whether anything else is left.
+		it.PageInfo().MaxSize = 2
+		onlyPlaceholder := false
+		for i := 0; i < 2; i++ {
+			attrs, err := it.Next()
+			if err == iterator.Done {
+				break
+			}
+			if err != nil {
+				return fmt.Errorf("Bucket(%s).Objects: %v", g.config.BucketName, err)
+			}
+			onlyPlaceholder = i == 0 && attrs.Name == placeholder
+			if !onlyPlaceholder {
+				break
+			}
+		}
+		if !onlyPlaceholder {
+			return nil
+		}
+		if err := bucket.Object(placeholder).Delete(ctx); err != nil && err != storage.ErrObjectNotExist {
+			return fmt.Errorf("cannot delete folder placeholder:%s reason: %v", placeholder, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,43 @@
+package gcpFS
+
+import (
+	"fmt"
+
+	"github.com/ninjamarcus/ninjaStorage/models"
+)
+
+// GetIAMPolicy returns the IAM bindings of the configured bucket.
+// The caller needs storage.buckets.getIamPolicy on the bucket.
+func (gcp *GCPController) GetIAMPolicy(g *GCPFS) (*models.Policy, error) {
+	defer g.startOp()()
+	ctx, cancel := g.opContext(g.config.MetadataTimeout)
+	defer cancel()
+	policy, err := g.rawBucket(g.config.BucketName).IAM().Policy(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read IAM policy of bucket:%s (requires storage.buckets.getIamPolicy) reason: %v", g.config.BucketName, err)
+	}
+	result := &models.Policy{}
+	for _, role := range policy.Roles() {
+		result.Bindings = append(result.Bindings, models.PolicyBinding{
+			Role:    string(role),
+			Members: policy.Members(role),
+		})
+	}
+	return result, nil
+}
+
+// TestPermissions reports which of perms (e.g. "storage.objects.create") the caller holds on the
+// configured bucket, so applications can check their access at startup and fail fast.
+func (gcp *GCPController) TestPermissions(g *GCPFS, perms []string) ([]string, error) {
+	if len(perms) == 0 {
+		return nil, fmt.Errorf("no permissions to test")
+	}
+	defer g.startOp()()
+	ctx, cancel := g.opContext(g.config.MetadataTimeout)
+	defer cancel()
+	held, err := g.rawBucket(g.config.BucketName).IAM().TestPermissions(ctx, perms)
+	if err != nil {
+		return nil, fmt.Errorf("cannot test permissions on bucket:%s reason: %v", g.config.BucketName, err)
+	}
+	return held, nil
+}
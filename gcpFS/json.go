@@ -0,0 +1,45 @@
+package gcpFS
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/ninjamarcus/ninjaStorage/models"
+)
+
+// JSONContentType is the content type WriteJSON stores objects with.
+const JSONContentType = "application/json"
+
+// ReadJSON reads the object at filePath and decodes it into a T. Storage failures are returned as
+// from Read; content that does not decode into a T is reported wrapping the encoding/json error
+// (e.g. *json.SyntaxError or *json.UnmarshalTypeError), with the metadata of the object read.
+// Go methods cannot have type parameters, so unlike the GCPController methods this is a function.
+func ReadJSON[T any](g *GCPFS, filePath string) (T, *models.FileMetaData, error) {
+	var v T
+	data, mdata, err := (&GCPController{}).Read(g, filePath)
+	if err != nil {
+		return v, nil, err
+	}
+	if err := json.Unmarshal(data, &v); err != nil {
+		return v, mdata, fmt.Errorf("object(%s) cannot be decoded as %T: %w", filePath, v, err)
+	}
+	return v, mdata, nil
+}
+
+// WriteJSON encodes v as JSON and writes it to filePath like Write, with ContentType
+// JSONContentType unless metaData sets another. A value that cannot be encoded is reported wrapping
+// the encoding/json error and nothing is written.
+func WriteJSON[T any](g *GCPFS, filePath string, v T, metaData *models.FileMetaData) (*models.FileMetaData, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, fmt.Errorf("cannot encode %T as JSON for object(%s): %w", v, filePath, err)
+	}
+	withType := &models.FileMetaData{}
+	if metaData != nil {
+		*withType = *metaData
+	}
+	if withType.ContentType == "" {
+		withType.ContentType = JSONContentType
+	}
+	return (&GCPController{}).Write(g, data, filePath, withType)
+}
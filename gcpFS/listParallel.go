@@ -0,0 +1,92 @@
+package gcpFS
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"cloud.google.com/go/storage"
+	"github.com/ninjamarcus/ninjaStorage/models"
+	"google.golang.org/api/iterator"
+)
+
+// ListParallel lists prefix like List, but splits the work across its immediate subprefixes: a
+// first delimited query finds the objects directly under prefix and the "folders" below it, then
+// up to concurrency folders are listed at once. Without subprefixes that first query already holds
+// everything, so this costs no more than List. Keys are relative to ParentFolder.
+func (gcp *GCPController) ListParallel(g *GCPFS, prefix string, concurrency int) (map[string]*models.FileMetaData, error) {
+	defer g.startOp()()
+	if concurrency <= 0 {
+		return nil, fmt.Errorf("concurrency must be positive")
+	}
+	fullPrefix, err := g.listPrefix(prefix)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := g.opContext(g.config.ListTimeout)
+	defer cancel()
+
+	results := make(map[string]*models.FileMetaData)
+	subprefixes := make(map[string]bool)
+	err = g.listQuery(ctx, &storage.Query{Prefix: fullPrefix, Delimiter: "/"}, func(attrs *storage.ObjectAttrs) {
+		if attrs.Prefix != "" {
+			subprefixes[attrs.Prefix] = true
+			return
+		}
+		results[g.relativeName(attrs.Name)] = g.parseMetaData(attrs)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Bucket(%s).Objects: %w", g.config.BucketName, wrapGCSError(ctx, err))
+	}
+
+	var (
+		mu      sync.Mutex
+		failed  batchErrors
+		wg      sync.WaitGroup
+		workers = make(chan struct{}, concurrency)
+	)
+	for sub := range subprefixes {
+		wg.Add(1)
+		workers <- struct{}{}
+		go func(sub string) {
+			defer wg.Done()
+			defer func() { <-workers }()
+			part := make(map[string]*models.FileMetaData)
+			err := g.listQuery(ctx, &storage.Query{Prefix: sub}, func(attrs *storage.ObjectAttrs) {
+				part[g.relativeName(attrs.Name)] = g.parseMetaData(attrs)
+			})
+			if err != nil {
+				failed.add(g.relativeName(sub), wrapGCSError(ctx, err))
+				return
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			for k, v := range part {
+				results[k] = v
+			}
+		}(sub)
+	}
+	wg.Wait()
+	if err := failed.err(); err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+// listQuery runs q to completion, calling fn for every result. A failed listing is retried from the
+// start, so fn must tolerate seeing results again.
+func (g *GCPFS) listQuery(ctx context.Context, q *storage.Query, fn func(*storage.ObjectAttrs)) error {
+	return g.retry(ctx, func() error {
+		it := g.bucket(g.config.BucketName).Objects(ctx, q)
+		for {
+			attrs, err := it.Next()
+			if err == iterator.Done {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			fn(attrs)
+		}
+	})
+}
@@ -0,0 +1,119 @@
+package gcpFS
+
+import (
+	"fmt"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"github.com/ninjamarcus/ninjaStorage/models"
+)
+
+// UpdateMetadata merges patch into the user metadata of the object at filePath and drops the keys in
+// remove, leaving every other key as it was; unlike the metadata given to Write, it does not replace
+// the whole map. The merged result is checked against MetadataSchema and written under a
+// metageneration precondition, so a concurrent metadata change fails the call with an error wrapping
+// ErrPreconditionFailed rather than being overwritten. A key cannot be both patched and removed.
+// Removing keys rewrites the object in place, which changes its generation; patching alone does not.
+// The rewrite keeps the object's storage class and KMS key.
+func (gcp *GCPController) UpdateMetadata(g *GCPFS, filePath string, patch map[string]string, remove []string) (*models.FileMetaData, error) {
+	defer g.startOp()()
+	for _, k := range remove {
+		if _, ok := patch[k]; ok {
+			return nil, fmt.Errorf("metadata key %q cannot be both set and removed", k)
+		}
+	}
+	fullPath, err := g.objectPath(filePath)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := g.opContext(g.config.MetadataTimeout)
+	defer cancel()
+	o := g.rawBucket(g.config.BucketName).Object(fullPath)
+	attrs, err := o.Attrs(ctx)
+	if err == storage.ErrObjectNotExist {
+		return nil, fmt.Errorf("object(%s) cannot be found: %w", fullPath, models.ErrNotFound)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("object.Attrs: %w", wrapGCSError(ctx, err))
+	}
+
+	merged := make(map[string]string, len(attrs.Metadata)+len(patch))
+	for k, v := range attrs.Metadata {
+		merged[k] = v
+	}
+	changed, removed := false, false
+	for k, v := range patch {
+		if old, ok := merged[k]; !ok || old != v {
+			changed = true
+		}
+		merged[k] = v
+	}
+	for _, k := range remove {
+		if _, ok := merged[k]; ok {
+			delete(merged, k)
+			changed, removed = true, true
+		}
+	}
+	if !changed {
+		return g.parseMetaData(attrs), nil
+	}
+	if err := g.config.MetadataSchema.Validate(merged); err != nil {
+		return nil, err
+	}
+
+	var updated *storage.ObjectAttrs
+	if !removed {
+		// GCS merges the keys of a metadata update into the stored map.
+		updated, err = o.If(storage.Conditions{MetagenerationMatch: attrs.Metageneration}).Update(ctx, storage.ObjectAttrsToUpdate{Metadata: patch})
+	} else {
+		// The SDK cannot delete single keys in an update, only clear the lot, so the object is rewritten
+		// onto itself with the merged map instead. That is still atomic but gives it a new generation.
+		// A customer-supplied key has to be sent for both sides of the rewrite.
+		rewritten := g.keyed(o)
+		src := rewritten.If(storage.Conditions{GenerationMatch: attrs.Generation, MetagenerationMatch: attrs.Metageneration})
+		copier := rewritten.If(storage.Conditions{GenerationMatch: attrs.Generation}).CopierFrom(src)
+		copier.ObjectAttrs = storage.ObjectAttrs{
+			ContentType:        attrs.ContentType,
+			ContentEncoding:    attrs.ContentEncoding,
+			ContentLanguage:    attrs.ContentLanguage,
+			ContentDisposition: attrs.ContentDisposition,
+			CacheControl:       attrs.CacheControl,
+			CustomTime:         attrs.CustomTime,
+			StorageClass:       attrs.StorageClass,
+			Metadata:           merged,
+		}
+		copier.DestinationKMSKeyName = cryptoKeyName(attrs.KMSKeyName)
+		updated, err = copier.Run(ctx)
+	}
+	if isPreconditionFailed(err) {
+		return nil, fmt.Errorf("object(%s) changed concurrently: %w", fullPath, models.ErrPreconditionFailed)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("ObjectHandle(%q) update failed: %w", fullPath, wrapGCSError(ctx, err))
+	}
+	return g.parseMetaData(updated), nil
+}
+
+// SetCustomTime sets the CustomTime of the object at filePath, for lifecycle rules to expire it by,
+// without rewriting it. GCS refuses to move a custom time earlier than the one already set, and a
+// custom time cannot be cleared at all.
+func (gcp *GCPController) SetCustomTime(g *GCPFS, filePath string, customTime time.Time) (*models.FileMetaData, error) {
+	defer g.startOp()()
+	if customTime.IsZero() {
+		return nil, fmt.Errorf("custom time of object(%s) cannot be cleared", filePath)
+	}
+	fullPath, err := g.objectPath(filePath)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := g.opContext(g.config.MetadataTimeout)
+	defer cancel()
+	updated, err := g.rawBucket(g.config.BucketName).Object(fullPath).Update(ctx, storage.ObjectAttrsToUpdate{CustomTime: customTime})
+	if err == storage.ErrObjectNotExist {
+		return nil, fmt.Errorf("object(%s) cannot be found: %w", fullPath, models.ErrNotFound)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("cannot set custom time on object:%s reason: %w", fullPath, wrapGCSError(ctx, err))
+	}
+	return g.parseMetaData(updated), nil
+}
@@ -0,0 +1,77 @@
+package gcpFS
+
+import (
+	"context"
+	"errors"
+
+	"cloud.google.com/go/storage"
+	"google.golang.org/api/googleapi"
+)
+
+// readBuckets lists the buckets reads are served from, in the order they are tried.
+func (g *GCPFS) readBuckets() []string {
+	if g.config.MirrorBucketName == "" {
+		return []string{g.config.BucketName}
+	}
+	return []string{g.config.BucketName, g.config.MirrorBucketName}
+}
+
+// shouldFailover reports whether a read error from one bucket warrants trying the mirror: the
+// object is missing or the service had a server-side failure.
+func shouldFailover(err error) bool {
+	if err == storage.ErrObjectNotExist {
+		return true
+	}
+	var gErr *googleapi.Error
+	return errors.As(err, &gErr) && gErr.Code >= 500
+}
+
+// openReader opens fullPath from the primary bucket, falling back to the mirror when configured.
+// The returned handle belongs to whichever bucket served the object. compressed asks for
+// gzip-transcoded objects to be returned without decompression.
+func (g *GCPFS) openReader(ctx context.Context, fullPath string, compressed bool) (*storage.ObjectHandle, *storage.Reader, error) {
+	var err error
+	buckets := g.readBuckets()
+	for i, bucket := range buckets {
+		o := g.keyed(g.bucket(bucket).Object(fullPath).ReadCompressed(compressed))
+		var rc *storage.Reader
+		err = g.retry(ctx, func() (err error) {
+			rc, err = o.NewReader(ctx)
+			return err
+		})
+		if err == nil {
+			return o, rc, nil
+		}
+		if !shouldFailover(err) {
+			break
+		}
+		if i+1 < len(buckets) {
+			g.logf("failover", "bucket", bucket, "object", fullPath, "err", err)
+		}
+	}
+	return nil, nil, err
+}
+
+// objectAttrs fetches the attributes of fullPath with the same failover as openReader.
+func (g *GCPFS) objectAttrs(ctx context.Context, fullPath string) (*storage.ObjectAttrs, error) {
+	var err error
+	buckets := g.readBuckets()
+	for i, bucket := range buckets {
+		o := g.bucket(bucket).Object(fullPath)
+		var attrs *storage.ObjectAttrs
+		err = g.retry(ctx, func() (err error) {
+			attrs, err = o.Attrs(ctx)
+			return err
+		})
+		if err == nil {
+			return attrs, nil
+		}
+		if !shouldFailover(err) {
+			break
+		}
+		if i+1 < len(buckets) {
+			g.logf("failover", "bucket", bucket, "object", fullPath, "err", err)
+		}
+	}
+	return nil, err
+}
@@ -0,0 +1,89 @@
+package gcpFS
+
+import (
+	"fmt"
+
+	"cloud.google.com/go/storage"
+	"github.com/ninjamarcus/ninjaStorage/models"
+)
+
+// moveObject moves the full object name from in the configured bucket to the full object name to in
+// dstBucket. The JSON API of the SDK in use has no single-request move, so it copies and then deletes
+// the source, keeping the two steps as close to atomic as copy+delete allows: the copy is pinned to
+// the source generation seen first, a held source is refused before anything is copied, and when the
+// source cannot be deleted the copy is deleted again, so a failed move leaves only the source. With
+// Overwrite the copy may have replaced an earlier object, which deleting it would lose, so it is kept
+// and the error names both copies. Returns the destination's attributes.
+func (gcp *GCPController) moveObject(g *GCPFS, from, dstBucket, to string, opts *CopyOptions) (*storage.ObjectAttrs, error) {
+	if opts == nil {
+		opts = &CopyOptions{}
+	}
+	pinned := *opts
+	ctx, cancel := g.opContext(g.config.MetadataTimeout)
+	defer cancel()
+	src := g.bucket(g.config.BucketName).Object(from)
+	var attrs *storage.ObjectAttrs
+	err := g.retry(ctx, func() (err error) {
+		attrs, err = src.Attrs(ctx)
+		return err
+	})
+	if err == storage.ErrObjectNotExist {
+		return nil, fmt.Errorf("cannot move object:%s reason: %w", from, models.ErrNotFound)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("object.Attrs: %w", wrapGCSError(ctx, err))
+	}
+	if attrs.TemporaryHold || attrs.EventBasedHold {
+		return nil, fmt.Errorf("cannot move object:%s reason: %w", from, models.ErrObjectHeld)
+	}
+	if pinned.SourceGenerationMatch != 0 && pinned.SourceGenerationMatch != attrs.Generation {
+		return nil, fmt.Errorf("object(%s) is no longer at generation %d: %w", from, pinned.SourceGenerationMatch, models.ErrPreconditionFailed)
+	}
+	pinned.SourceGenerationMatch = attrs.Generation
+
+	copied, err := gcp.copyObject(g, from, dstBucket, to, &pinned)
+	if err != nil {
+		return nil, fmt.Errorf("could not move/copy file from:%s to:%s reason: %w", from, to, err)
+	}
+	if err := gcp.deleteMoved(g, from, attrs.Generation); err != nil {
+		if opts.Overwrite {
+			return nil, fmt.Errorf("could not move/delete file:%s reason: %w (the copy at %s/%s was kept, so the object is now at both paths)", from, err, dstBucket, to)
+		}
+		if rerr := gcp.undoCopy(g, dstBucket, copied); rerr != nil {
+			return nil, fmt.Errorf("could not move/delete file:%s reason: %w (and the copy at %s could not be removed: %v)", from, err, to, rerr)
+		}
+		return nil, fmt.Errorf("could not move/delete file:%s reason: %w", from, err)
+	}
+	return copied, nil
+}
+
+// deleteMoved deletes the source of a move once it has been copied.
+func (gcp *GCPController) deleteMoved(g *GCPFS, fullPath string, generation int64) (err error) {
+	done := g.traceOp("delete", fullPath)
+	defer func() { done(0, err) }()
+	if err := g.throttle(); err != nil {
+		return err
+	}
+	ctx, cancel := g.opContext(g.config.MetadataTimeout)
+	defer cancel()
+	defer g.exists.forget(fullPath)
+	return g.deleteGeneration(ctx, fullPath, generation)
+}
+
+// undoCopy deletes the object a failed move copied, unless something has replaced it since.
+func (gcp *GCPController) undoCopy(g *GCPFS, dstBucket string, copied *storage.ObjectAttrs) error {
+	ctx, cancel := g.opContext(g.config.MetadataTimeout)
+	defer cancel()
+	if dstBucket == g.config.BucketName {
+		defer g.exists.forget(copied.Name)
+	}
+	o := g.bucket(dstBucket).Object(copied.Name).If(storage.Conditions{GenerationMatch: copied.Generation})
+	err := g.retry(ctx, func() error {
+		err := o.Delete(ctx)
+		if err == storage.ErrObjectNotExist {
+			return nil
+		}
+		return err
+	})
+	return wrapGCSError(ctx, err)
+}
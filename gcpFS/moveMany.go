@@ -0,0 +1,184 @@
+package gcpFS
+
+import (
+	"fmt"
+	"sync"
+
+	"cloud.google.com/go/storage"
+	"github.com/ninjamarcus/ninjaStorage/models"
+)
+
+// MoveManyOptions tunes MoveManyWithOptions.
+type MoveManyOptions struct {
+	// Overwrite lets moves replace objects already at their destination.
+	Overwrite bool
+	// DryRun runs the pre-flight checks only and moves nothing.
+	DryRun bool
+}
+
+// MoveMany renames a batch of objects, moves mapping source to destination paths. See
+// MoveManyWithOptions.
+func (gcp *GCPController) MoveMany(g *GCPFS, moves map[string]string, overwrite bool) (map[string]error, error) {
+	return gcp.MoveManyWithOptions(g, moves, &MoveManyOptions{Overwrite: overwrite})
+}
+
+// MoveManyWithOptions checks every move before starting any of them: each source must exist, no
+// two moves may share a destination or use another move's source as theirs, and, unless Overwrite
+// is set, no destination may exist yet. If any check fails nothing is moved. Otherwise the moves
+// run concurrently, each copying the source generation that was checked. opts may be nil.
+//
+// The returned map has an entry for every source in moves: nil when it was (or, with DryRun,
+// would be) moved, otherwise why not, wrapping ErrAlreadyExists or ErrNotFound for failed checks.
+// The error is a *BatchError of the failures, wrapped in a "nothing was moved" error when they came
+// from the pre-flight checks.
+func (gcp *GCPController) MoveManyWithOptions(g *GCPFS, moves map[string]string, opts *MoveManyOptions) (map[string]error, error) {
+	defer g.startOp()()
+	if opts == nil {
+		opts = &MoveManyOptions{}
+	}
+	results, generations, conflicts := gcp.planMoves(g, moves, opts.Overwrite)
+	if conflicts != nil {
+		return results, conflicts
+	}
+	if opts.DryRun {
+		return results, nil
+	}
+
+	var (
+		mu      sync.Mutex
+		failed  batchErrors
+		wg      sync.WaitGroup
+		workers = make(chan struct{}, bulkConcurrency)
+	)
+	for from, to := range moves {
+		wg.Add(1)
+		workers <- struct{}{}
+		go func(from, to string) {
+			defer wg.Done()
+			defer func() { <-workers }()
+			fromPath, toPath, err := g.objectPaths(from, to)
+			if err == nil {
+				_, err = gcp.moveObject(g, fromPath, g.config.BucketName, toPath, &CopyOptions{SourceGenerationMatch: generations[from], Overwrite: opts.Overwrite})
+			}
+			if err != nil {
+				failed.add(from, err)
+			}
+			mu.Lock()
+			results[from] = err
+			mu.Unlock()
+		}(from, to)
+	}
+	wg.Wait()
+	return results, failed.err()
+}
+
+// planMoves runs the MoveMany pre-flight checks. It returns the per-move outcome, the generation of
+// every source and, if any check failed, an error summarising the conflicts.
+func (gcp *GCPController) planMoves(g *GCPFS, moves map[string]string, overwrite bool) (map[string]error, map[string]int64, error) {
+	results := make(map[string]error, len(moves))
+	generations := make(map[string]int64, len(moves))
+	destinations := make(map[string]string, len(moves))
+	sources := make(map[string]bool, len(moves))
+	fullPaths := make(map[string]string, 2*len(moves))
+	for from, to := range moves {
+		fromPath, toPath, err := g.objectPaths(from, to)
+		results[from] = err
+		if err == nil {
+			fullPaths[from], fullPaths[to] = fromPath, toPath
+			sources[fromPath] = true
+		}
+	}
+	for from, to := range moves {
+		if results[from] != nil {
+			continue
+		}
+		switch dst := fullPaths[to]; {
+		case dst == fullPaths[from]:
+			results[from] = fmt.Errorf("source and destination are both %s", to)
+		case sources[dst]:
+			results[from] = fmt.Errorf("destination %s is also moved in this batch", to)
+		case destinations[dst] != "":
+			results[from] = fmt.Errorf("destination %s is also the target of %s: %w", to, destinations[dst], models.ErrAlreadyExists)
+			if results[destinations[dst]] == nil {
+				results[destinations[dst]] = fmt.Errorf("destination %s is also the target of %s: %w", to, from, models.ErrAlreadyExists)
+			}
+		default:
+			destinations[dst] = from
+		}
+	}
+	// Only moves that passed the checks above go to the bucket.
+	var checks []string
+	for from := range moves {
+		if results[from] == nil {
+			checks = append(checks, from)
+		}
+	}
+
+	var (
+		mu      sync.Mutex
+		wg      sync.WaitGroup
+		workers = make(chan struct{}, bulkConcurrency)
+	)
+	for _, from := range checks {
+		wg.Add(1)
+		workers <- struct{}{}
+		go func(from, to string) {
+			defer wg.Done()
+			defer func() { <-workers }()
+			generation, err := gcp.checkMove(g, from, to, overwrite)
+			mu.Lock()
+			defer mu.Unlock()
+			results[from] = err
+			generations[from] = generation
+		}(from, moves[from])
+	}
+	wg.Wait()
+
+	var conflicts batchErrors
+	for from, err := range results {
+		if err != nil {
+			conflicts.add(from, err)
+		}
+	}
+	if err := conflicts.err(); err != nil {
+		return results, generations, fmt.Errorf("pre-flight checks failed, nothing was moved: %w", err)
+	}
+	return results, generations, nil
+}
+
+// checkMove checks a single move against the bucket, returning the source's generation.
+func (gcp *GCPController) checkMove(g *GCPFS, from, to string, overwrite bool) (int64, error) {
+	fromPath, toPath, err := g.objectPaths(from, to)
+	if err != nil {
+		return 0, err
+	}
+	ctx, cancel := g.opContext(g.config.MetadataTimeout)
+	defer cancel()
+	attrs := func(fullPath string) (a *storage.ObjectAttrs, err error) {
+		o := g.bucket(g.config.BucketName).Object(fullPath)
+		err = g.retry(ctx, func() error {
+			a, err = o.Attrs(ctx)
+			return err
+		})
+		return a, err
+	}
+
+	src, err := attrs(fromPath)
+	if err == storage.ErrObjectNotExist {
+		return 0, fmt.Errorf("source %s: %w", from, models.ErrNotFound)
+	}
+	if err != nil {
+		return 0, fmt.Errorf("source %s cannot be checked: %v", from, err)
+	}
+	if overwrite {
+		return src.Generation, nil
+	}
+	_, err = attrs(toPath)
+	if err == nil {
+		return 0, fmt.Errorf("destination %s: %w", to, models.ErrAlreadyExists)
+	}
+	if err != storage.ErrObjectNotExist {
+		return 0, fmt.Errorf("destination %s cannot be checked: %v", to, err)
+	}
+	return src.Generation, nil
+}
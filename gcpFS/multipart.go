@@ -0,0 +1,52 @@
+package gcpFS
+
+import (
+	"fmt"
+	"io"
+	"mime/multipart"
+	"path"
+	"strings"
+
+	"github.com/ninjamarcus/ninjaStorage/models"
+)
+
+// UploadMultipart streams every file part of a multipart/form-data body into its own object under
+// prefix, named after the part's filename, without buffering the parts in memory. Only the base name
+// of the filename is used, so a client cannot escape prefix with "../". The part's Content-Type header
+// becomes the object's content type (detected as usual when absent). Parts that are not files are
+// skipped. Two file parts with the same base name would be stored as the same object, so the second
+// is rejected before anything of it is uploaded.
+//
+// The result is keyed "<form field>/<filename>". On failure the parts uploaded so far are returned
+// alongside the error.
+func (gcp *GCPController) UploadMultipart(g *GCPFS, r *multipart.Reader, prefix string) (map[string]*models.FileMetaData, error) {
+	defer g.startOp()()
+	results := make(map[string]*models.FileMetaData)
+	uploaded := make(map[string]string)
+	for {
+		part, err := r.NextPart()
+		if err != nil {
+			if err == io.EOF {
+				return results, nil
+			}
+			return results, fmt.Errorf("cannot read multipart body: %v", err)
+		}
+		filename := path.Base(strings.ReplaceAll(part.FileName(), "\\", "/"))
+		if part.FileName() == "" || filename == "." || filename == "/" || filename == ".." {
+			part.Close()
+			continue
+		}
+		if field, ok := uploaded[filename]; ok {
+			part.Close()
+			return results, fmt.Errorf("part %s/%s would overwrite the object already uploaded from %s/%s", part.FormName(), part.FileName(), field, filename)
+		}
+		meta := &models.FileMetaData{ContentType: part.Header.Get("Content-Type")}
+		mdata, err := gcp.writeStream(g, part, path.Join(prefix, filename), meta, 0, nil)
+		part.Close()
+		if err != nil {
+			return results, fmt.Errorf("cannot upload part %s: %w", filename, err)
+		}
+		uploaded[filename] = part.FormName()
+		results[part.FormName()+"/"+filename] = mdata
+	}
+}
@@ -0,0 +1,138 @@
+package gcpFS
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/storage"
+)
+
+// OpOption sets a per-call option on the GCPFS returned by With.
+type OpOption func(*GCPFS)
+
+// WithRequestID labels the operations made through the derived GCPFS with id, e.g. the request or
+// trace ID of the caller, so their log lines can be correlated. An empty id is a no-op.
+func WithRequestID(id string) OpOption {
+	return func(g *GCPFS) {
+		if id != "" {
+			g.requestID = id
+		}
+	}
+}
+
+// WithContext makes the operations of the derived GCPFS run under ctx instead of the context the
+// GCPFS was created with, so cancelling ctx, or its deadline, stops them, and request-scoped values
+// reach the SDK. The configured per-operation timeouts still apply within it. A nil ctx is a no-op.
+func WithContext(ctx context.Context) OpOption {
+	return func(g *GCPFS) {
+		if ctx != nil {
+			g.ctx = ctx
+		}
+	}
+}
+
+// WithEncryptionKey makes every object read, written, copied or rewritten through the derived GCPFS
+// use key, a customer-supplied 32-byte AES-256 key, e.g. to ReadRange or Tail an object written with
+// WriteOptions.EncryptionKey. Operations fail when the key is not 32 bytes. A nil key is a no-op.
+func WithEncryptionKey(key []byte) OpOption {
+	return func(g *GCPFS) {
+		if key != nil {
+			g.encryptionKey = key
+		}
+	}
+}
+
+// keyed returns o set up with the customer-supplied encryption key, when there is one.
+func (g *GCPFS) keyed(o *storage.ObjectHandle) *storage.ObjectHandle {
+	if g.encryptionKey == nil {
+		return o
+	}
+	return o.Key(g.encryptionKey)
+}
+
+// validateEncryptionKey checks a customer-supplied encryption key is an AES-256 key.
+func validateEncryptionKey(key []byte) error {
+	if len(key) != 32 {
+		return fmt.Errorf("encryption key must be 32 bytes (AES-256), got %d", len(key))
+	}
+	return nil
+}
+
+// With returns a copy of g with opts applied, for passing to the GCPController methods in place of g:
+//
+//	gcp.Read(g.With(WithRequestID(reqID)), "reports/today.csv")
+//
+// The copy shares g's client, stats and caches, so it is cheap to make per request.
+func (g *GCPFS) With(opts ...OpOption) *GCPFS {
+	c := *g
+	for _, opt := range opts {
+		opt(&c)
+	}
+	return &c
+}
+
+// logf writes a key=value line for event to the configured Logger, if any, tagged with the
+// request ID when one is set. kv alternates keys and values.
+func (g *GCPFS) logf(event string, kv ...interface{}) {
+	if g.config == nil || g.config.Logger == nil {
+		return
+	}
+	var b strings.Builder
+	b.WriteString("event=" + event)
+	if g.requestID != "" {
+		b.WriteString(" request_id=" + logValue(g.requestID))
+	}
+	for i := 0; i+1 < len(kv); i += 2 {
+		fmt.Fprintf(&b, " %v=%s", kv[i], logValue(fmt.Sprint(kv[i+1])))
+	}
+	g.config.Logger.Print(b.String())
+}
+
+// traceOp logs an op_start line for op on object, followed by kv, when LogOperations is on, and
+// returns the func to call once op has finished: it logs op_done with the bytes transferred, the
+// duration, the error if any and the extra kv, and reports the same to Metrics. With neither
+// configured both are no-ops.
+func (g *GCPFS) traceOp(op, object string, kv ...interface{}) func(bytes int64, err error, kv ...interface{}) {
+	if g.config == nil {
+		return func(int64, error, ...interface{}) {}
+	}
+	logOps := g.config.Logger != nil && g.config.LogOperations
+	metrics := g.config.Metrics
+	if !logOps && metrics == nil {
+		return func(int64, error, ...interface{}) {}
+	}
+	if logOps {
+		g.logf("op_start", append([]interface{}{"op", op, "object", object}, kv...)...)
+	}
+	start := time.Now()
+	return func(bytes int64, err error, doneKV ...interface{}) {
+		elapsed := time.Since(start)
+		if metrics != nil {
+			metrics.ObserveOp(op, g.config.BucketName, elapsed, err)
+			if bytes > 0 {
+				metrics.AddBytes(op, g.config.BucketName, bytes)
+			}
+		}
+		if !logOps {
+			return
+		}
+		fields := append([]interface{}{"op", op, "object", object}, kv...)
+		fields = append(fields, "bytes", bytes, "duration", elapsed)
+		fields = append(fields, doneKV...)
+		if err != nil {
+			fields = append(fields, "err", err)
+		}
+		g.logf("op_done", fields...)
+	}
+}
+
+// logValue quotes v when it would otherwise break the key=value format.
+func logValue(v string) string {
+	if v == "" || strings.ContainsAny(v, " \t\n\"=") {
+		return strconv.Quote(v)
+	}
+	return v
+}
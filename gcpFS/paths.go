@@ -0,0 +1,130 @@
+package gcpFS
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"strings"
+
+	"cloud.google.com/go/storage"
+	"github.com/ninjamarcus/ninjaStorage/models"
+)
+
+// Path rules shared by every operation:
+//
+//   - Object paths are cleaned and joined onto ParentFolder, so a trailing slash is dropped:
+//     "a/b/" and "a/b" name the same object, "a/b". Folder placeholder objects ("a/b/") are
+//     therefore only managed internally and cannot be read or written directly.
+//   - List prefixes keep a trailing slash. "a/b/" lists the contents of folder a/b, while "a/b" is
+//     a plain prefix match that also returns "a/bc" and "a/b.txt". An empty prefix lists everything
+//     under ParentFolder (and nothing from a sibling folder that merely shares its name as prefix).
+//   - Paths are always relative to ParentFolder. Empty paths, absolute paths and paths with a ".."
+//     segment, which could reach outside ParentFolder, are rejected with ErrInvalidPath; "." segments
+//     and duplicate slashes are dropped. Prefixes follow the same rules but may be empty.
+//   - With LowercaseKeys the path below ParentFolder is lowercased for every operation. Read and
+//     Stat fall back to the path exactly as given when no lowercased object exists, so objects
+//     written with mixed case before the option was enabled stay readable; other operations only
+//     ever see the lowercased key.
+
+// sanitizePath checks a caller supplied object path against the rules above and returns it cleaned.
+func sanitizePath(filePath string) (string, error) {
+	if filePath == "" {
+		return "", fmt.Errorf("file path cannot be empty: %w", models.ErrInvalidPath)
+	}
+	if path.IsAbs(filePath) {
+		return "", fmt.Errorf("file path %q must be relative: %w", filePath, models.ErrInvalidPath)
+	}
+	for _, segment := range strings.Split(filePath, "/") {
+		if segment == ".." {
+			return "", fmt.Errorf("file path %q cannot contain ..: %w", filePath, models.ErrInvalidPath)
+		}
+	}
+	clean := path.Clean(filePath)
+	if clean == "." {
+		return "", fmt.Errorf("file path %q names no object: %w", filePath, models.ErrInvalidPath)
+	}
+	return clean, nil
+}
+
+// objectPath maps a caller supplied object path to the full object name in the bucket.
+func (g *GCPFS) objectPath(filePath string) (string, error) {
+	clean, err := sanitizePath(filePath)
+	if err != nil {
+		return "", err
+	}
+	if g.config.LowercaseKeys {
+		clean = strings.ToLower(clean)
+	}
+	return path.Join(g.config.ParentFolder, clean), nil
+}
+
+// objectPaths is objectPath for the source and destination of a copy or move.
+func (g *GCPFS) objectPaths(from, to string) (string, string, error) {
+	fromPath, err := g.objectPath(from)
+	if err != nil {
+		return "", "", err
+	}
+	toPath, err := g.objectPath(to)
+	if err != nil {
+		return "", "", err
+	}
+	return fromPath, toPath, nil
+}
+
+// readPaths lists the full object names a read of filePath tries, in order.
+func (g *GCPFS) readPaths(filePath string) ([]string, error) {
+	fullPath, err := g.objectPath(filePath)
+	if err != nil {
+		return nil, err
+	}
+	if exact := path.Join(g.config.ParentFolder, filePath); exact != fullPath {
+		return []string{fullPath, exact}, nil
+	}
+	return []string{fullPath}, nil
+}
+
+// listPrefix maps a caller supplied List prefix to the bucket prefix to query.
+func (g *GCPFS) listPrefix(prefix string) (string, error) {
+	if prefix == "" {
+		return path.Join(g.config.ParentFolder) + "/", nil
+	}
+	clean, err := sanitizePath(prefix)
+	if err != nil {
+		return "", fmt.Errorf("prefix %q: %w", prefix, err)
+	}
+	full := path.Join(g.config.ParentFolder, clean)
+	if strings.HasSuffix(prefix, "/") {
+		full += "/"
+	}
+	return full, nil
+}
+
+// checkCaseCollision returns ErrCaseCollision when LowercaseKeys is on and an object whose name
+// differs from filePath's normalized key only by case already exists. Variants are looked for in the
+// lowercased folder and in the folder as the caller spelled it, which covers keys written before the
+// option was enabled.
+func (g *GCPFS) checkCaseCollision(ctx context.Context, filePath string) error {
+	if !g.config.LowercaseKeys {
+		return nil
+	}
+	target, err := g.objectPath(filePath)
+	if err != nil {
+		return err
+	}
+	dirs := map[string]bool{path.Dir(target) + "/": true, path.Dir(path.Join(g.config.ParentFolder, filePath)) + "/": true}
+	for dir := range dirs {
+		var collision string
+		err := g.listQuery(ctx, &storage.Query{Prefix: dir, Delimiter: "/"}, func(attrs *storage.ObjectAttrs) {
+			if attrs.Name != target && strings.EqualFold(attrs.Name, target) {
+				collision = attrs.Name
+			}
+		})
+		if err != nil {
+			return fmt.Errorf("Bucket(%s).Objects: %v", g.config.BucketName, err)
+		}
+		if collision != "" {
+			return fmt.Errorf("object(%s) differs from %s only by case: %w", collision, target, models.ErrCaseCollision)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,133 @@
+package gcpFS
+
+import (
+	"io"
+
+	"github.com/ninjamarcus/ninjaStorage/models"
+)
+
+// progressInterval is how many bytes pass between progress reports.
+const progressInterval = 1 << 20
+
+// ProgressFunc is told how many bytes of a transfer have been moved so far, out of totalBytes, or -1
+// when the total is not known up front.
+type ProgressFunc func(bytesTransferred, totalBytes int64)
+
+// progressReader counts the bytes read through it, reporting every progressInterval bytes. The final
+// count is reported by done, once the transfer has actually completed.
+type progressReader struct {
+	r        io.Reader
+	fn       ProgressFunc
+	total    int64
+	n        int64
+	reported int64
+}
+
+// withProgress wraps r to report to fn; a nil fn only counts.
+func withProgress(r io.Reader, fn ProgressFunc, total int64) *progressReader {
+	return &progressReader{r: r, fn: fn, total: total}
+}
+
+func (p *progressReader) Read(b []byte) (int, error) {
+	n, err := p.r.Read(b)
+	p.n += int64(n)
+	if p.fn != nil && p.n-p.reported >= progressInterval {
+		p.reported = p.n
+		p.fn(p.n, p.total)
+	}
+	return n, err
+}
+
+// done reports the final count.
+func (p *progressReader) done() {
+	if p.fn != nil {
+		p.fn(p.n, p.total)
+	}
+}
+
+// WriteOptions tunes WriteWithOptions and WriteStreamWithOptions.
+type WriteOptions struct {
+	// Progress, when set, is called as the upload proceeds (about every MiB) and once more with the
+	// final count after it has been committed. The total is len(data) for WriteWithOptions and -1
+	// for WriteStreamWithOptions; with Compress both count the compressed bytes.
+	Progress ProgressFunc
+	// PredefinedACL, when set, replaces metaData.PredefinedACL, e.g. "publicRead".
+	PredefinedACL string
+	// Compress gzips the content before uploading and stores it with Content-Encoding gzip. GCS
+	// decompresses it again on download, so Read returns the original bytes while storage and
+	// transfer shrink; the returned FileMetaData.Size is the compressed size. Content that already
+	// has a ContentEncoding, or whose content type is a compressed format (images, audio, video,
+	// archives, web fonts), is uploaded as is. It cannot be combined with Md5Hash or Crc32c.
+	Compress bool
+	// EncryptionKey, when set, is a 32-byte AES-256 key GCS encrypts the object with instead of the
+	// bucket's default encryption or KMSKeyName. GCS does not keep the key: reading the object back
+	// needs the same key in ReadOptions.EncryptionKey, and losing it loses the content.
+	EncryptionKey []byte
+}
+
+// withACL returns metaData with PredefinedACL set to acl, leaving the caller's value untouched.
+func (o *WriteOptions) withACL(metaData *models.FileMetaData) *models.FileMetaData {
+	if o.PredefinedACL == "" {
+		return metaData
+	}
+	withACL := &models.FileMetaData{}
+	if metaData != nil {
+		*withACL = *metaData
+	}
+	withACL.PredefinedACL = o.PredefinedACL
+	return withACL
+}
+
+// WriteWithOptions is Write with per-call options; opts may be nil.
+func (gcp *GCPController) WriteWithOptions(g *GCPFS, data []byte, filePath string, metaData *models.FileMetaData, opts *WriteOptions) (*models.FileMetaData, error) {
+	defer g.startOp()()
+	if opts == nil {
+		opts = &WriteOptions{}
+	}
+	if opts.EncryptionKey != nil {
+		if err := validateEncryptionKey(opts.EncryptionKey); err != nil {
+			return nil, err
+		}
+		g = g.With(WithEncryptionKey(opts.EncryptionKey))
+	}
+	metaData = opts.withACL(metaData)
+	if opts.Compress {
+		gzipped, err := compressedMetaData(filePath, data, metaData)
+		if err != nil {
+			return nil, err
+		}
+		if gzipped != nil {
+			data, metaData = gzipBytes(data), gzipped
+		}
+	}
+	return gcp.write(g, data, filePath, metaData, nil, opts.Progress)
+}
+
+// WriteStreamWithOptions is WriteStream with per-call options; opts may be nil.
+func (gcp *GCPController) WriteStreamWithOptions(g *GCPFS, r io.Reader, filePath string, metaData *models.FileMetaData, opts *WriteOptions) (*models.FileMetaData, error) {
+	defer g.startOp()()
+	if opts == nil {
+		opts = &WriteOptions{}
+	}
+	if opts.EncryptionKey != nil {
+		if err := validateEncryptionKey(opts.EncryptionKey); err != nil {
+			return nil, err
+		}
+		g = g.With(WithEncryptionKey(opts.EncryptionKey))
+	}
+	metaData = opts.withACL(metaData)
+	if opts.Compress {
+		var head []byte
+		head, r = peekHead(r)
+		gzipped, err := compressedMetaData(filePath, head, metaData)
+		if err != nil {
+			return nil, err
+		}
+		if gzipped != nil {
+			zr := gzipReader(r)
+			defer zr.Close()
+			r, metaData = zr, gzipped
+		}
+	}
+	return gcp.writeStream(g, r, filePath, metaData, 0, opts.Progress)
+}
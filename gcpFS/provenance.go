@@ -0,0 +1,50 @@
+package gcpFS
+
+import (
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/ninjamarcus/ninjaStorage/models"
+)
+
+// withProvenance returns meta with the configured provenance fields added. Keys the caller already
+// set win. meta itself is not modified.
+func (g *GCPFS) withProvenance(meta map[string]string) map[string]string {
+	if len(g.config.Provenance) == 0 {
+		return meta
+	}
+	out := make(map[string]string, len(meta)+len(g.config.Provenance))
+	for _, field := range g.config.Provenance {
+		switch field {
+		case models.ProvenanceHost:
+			if host, err := os.Hostname(); err == nil {
+				out[string(field)] = host
+			}
+		case models.ProvenancePID:
+			out[string(field)] = strconv.Itoa(os.Getpid())
+		case models.ProvenanceTime:
+			out[string(field)] = time.Now().UTC().Format(time.RFC3339)
+		}
+	}
+	for k, v := range meta {
+		out[k] = v
+	}
+	return out
+}
+
+// withDefaultMetadata returns meta with the configured DefaultMetadata added under it. Keys the
+// caller already set win. meta itself is not modified.
+func (g *GCPFS) withDefaultMetadata(meta map[string]string) map[string]string {
+	if len(g.config.DefaultMetadata) == 0 {
+		return meta
+	}
+	out := make(map[string]string, len(meta)+len(g.config.DefaultMetadata))
+	for k, v := range g.config.DefaultMetadata {
+		out[k] = v
+	}
+	for k, v := range meta {
+		out[k] = v
+	}
+	return out
+}
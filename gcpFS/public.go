@@ -0,0 +1,84 @@
+package gcpFS
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+
+	"cloud.google.com/go/storage"
+
+	"github.com/ninjamarcus/ninjaStorage/models"
+)
+
+// publicHost serves publicly readable objects.
+const publicHost = "storage.googleapis.com"
+
+// WritePublic uploads data like Write with the publicRead predefined ACL and returns the object's
+// public https://storage.googleapis.com/<bucket>/<object> URL. Buckets with uniform bucket-level
+// access do not accept object ACLs; for those, grant allUsers roles/storage.objectViewer on the
+// bucket through IAM instead and an error saying so is returned.
+func (gcp *GCPController) WritePublic(g *GCPFS, data []byte, filePath string, metaData *models.FileMetaData) (string, *models.FileMetaData, error) {
+	defer g.startOp()()
+	public := &models.FileMetaData{}
+	if metaData != nil {
+		*public = *metaData
+	}
+	public.PredefinedACL = "publicRead"
+	mdata, err := gcp.write(g, data, filePath, public, nil, nil)
+	if isUniformAccessError(err) {
+		return "", nil, fmt.Errorf("bucket:%s uses uniform bucket-level access so object ACLs cannot be set, make objects public through bucket IAM (allUsers roles/storage.objectViewer) instead: %v", g.config.BucketName, err)
+	}
+	if err != nil {
+		return "", nil, err
+	}
+	return g.publicURL(mdata.Name), mdata, nil
+}
+
+// MakePublic grants allUsers read access to the existing object at filePath through its ACL, so it
+// can be fetched from its public URL. Like WritePublic it fails on buckets with uniform
+// bucket-level access.
+func (gcp *GCPController) MakePublic(g *GCPFS, filePath string) error {
+	defer g.startOp()()
+	fullPath, err := g.objectPath(filePath)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := g.opContext(g.config.MetadataTimeout)
+	defer cancel()
+	err = g.rawBucket(g.config.BucketName).Object(fullPath).ACL().Set(ctx, storage.AllUsers, storage.RoleReader)
+	if isUniformAccessError(err) {
+		return fmt.Errorf("bucket:%s uses uniform bucket-level access so object ACLs cannot be set, make objects public through bucket IAM (allUsers roles/storage.objectViewer) instead: %v", g.config.BucketName, err)
+	}
+	if err != nil {
+		return fmt.Errorf("could not make object:%s public: %w", fullPath, wrapGCSError(ctx, err))
+	}
+	return nil
+}
+
+// publicURL returns the unauthenticated download URL of the full object name.
+func (g *GCPFS) publicURL(objectName string) string {
+	u := url.URL{Scheme: "https", Host: publicHost, Path: "/" + g.config.BucketName + "/" + objectName}
+	return u.String()
+}
+
+// PublicURL returns the user-facing URL of the object at filePath: CDNBaseURL followed by the path
+// relative to ParentFolder when a CDN is configured, otherwise the object's public
+// storage.googleapis.com URL. The object is not checked for existence or public access. A filePath
+// that is not a valid object path, e.g. one escaping ParentFolder, gives "".
+func (gcp *GCPController) PublicURL(g *GCPFS, filePath string) string {
+	fullPath, err := g.objectPath(filePath)
+	if err != nil {
+		return ""
+	}
+	if g.config.CDNBaseURL == "" {
+		return g.publicURL(fullPath)
+	}
+	u, err := url.Parse(g.config.CDNBaseURL)
+	if err != nil {
+		// Validate rejects unparsable base URLs, this only happens if the config changed since.
+		return g.publicURL(fullPath)
+	}
+	u.Path = strings.TrimSuffix(u.Path, "/") + "/" + g.relativeName(fullPath)
+	u.RawPath = ""
+	return u.String()
+}
@@ -0,0 +1,137 @@
+package gcpFS
+
+import (
+	"context"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"sync"
+
+	"cloud.google.com/go/storage"
+	"github.com/ninjamarcus/ninjaStorage/models"
+)
+
+// ReadRange returns length bytes of the object at filePath starting at offset, a length of -1
+// meaning up to the end, e.g. to serve an HTTP Range request. A range running past the end is cut
+// short, but one starting past it is an error. The metadata is that of the whole object, so Size is
+// the full length rather than the number of bytes returned.
+func (gcp *GCPController) ReadRange(g *GCPFS, filePath string, offset, length int64) ([]byte, *models.FileMetaData, error) {
+	defer g.startOp()()
+	if offset < 0 {
+		return nil, nil, fmt.Errorf("offset cannot be negative: %d", offset)
+	}
+	if length < -1 {
+		return nil, nil, fmt.Errorf("length must be -1 or more: %d", length)
+	}
+	fullPath, err := g.objectPath(filePath)
+	if err != nil {
+		return nil, nil, err
+	}
+	ctx, cancel := g.opContext(g.config.ReadTimeout)
+	defer cancel()
+	o := g.rawBucket(g.config.BucketName).Object(fullPath)
+	attrs, err := g.keyed(o).Attrs(ctx)
+	if err == storage.ErrObjectNotExist {
+		return nil, nil, fmt.Errorf("object(%s) cannot be found: %w", fullPath, models.ErrNotFound)
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("object.Attrs: %w", wrapGCSError(ctx, err))
+	}
+	if offset > attrs.Size {
+		return nil, nil, fmt.Errorf("object(%s) range starts at %d past its end at %d", fullPath, offset, attrs.Size)
+	}
+	if length == -1 || offset+length > attrs.Size {
+		length = attrs.Size - offset
+	}
+	// Pin the generation so the bytes returned belong to the object the metadata describes.
+	data, err := readRange(ctx, g.keyed(o.Generation(attrs.Generation)), offset, length)
+	if err != nil {
+		return nil, nil, readError(ctx, fullPath, err)
+	}
+	return data, g.parseMetaData(attrs), nil
+}
+
+// ReadParallel downloads the object at filePath like Read, but as parts of partSize bytes fetched
+// with up to concurrency range requests at once and reassembled in order, to use more bandwidth on
+// large objects. Objects no larger than partSize, and gzip-encoded ones (which GCS cannot serve in
+// ranges), are read in one request. All parts are read from the generation the metadata describes
+// and the result is checked against its CRC32C, a mismatch wrapping ErrChecksumMismatch.
+func (gcp *GCPController) ReadParallel(g *GCPFS, filePath string, partSize int64, concurrency int) ([]byte, *models.FileMetaData, error) {
+	defer g.startOp()()
+	if partSize <= 0 {
+		return nil, nil, fmt.Errorf("partSize must be positive: %d", partSize)
+	}
+	if concurrency <= 0 {
+		return nil, nil, fmt.Errorf("concurrency must be positive: %d", concurrency)
+	}
+	fullPath, err := g.objectPath(filePath)
+	if err != nil {
+		return nil, nil, err
+	}
+	ctx, cancel := g.opContext(g.config.ReadTimeout)
+	defer cancel()
+	o := g.rawBucket(g.config.BucketName).Object(fullPath)
+	attrs, err := g.keyed(o).Attrs(ctx)
+	if err == storage.ErrObjectNotExist {
+		return nil, nil, fmt.Errorf("object(%s) cannot be found: %w", fullPath, models.ErrNotFound)
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("object.Attrs: %w", wrapGCSError(ctx, err))
+	}
+	if attrs.Size <= partSize || attrs.ContentEncoding == "gzip" {
+		return gcp.read(g, filePath, nil)
+	}
+
+	o = g.keyed(o.Generation(attrs.Generation))
+	data := make([]byte, attrs.Size)
+	ctx, stop := context.WithCancel(ctx)
+	defer stop()
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		firstErr error
+		workers  = make(chan struct{}, concurrency)
+	)
+	for offset := int64(0); offset < attrs.Size && ctx.Err() == nil; offset += partSize {
+		end := offset + partSize
+		if end > attrs.Size {
+			end = attrs.Size
+		}
+		part := data[offset:end]
+		wg.Add(1)
+		workers <- struct{}{}
+		go func(offset int64, part []byte) {
+			defer wg.Done()
+			defer func() { <-workers }()
+			err := g.retry(ctx, func() error {
+				rc, err := o.NewRangeReader(ctx, offset, int64(len(part)))
+				if err != nil {
+					return err
+				}
+				defer rc.Close()
+				_, err = io.ReadFull(rc, part)
+				return err
+			})
+			if err != nil {
+				mu.Lock()
+				defer mu.Unlock()
+				if firstErr == nil {
+					firstErr = fmt.Errorf("part at %d: %w", offset, readError(ctx, fullPath, err))
+					stop()
+				}
+			}
+		}(offset, part)
+	}
+	wg.Wait()
+	if firstErr != nil {
+		return nil, nil, firstErr
+	}
+	// No more parts are requested once the context ends, so some may be missing without any failing.
+	if err := ctx.Err(); err != nil {
+		return nil, nil, fmt.Errorf("object(%s) cannot be read: %w", fullPath, &contextError{err: err, ctxErr: err})
+	}
+	if sum := crc32.Checksum(data, castagnoli); sum != attrs.CRC32C {
+		return nil, nil, fmt.Errorf("object(%s) reassembled with CRC32C %08x, want %08x: %w", fullPath, sum, attrs.CRC32C, models.ErrChecksumMismatch)
+	}
+	return data, g.parseMetaData(attrs), nil
+}
@@ -0,0 +1,70 @@
+package gcpFS
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// rateLimiter is a token bucket holding a single token, refilled every interval, so operations
+// are spread evenly rather than let through in bursts. It is shared by pointer across copies of a
+// GCPFS; a nil rateLimiter never waits.
+type rateLimiter struct {
+	mu       sync.Mutex
+	interval time.Duration
+	// next is when the next operation may start.
+	next time.Time
+}
+
+// newRateLimiter returns a rateLimiter allowing opsPerSecond operations a second, or nil for no
+// limit when opsPerSecond is not positive.
+func newRateLimiter(opsPerSecond float64) *rateLimiter {
+	if opsPerSecond <= 0 {
+		return nil
+	}
+	return &rateLimiter{interval: time.Duration(float64(time.Second) / opsPerSecond)}
+}
+
+// wait blocks until the caller may start its operation, or ctx ends. A caller that gives up hands
+// its turn back when no one has queued behind it.
+func (l *rateLimiter) wait(ctx context.Context) error {
+	if l == nil {
+		return nil
+	}
+	l.mu.Lock()
+	now := time.Now()
+	if l.next.Before(now) {
+		l.next = now
+	}
+	at := l.next
+	l.next = at.Add(l.interval)
+	l.mu.Unlock()
+
+	delay := at.Sub(now)
+	if delay <= 0 {
+		return nil
+	}
+	t := time.NewTimer(delay)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		l.mu.Lock()
+		if l.next.Equal(at.Add(l.interval)) {
+			l.next = at
+		}
+		l.mu.Unlock()
+		return ctx.Err()
+	}
+}
+
+// throttle waits for MaxOpsPerSecond to allow another mutating operation, giving up when the
+// GCPFS's context ends. The wait does not count against the operation's timeout.
+func (g *GCPFS) throttle() error {
+	if err := g.limiter.wait(g.ctx); err != nil {
+		return fmt.Errorf("waiting for MaxOpsPerSecond: %w", err)
+	}
+	return nil
+}
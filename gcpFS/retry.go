@@ -0,0 +1,137 @@
+package gcpFS
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math/rand"
+	"net"
+	"syscall"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"github.com/googleapis/gax-go/v2"
+	"google.golang.org/api/googleapi"
+)
+
+const (
+	// defaultRetryBaseDelay is the first backoff when RetryBaseDelay is unset.
+	defaultRetryBaseDelay = 100 * time.Millisecond
+	// maxRetryDelay caps the exponential backoff between attempts.
+	maxRetryDelay = 30 * time.Second
+)
+
+// DefaultShouldRetry is the classifier used when GCPFSConfig.ShouldRetry is nil. It retries
+// rate limiting (429), server errors (500, 502, 503, 504), connection resets/refusals, unexpected
+// EOFs and network timeouts. Anything else, including context cancellation and every other 4xx,
+// fails straight away. attempt is ignored.
+func DefaultShouldRetry(err error, attempt int) bool {
+	if err == nil || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	var gErr *googleapi.Error
+	if errors.As(err, &gErr) {
+		switch gErr.Code {
+		case 429, 500, 502, 503, 504:
+			return true
+		}
+		return false
+	}
+	if errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.ECONNREFUSED) {
+		return true
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+// retrying reports whether the retry loop is enabled, in which case it replaces the SDK's own retries.
+func (g *GCPFS) retrying() bool {
+	return g.config.MaxRetries > 0
+}
+
+// rawBucket returns a handle on the named bucket, billed to UserProject when one is configured,
+// with the SDK's default retries.
+func (g *GCPFS) rawBucket(name string) *storage.BucketHandle {
+	b := g.client.Bucket(name)
+	if g.config.UserProject != "" {
+		b = b.UserProject(g.config.UserProject)
+	}
+	return b
+}
+
+// bucket returns a handle on the named bucket like rawBucket. When the retry loop is enabled the SDK's built-in
+// retries are switched off on it so the configured classifier is the only one deciding.
+func (g *GCPFS) bucket(name string) *storage.BucketHandle {
+	b := g.rawBucket(name)
+	if g.retrying() {
+		b = b.Retryer(storage.WithPolicy(storage.RetryNever))
+	}
+	return b
+}
+
+// uploadRetries hands an upload on o to the SDK's own retries, configured like the retry loop, when
+// that is enabled. Uploads cannot simply be re-run from the start once part of the content has been
+// consumed, so the writer buffers each chunk and the SDK resends or resumes from the last one
+// committed. Every upload made here either carries a precondition or replaces the whole object with
+// content the caller already chose, so retrying it is safe. The returned ChunkSize is what the
+// writer has to use: UploadChunkSize when set, else zero, a single unbuffered request, without
+// retries and the SDK's default chunk size with them.
+func (g *GCPFS) uploadRetries(o *storage.ObjectHandle) (*storage.ObjectHandle, int) {
+	chunkSize := g.config.UploadChunkSize
+	if !g.retrying() {
+		return o, chunkSize
+	}
+	if chunkSize == 0 {
+		chunkSize = googleapi.DefaultUploadChunkSize
+	}
+	shouldRetry := g.config.ShouldRetry
+	if shouldRetry == nil {
+		shouldRetry = DefaultShouldRetry
+	}
+	delay := g.config.RetryBaseDelay
+	if delay <= 0 {
+		delay = defaultRetryBaseDelay
+	}
+	attempt := 0
+	return o.Retryer(
+		storage.WithPolicy(storage.RetryAlways),
+		storage.WithBackoff(gax.Backoff{Initial: delay, Max: maxRetryDelay, Multiplier: 2}),
+		storage.WithErrorFunc(func(err error) bool {
+			attempt++
+			if attempt > g.config.MaxRetries || !shouldRetry(err, attempt) {
+				return false
+			}
+			g.logf("retry", "attempt", attempt, "err", err)
+			return true
+		}),
+	), chunkSize
+}
+
+// retry runs op, re-running it with exponential backoff while the classifier accepts the error, at
+// most MaxRetries more times and never past ctx. With MaxRetries unset op runs exactly once.
+func (g *GCPFS) retry(ctx context.Context, op func() error) error {
+	shouldRetry := g.config.ShouldRetry
+	if shouldRetry == nil {
+		shouldRetry = DefaultShouldRetry
+	}
+	delay := g.config.RetryBaseDelay
+	if delay <= 0 {
+		delay = defaultRetryBaseDelay
+	}
+	err := op()
+	for attempt := 1; err != nil && attempt <= g.config.MaxRetries && shouldRetry(err, attempt); attempt++ {
+		// Half fixed, half jitter, so concurrent callers spread out.
+		wait := delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(wait):
+		}
+		if delay *= 2; delay > maxRetryDelay {
+			delay = maxRetryDelay
+		}
+		g.logf("retry", "attempt", attempt, "wait", wait, "err", err)
+		err = op()
+	}
+	return err
+}
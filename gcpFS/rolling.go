@@ -0,0 +1,168 @@
+package gcpFS
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"path"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/storage"
+)
+
+// rollingWriter spreads an unbounded stream over a sequence of objects, see NewRollingWriter.
+type rollingWriter struct {
+	g        *GCPFS
+	prefix   string
+	maxBytes int64
+	maxAge   time.Duration
+	ctx      context.Context
+	cancel   context.CancelFunc
+	done     func()
+
+	mu      sync.Mutex
+	wc      *storage.Writer
+	trace   func(bytes int64, err error, kv ...interface{})
+	written int64
+	seq     int
+	timer   *time.Timer
+	err     error
+	closed  bool
+}
+
+// NewRollingWriter returns a writer that streams into a series of objects under prefix, finishing
+// the current object and starting a new one once it holds maxBytes or has been open for maxAge,
+// whichever comes first (either may be zero to disable it). Objects are named
+// "<prefix>/<UTC start time>-<sequence>" so they sort in write order. An object is only started
+// when there is data for it, and Close commits the last, partial object. Like any other upload, each
+// object carries DefaultMetadata and the configured provenance and counts towards MaxOpsPerSecond.
+//
+// A failed upload makes every later Write and Close return the error; objects already rolled stay
+// committed.
+func (gcp *GCPController) NewRollingWriter(g *GCPFS, prefix string, maxBytes int64, maxAge time.Duration) (io.WriteCloser, error) {
+	if maxBytes < 0 || maxAge < 0 {
+		return nil, fmt.Errorf("maxBytes and maxAge cannot be negative")
+	}
+	if maxBytes == 0 && maxAge == 0 {
+		return nil, fmt.Errorf("at least one of maxBytes or maxAge must be set")
+	}
+	ctx, cancel := context.WithCancel(g.ctx)
+	return &rollingWriter{
+		g:        g,
+		prefix:   prefix,
+		maxBytes: maxBytes,
+		maxAge:   maxAge,
+		ctx:      ctx,
+		cancel:   cancel,
+		done:     g.startOp(),
+	}, nil
+}
+
+func (w *rollingWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.closed {
+		return 0, fmt.Errorf("rolling writer is closed")
+	}
+	n := 0
+	for len(p) > 0 && w.err == nil {
+		if w.wc == nil {
+			w.err = w.open(p)
+			continue
+		}
+		chunk := p
+		if w.maxBytes > 0 && int64(len(chunk)) > w.maxBytes-w.written {
+			chunk = chunk[:w.maxBytes-w.written]
+		}
+		m, err := w.wc.Write(chunk)
+		n += m
+		w.written += int64(m)
+		p = p[m:]
+		if err != nil {
+			w.err = fmt.Errorf("object(%s) write error: %v", w.wc.Name, err)
+			break
+		}
+		if w.maxBytes > 0 && w.written >= w.maxBytes {
+			w.err = w.roll()
+		}
+	}
+	return n, w.err
+}
+
+// open starts the next object; head is the data about to be written, used to sniff its content type.
+// Called with mu held.
+func (w *rollingWriter) open(head []byte) error {
+	name := path.Join(w.prefix, fmt.Sprintf("%s-%06d", time.Now().UTC().Format("20060102T150405.000000000Z"), w.seq))
+	fullPath, err := w.g.objectPath(name)
+	if err != nil {
+		return err
+	}
+	if err := w.g.throttle(); err != nil {
+		return err
+	}
+	trace := w.g.traceOp("write_stream", fullPath)
+	wc, err := w.g.newObjectWriter(w.ctx, w.g.rawBucket(w.g.config.BucketName).Object(fullPath), name, nil, head)
+	if err != nil {
+		trace(0, err)
+		return err
+	}
+	// Stamped on the upload itself, as there is no later moment to add them to each rolled object.
+	wc.Metadata = w.g.withProvenance(w.g.withDefaultMetadata(nil))
+	w.wc, w.trace, w.written = wc, trace, 0
+	w.seq++
+	if w.maxAge > 0 {
+		wc := wc
+		w.timer = time.AfterFunc(w.maxAge, func() {
+			w.mu.Lock()
+			defer w.mu.Unlock()
+			// Only roll if the object that armed the timer is still the current one.
+			if w.wc == wc && w.err == nil {
+				w.err = w.roll()
+			}
+		})
+	}
+	return nil
+}
+
+// roll commits the current object. Called with mu held.
+func (w *rollingWriter) roll() error {
+	if w.timer != nil {
+		w.timer.Stop()
+		w.timer = nil
+	}
+	wc := w.wc
+	w.wc = nil
+	if wc == nil {
+		return nil
+	}
+	defer w.g.exists.forget(wc.Name)
+	err := wc.Close()
+	w.trace(w.written, err)
+	if err != nil {
+		return fmt.Errorf("Writer.Close error: %w", err)
+	}
+	return nil
+}
+
+// Close commits the object in progress, if any.
+func (w *rollingWriter) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.closed {
+		return w.err
+	}
+	w.closed = true
+	defer w.done()
+	defer w.cancel()
+	if w.err != nil {
+		// Abort rather than commit whatever was buffered after the failure.
+		if w.wc != nil {
+			abortWriter(w.wc, w.cancel, w.err)
+			w.trace(0, w.err)
+		}
+		return w.err
+	}
+	w.err = w.roll()
+	return w.err
+}
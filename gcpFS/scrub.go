@@ -0,0 +1,105 @@
+package gcpFS
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/storage"
+)
+
+// scrubConcurrency bounds how many objects a scrub verifies at once.
+const scrubConcurrency = 4
+
+// StartScrubber verifies every object under prefix with VerifyIntegrity straight away and then every
+// interval, in the background, calling report for each object that fails (filePath is relative to
+// ParentFolder). Errors wrapping ErrChecksumMismatch mean corruption; others, e.g. a failed listing
+// reported against prefix itself, mean the object could not be checked this time. report may be
+// called from several goroutines at once. Call stop to end scrubbing; it cancels a scan in progress
+// and waits for it to wind down.
+//
+// Every scan downloads every object under prefix, so each one costs a full read of the prefix in
+// operations and, outside the bucket's region, egress. Scope prefix to the data worth checking and
+// choose interval accordingly, e.g. daily or weekly rather than minutes. A scan still running when
+// the next is due delays it instead of overlapping.
+func (gcp *GCPController) StartScrubber(g *GCPFS, prefix string, interval time.Duration, report func(filePath string, err error)) (stop func(), err error) {
+	if interval <= 0 {
+		return nil, fmt.Errorf("interval must be positive")
+	}
+	if report == nil {
+		return nil, fmt.Errorf("report cannot be nil")
+	}
+	ctx, cancel := context.WithCancel(g.ctx)
+	finished := make(chan struct{})
+	go func() {
+		defer close(finished)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			gcp.scrub(ctx, g, prefix, report)
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			cancel()
+			<-finished
+		})
+	}, nil
+}
+
+// scrub runs a single pass over prefix.
+func (gcp *GCPController) scrub(ctx context.Context, g *GCPFS, prefix string, report func(filePath string, err error)) {
+	defer g.startOp()()
+	fullPrefix, err := g.listPrefix(prefix)
+	if err != nil {
+		report(prefix, err)
+		return
+	}
+	q := &storage.Query{Prefix: fullPrefix}
+	if err := q.SetAttrSelection([]string{"Name"}); err != nil {
+		report(prefix, err)
+		return
+	}
+	// A set, since a retried listing sees names again.
+	names := make(map[string]bool)
+	err = g.listQuery(ctx, q, func(attrs *storage.ObjectAttrs) { names[attrs.Name] = true })
+	if err != nil {
+		if ctx.Err() == nil {
+			report(prefix, fmt.Errorf("Bucket(%s).Objects: %v", g.config.BucketName, err))
+		}
+		return
+	}
+
+	var (
+		wg      sync.WaitGroup
+		workers = make(chan struct{}, scrubConcurrency)
+	)
+	for name := range names {
+		if strings.HasSuffix(name, "/") {
+			continue
+		}
+		select {
+		case <-ctx.Done():
+			wg.Wait()
+			return
+		case workers <- struct{}{}:
+		}
+		wg.Add(1)
+		go func(name string) {
+			defer wg.Done()
+			defer func() { <-workers }()
+			if _, err := gcp.verifyIntegrity(ctx, g, name); err != nil && ctx.Err() == nil {
+				report(g.relativeName(name), err)
+			}
+		}(name)
+	}
+	wg.Wait()
+}
@@ -0,0 +1,116 @@
+package gcpFS
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"github.com/ninjamarcus/ninjaStorage/models"
+)
+
+// signingHint is appended to signing failures, which the SDK otherwise reports cryptically.
+const signingHint = "signing needs a service account: set SigningAccessID and SigningPrivateKey, point " +
+	"GOOGLE_APPLICATION_CREDENTIALS at a service account key file, or run as a service account allowed " +
+	"to call iam.serviceAccounts.signBlob"
+
+// GenerateUploadPolicy signs a V4 POST policy letting a browser upload one object to filePath with an
+// HTML form, without the bytes passing through this service. The policy expires after expiry and
+// only accepts uploads of at most maxSize bytes. allowedContentType, when set, is the Content-Type
+// the form must carry; a "type/*" value accepts any subtype.
+//
+// Signing requires a service account private key (SigningPrivateKey, or the key file in
+// GOOGLE_APPLICATION_CREDENTIALS). Without one the SDK falls back to the IAM signBlob API, which
+// needs the iamcredentials API enabled and iam.serviceAccounts.signBlob on the account; user
+// credentials from gcloud cannot sign at all.
+func (gcp *GCPController) GenerateUploadPolicy(g *GCPFS, filePath string, maxSize int64, expiry time.Duration, allowedContentType string) (*models.PostPolicy, error) {
+	defer g.startOp()()
+	if filePath == "" {
+		return nil, fmt.Errorf("Filepath cannot be empty")
+	}
+	if maxSize <= 0 {
+		return nil, fmt.Errorf("maxSize must be positive")
+	}
+	if expiry <= 0 {
+		return nil, fmt.Errorf("expiry must be positive")
+	}
+	opts := &storage.PostPolicyV4Options{
+		GoogleAccessID: g.config.SigningAccessID,
+		PrivateKey:     g.config.SigningPrivateKey,
+		Expires:        time.Now().Add(expiry),
+		Conditions:     []storage.PostPolicyV4Condition{storage.ConditionContentLengthRange(0, uint64(maxSize))},
+	}
+	switch {
+	case strings.HasSuffix(allowedContentType, "/*"):
+		opts.Conditions = append(opts.Conditions, storage.ConditionStartsWith("$Content-Type", strings.TrimSuffix(allowedContentType, "*")))
+	case allowedContentType != "":
+		opts.Fields = &storage.PolicyV4Fields{ContentType: allowedContentType}
+	}
+	fullPath, err := g.objectPath(filePath)
+	if err != nil {
+		return nil, err
+	}
+	policy, err := g.rawBucket(g.config.BucketName).GenerateSignedPostPolicyV4(fullPath, opts)
+	if err != nil {
+		return nil, fmt.Errorf("cannot sign upload policy for object(%s): %v (%s)", fullPath, err, signingHint)
+	}
+	return &models.PostPolicy{URL: policy.URL, Fields: policy.Fields}, nil
+}
+
+// maxSignedURLExpiryV4 is the longest lifetime GCS accepts for a V4 signature.
+const maxSignedURLExpiryV4 = 7 * 24 * time.Hour
+
+// SignedURL returns a URL that lets anyone holding it download the object at filePath with a plain
+// GET, without credentials, until expiry has passed. It is signed with the V4 scheme unless
+// SigningScheme is "v2"; V4 URLs are valid for at most seven days. Nothing is checked against GCS,
+// so the object need not exist yet.
+//
+// Signing needs a service account private key, see GenerateUploadPolicy. Under application default
+// credentials from gcloud (a user account) there is none, and the returned error says how to
+// configure one instead of the SDK's own message.
+func (gcp *GCPController) SignedURL(g *GCPFS, filePath string, expiry time.Duration) (string, error) {
+	defer g.startOp()()
+	return gcp.signedURL(g, filePath, http.MethodGet, expiry, "")
+}
+
+// SignedUploadURL returns a URL that lets anyone holding it upload the object at filePath with a
+// plain PUT of the content, without credentials, until expiry has passed, so browsers can upload
+// straight to GCS. An object already at filePath is replaced. When contentType is set it is signed
+// into the URL and the PUT must carry exactly that Content-Type header; otherwise the PUT must not
+// send one. The scheme, expiry limits and signing requirements are those of SignedURL.
+func (gcp *GCPController) SignedUploadURL(g *GCPFS, filePath string, expiry time.Duration, contentType string) (string, error) {
+	defer g.startOp()()
+	return gcp.signedURL(g, filePath, http.MethodPut, expiry, contentType)
+}
+
+func (gcp *GCPController) signedURL(g *GCPFS, filePath string, method string, expiry time.Duration, contentType string) (string, error) {
+	if filePath == "" {
+		return "", fmt.Errorf("Filepath cannot be empty")
+	}
+	if expiry <= 0 {
+		return "", fmt.Errorf("expiry must be positive")
+	}
+	scheme := storage.SigningSchemeV4
+	if g.config.SigningScheme == models.SigningSchemeV2 {
+		scheme = storage.SigningSchemeV2
+	} else if expiry > maxSignedURLExpiryV4 {
+		return "", fmt.Errorf("expiry %v is longer than the %v allowed for V4 signed URLs", expiry, maxSignedURLExpiryV4)
+	}
+	fullPath, err := g.objectPath(filePath)
+	if err != nil {
+		return "", err
+	}
+	u, err := g.rawBucket(g.config.BucketName).SignedURL(fullPath, &storage.SignedURLOptions{
+		GoogleAccessID: g.config.SigningAccessID,
+		PrivateKey:     g.config.SigningPrivateKey,
+		Method:         method,
+		Expires:        time.Now().Add(expiry),
+		ContentType:    contentType,
+		Scheme:         scheme,
+	})
+	if err != nil {
+		return "", fmt.Errorf("cannot sign URL for object(%s): %v (%s)", fullPath, err, signingHint)
+	}
+	return u, nil
+}
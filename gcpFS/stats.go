@@ -0,0 +1,44 @@
+package gcpFS
+
+import "sync/atomic"
+
+// OpStats is a snapshot of the operations currently running against a GCPFS.
+type OpStats struct {
+	// InFlight is the number of operations in progress. A streaming reader counts until it is closed.
+	InFlight int64
+	// MaxInFlight is the highest InFlight value seen since the GCPFS was created.
+	MaxInFlight int64
+}
+
+// opStats holds the live counters, it is shared by pointer so every copy of a GCPFS reports the same figures.
+type opStats struct {
+	inFlight    int64
+	maxInFlight int64
+}
+
+// startOp marks an operation as in flight; call the returned func when it finishes.
+func (g *GCPFS) startOp() func() {
+	s := g.stats
+	if s == nil {
+		return func() {}
+	}
+	n := atomic.AddInt64(&s.inFlight, 1)
+	for {
+		max := atomic.LoadInt64(&s.maxInFlight)
+		if n <= max || atomic.CompareAndSwapInt64(&s.maxInFlight, max, n) {
+			break
+		}
+	}
+	return func() { atomic.AddInt64(&s.inFlight, -1) }
+}
+
+// Stats reports the in-flight operation gauge and its high-water mark.
+func (gcp *GCPController) Stats(g *GCPFS) OpStats {
+	if g.stats == nil {
+		return OpStats{}
+	}
+	return OpStats{
+		InFlight:    atomic.LoadInt64(&g.stats.inFlight),
+		MaxInFlight: atomic.LoadInt64(&g.stats.maxInFlight),
+	}
+}
@@ -0,0 +1,46 @@
+package gcpFS
+
+import (
+	"fmt"
+
+	"cloud.google.com/go/storage"
+	"google.golang.org/api/iterator"
+)
+
+// StorageClassBreakdown sums the size in bytes of the objects under prefix by storage class, keyed
+// as GCS reports it (e.g. "STANDARD", "NEARLINE", "COLDLINE", "ARCHIVE"), to show how storage cost
+// is spread across tiers. Only name, size and storage class are fetched for each object.
+func (gcp *GCPController) StorageClassBreakdown(g *GCPFS, prefix string) (map[string]int64, error) {
+	defer g.startOp()()
+	fullPrefix, err := g.listPrefix(prefix)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := g.opContext(g.config.ListTimeout)
+	defer cancel()
+
+	q := &storage.Query{Prefix: fullPrefix}
+	if err := q.SetAttrSelection([]string{"Name", "Size", "StorageClass"}); err != nil {
+		return nil, err
+	}
+	var totals map[string]int64
+	// A failed listing restarts from scratch, so the totals are reset on every attempt.
+	err = g.retry(ctx, func() error {
+		totals = make(map[string]int64)
+		it := g.bucket(g.config.BucketName).Objects(ctx, q)
+		for {
+			attrs, err := it.Next()
+			if err == iterator.Done {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			totals[attrs.StorageClass] += attrs.Size
+		}
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Bucket(%s).Objects: %w", g.config.BucketName, wrapGCSError(ctx, err))
+	}
+	return totals, nil
+}
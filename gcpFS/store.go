@@ -0,0 +1,53 @@
+package gcpFS
+
+import (
+	ninjaStorage "github.com/ninjamarcus/ninjaStorage/Interfaces"
+	"github.com/ninjamarcus/ninjaStorage/models"
+)
+
+// Store adapts a GCPFS to the backend-neutral ninjaStorage.Storage interface, for code that should
+// not care which backend it writes to. Every method behaves like the GCPController method of the
+// same name.
+type Store struct {
+	gcp *GCPController
+	g   *GCPFS
+}
+
+var _ ninjaStorage.Storage = (*Store)(nil)
+
+// NewStore wraps g. Closing g is still up to the caller.
+func NewStore(g *GCPFS) *Store {
+	return &Store{gcp: &GCPController{}, g: g}
+}
+
+func (s *Store) Write(data []byte, filePath string, metaData *models.FileMetaData) (*models.FileMetaData, error) {
+	return s.gcp.Write(s.g, data, filePath, metaData)
+}
+
+func (s *Store) Read(filePath string) ([]byte, *models.FileMetaData, error) {
+	return s.gcp.Read(s.g, filePath)
+}
+
+func (s *Store) Stat(filePath string) (*models.FileMetaData, error) {
+	return s.gcp.Stat(s.g, filePath)
+}
+
+func (s *Store) Exists(filePath string) (bool, error) {
+	return s.gcp.Exists(s.g, filePath)
+}
+
+func (s *Store) Delete(filePath string) error {
+	return s.gcp.Delete(s.g, filePath)
+}
+
+func (s *Store) Move(filePathFrom string, filePathTo string) error {
+	return s.gcp.Move(s.g, filePathFrom, filePathTo)
+}
+
+func (s *Store) Copy(filePathFrom string, filePathTo string) error {
+	return s.gcp.Copy(s.g, filePathFrom, filePathTo)
+}
+
+func (s *Store) List(prefix string) (map[string]*models.FileMetaData, error) {
+	return s.gcp.List(s.g, prefix)
+}
@@ -0,0 +1,316 @@
+package gcpFS
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"strconv"
+
+	"cloud.google.com/go/storage"
+	"github.com/ninjamarcus/ninjaStorage/models"
+)
+
+// lineReaderBufferSize is large enough that typical log/CSV lines are scanned without refills.
+const lineReaderBufferSize = 64 * 1024
+
+// streamCloser closes the underlying GCS reader and releases the context it was opened with.
+type streamCloser struct {
+	rc     io.Closer
+	cancel context.CancelFunc
+	done   func()
+}
+
+func (s *streamCloser) Close() error {
+	defer s.done()
+	defer s.cancel()
+	return s.rc.Close()
+}
+
+// OpenLineReader opens the object at filePath for line-oriented reading without downloading it
+// first, with the same mirror and lowercase-key fallbacks as Read. Use ReadString('\n') or wrap the
+// reader in a bufio.Scanner; the caller must call Close on the returned io.Closer when done, which
+// also closes the underlying GCS reader.
+func (gcp *GCPController) OpenLineReader(g *GCPFS, filePath string) (*bufio.Reader, io.Closer, error) {
+	done := g.startOp()
+	fullPath, _, rc, cancel, err := g.openStream(filePath)
+	if err != nil {
+		done()
+		return nil, nil, err
+	}
+	r, err := g.decodeReader(rc)
+	if err != nil {
+		rc.Close()
+		cancel()
+		done()
+		return nil, nil, fmt.Errorf("object(%s) cannot be decompressed: %v", fullPath, err)
+	}
+	return bufio.NewReaderSize(r, lineReaderBufferSize), &streamCloser{rc: rc, cancel: cancel, done: done}, nil
+}
+
+// ReadStream opens the object at filePath for reading without downloading it first, e.g. to copy a
+// large object to disk or an HTTP response. Reads follow the same mirror and lowercase-key fallbacks
+// and the same decoding as Read. The metadata comes from the download response itself, so only
+// Bucket, Name, ContentType, ContentEncoding, Size, Updated, Generation and Metageneration are
+// filled in; use Stat for the rest. The caller must Close the reader, which also releases the GCS
+// connection. There is no overall deadline, so a slow consumer does not fail the download.
+func (gcp *GCPController) ReadStream(g *GCPFS, filePath string) (io.ReadCloser, *models.FileMetaData, error) {
+	done := g.startOp()
+	fullPath, o, rc, cancel, err := g.openStream(filePath)
+	if err != nil {
+		done()
+		return nil, nil, err
+	}
+	r, err := g.decodeReader(g.bufferReader(rc))
+	if err != nil {
+		rc.Close()
+		cancel()
+		done()
+		return nil, nil, fmt.Errorf("object(%s) cannot be decompressed: %v", fullPath, err)
+	}
+	mdata := &models.FileMetaData{
+		Bucket:          o.BucketName(),
+		Name:            fullPath,
+		ContentType:     rc.Attrs.ContentType,
+		ContentEncoding: rc.Attrs.ContentEncoding,
+		Size:            rc.Attrs.Size,
+		Updated:         rc.Attrs.LastModified,
+		Generation:      rc.Attrs.Generation,
+		Metageneration:  rc.Attrs.Metageneration,
+	}
+	return struct {
+		io.Reader
+		io.Closer
+	}{r, &streamCloser{rc: rc, cancel: cancel, done: done}}, mdata, nil
+}
+
+// openStream opens a reader on filePath for the streaming reads, trying each of its read paths and
+// failing over to the mirror like Read. There is no deadline; on success the caller owns rc and must
+// call cancel once done with it.
+func (g *GCPFS) openStream(filePath string) (fullPath string, o *storage.ObjectHandle, rc *storage.Reader, cancel context.CancelFunc, err error) {
+	paths, err := g.readPaths(filePath)
+	if err != nil {
+		return "", nil, nil, nil, err
+	}
+	ctx, cancel := context.WithCancel(g.ctx)
+	for _, fullPath = range paths {
+		if o, rc, err = g.openReader(ctx, fullPath, false); err != storage.ErrObjectNotExist {
+			break
+		}
+	}
+	if err == storage.ErrObjectNotExist {
+		cancel()
+		return "", nil, nil, nil, fmt.Errorf("object(%s) cannot be read: %w", fullPath, models.ErrNotFound)
+	}
+	if err != nil {
+		err = readError(ctx, fullPath, err)
+		cancel()
+		return "", nil, nil, nil, err
+	}
+	return fullPath, o, rc, cancel, nil
+}
+
+// bufferReader wraps r in a bufio.Reader of the configured ReadBufferSize, if any.
+func (g *GCPFS) bufferReader(r io.Reader) io.Reader {
+	if g.config.ReadBufferSize <= 0 {
+		return r
+	}
+	return bufio.NewReaderSize(r, g.config.ReadBufferSize)
+}
+
+// decodeReader applies the configured read-side decoding to an object reader.
+func (g *GCPFS) decodeReader(r io.Reader) (io.Reader, error) {
+	if !g.config.DetectGzip {
+		return r, nil
+	}
+	return gunzipIfCompressed(r)
+}
+
+// gunzipIfCompressed peeks at the first bytes of r and, if they are the gzip magic number, returns a
+// reader producing the decompressed content. Anything else is passed through untouched.
+func gunzipIfCompressed(r io.Reader) (io.Reader, error) {
+	br := bufio.NewReader(r)
+	magic, err := br.Peek(2)
+	if err != nil || magic[0] != 0x1f || magic[1] != 0x8b {
+		return br, nil
+	}
+	return gzip.NewReader(br)
+}
+
+// abortWriter fails an in-progress upload so nothing is committed. The pipe feeding the upload is
+// closed with err first: merely cancelling the context is noticed asynchronously, and a Close in the
+// meantime would end the body cleanly and commit the partial object. Close then waits for the upload
+// goroutine to finish.
+func abortWriter(wc *storage.Writer, cancel context.CancelFunc, err error) {
+	wc.CloseWithError(err) //nolint:staticcheck // the synchronous abort is the point here
+	cancel()
+	wc.Close()
+}
+
+// Custom metadata keys written by WriteStreamWithAutoMeta.
+const (
+	// AutoMetaSizeKey holds the number of bytes streamed, in decimal.
+	AutoMetaSizeKey = "content-size"
+	// AutoMetaSHA256Key holds the hex SHA-256 of the bytes streamed.
+	AutoMetaSHA256Key = "content-sha256"
+)
+
+// WriteStreamWithAutoMeta streams r to filePath without knowing its length up front, counting and
+// hashing the bytes on the way. Once the upload completes the computed size and SHA-256 are added to
+// the object's user metadata (under AutoMetaSizeKey and AutoMetaSHA256Key, alongside any user
+// supplied keys), after checking them against what GCS stored.
+func (gcp *GCPController) WriteStreamWithAutoMeta(g *GCPFS, r io.Reader, filePath string, metaData *models.FileMetaData) (mdata *models.FileMetaData, err error) {
+	defer g.startOp()()
+	fullPath, err := g.objectPath(filePath)
+	if err != nil {
+		return nil, err
+	}
+	done := g.traceOp("write", fullPath)
+	defer func() {
+		if mdata != nil {
+			done(mdata.Size, err)
+		} else {
+			done(0, err)
+		}
+	}()
+	if err := g.throttle(); err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithCancel(g.ctx)
+	defer cancel()
+	defer g.exists.forget(fullPath)
+	o := g.rawBucket(g.config.BucketName).Object(fullPath)
+
+	br := bufio.NewReaderSize(r, 512)
+	head, _ := br.Peek(512)
+	wc, err := g.newObjectWriter(ctx, o, filePath, metaData, head)
+	if err != nil {
+		return nil, err
+	}
+	sha := sha256.New()
+	sum := md5.New()
+	n, err := io.Copy(wc, io.TeeReader(br, io.MultiWriter(sha, sum)))
+	if err != nil {
+		abortWriter(wc, cancel, err)
+		return nil, fmt.Errorf("io.Copy error: %v", err)
+	}
+	if err := wc.Close(); err != nil {
+		if isChecksumMismatch(err) {
+			return nil, fmt.Errorf("object(%s) does not match the supplied checksum: %w", fullPath, models.ErrChecksumMismatch)
+		}
+		return nil, fmt.Errorf("Writer.Close error: %w", wrapGCSError(ctx, err))
+	}
+	attrs := wc.Attrs()
+	if attrs.Size != n {
+		return nil, fmt.Errorf("object(%s) stored %d bytes but %d were streamed: %w", fullPath, attrs.Size, n, models.ErrChecksumMismatch)
+	}
+	if len(attrs.MD5) > 0 && !bytes.Equal(attrs.MD5, sum.Sum(nil)) {
+		return nil, fmt.Errorf("object(%s) stored MD5 does not match the streamed bytes: %w", fullPath, models.ErrChecksumMismatch)
+	}
+
+	meta := map[string]string{}
+	if metaData != nil {
+		for k, v := range metaData.UserMetaData {
+			meta[k] = v
+		}
+	}
+	meta[AutoMetaSizeKey] = strconv.FormatInt(n, 10)
+	meta[AutoMetaSHA256Key] = hex.EncodeToString(sha.Sum(nil))
+	if err := gcp.writeMetadata(g, o, &models.FileMetaData{UserMetaData: meta}); err != nil {
+		return nil, fmt.Errorf("error writing metadata: %w", err)
+	}
+	stored, err := o.Attrs(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("could not retrieve object attributes: %w", wrapGCSError(ctx, err))
+	}
+	return g.parseMetaData(stored), nil
+}
+
+// WriteStream uploads everything read from r to filePath, overwriting any object already there,
+// without holding it in memory, e.g. to pipe an HTTP request body or an open file straight to GCS.
+// Unlike Write there is no fixed deadline, since the length of r is unknown; an upload failing part
+// way commits nothing. Content type detection sniffs the first 512 bytes.
+func (gcp *GCPController) WriteStream(g *GCPFS, r io.Reader, filePath string, metaData *models.FileMetaData) (*models.FileMetaData, error) {
+	defer g.startOp()()
+	return gcp.writeStream(g, r, filePath, metaData, 0, nil)
+}
+
+// WriteStreamWithBudget streams r to filePath like WriteStreamWithAutoMeta, but gives up as soon as
+// more than maxBytes have been read. The upload is then aborted before the writer is closed, so no
+// partial object is committed, and the error wraps ErrObjectTooLarge. Use it for uploads from
+// untrusted sources such as HTTP request bodies.
+func (gcp *GCPController) WriteStreamWithBudget(g *GCPFS, r io.Reader, filePath string, metaData *models.FileMetaData, maxBytes int64) (*models.FileMetaData, error) {
+	defer g.startOp()()
+	if maxBytes <= 0 {
+		return nil, fmt.Errorf("maxBytes must be positive")
+	}
+	return gcp.writeStream(g, r, filePath, metaData, maxBytes, nil)
+}
+
+// writeStream copies r into a new object at filePath without a fixed deadline. A positive maxBytes
+// aborts the upload with ErrObjectTooLarge once more than that has been read; zero means no limit.
+// progress, if not nil, is reported to with an unknown total.
+func (gcp *GCPController) writeStream(g *GCPFS, r io.Reader, filePath string, metaData *models.FileMetaData, maxBytes int64, progress ProgressFunc) (mdata *models.FileMetaData, err error) {
+	fullPath, err := g.objectPath(filePath)
+	if err != nil {
+		return nil, err
+	}
+	done := g.traceOp("write_stream", fullPath)
+	defer func() {
+		if mdata != nil {
+			done(mdata.Size, err)
+		} else {
+			done(0, err)
+		}
+	}()
+	if err := g.throttle(); err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithCancel(g.ctx)
+	defer cancel()
+	defer g.exists.forget(fullPath)
+	o := g.rawBucket(g.config.BucketName).Object(fullPath)
+
+	br := bufio.NewReaderSize(r, 512)
+	head, _ := br.Peek(512)
+	wc, err := g.newObjectWriter(ctx, o, filePath, metaData, head)
+	if err != nil {
+		return nil, err
+	}
+	var src io.Reader = br
+	if maxBytes > 0 {
+		// Read one byte past the budget so an exactly-sized stream is still accepted.
+		src = io.LimitReader(br, maxBytes+1)
+	}
+	counted := withProgress(src, progress, -1)
+	n, err := io.Copy(wc, counted)
+	if err == nil && maxBytes > 0 && n > maxBytes {
+		err = fmt.Errorf("object(%s) exceeds %d bytes: %w", fullPath, maxBytes, models.ErrObjectTooLarge)
+	} else if err != nil {
+		err = fmt.Errorf("io.Copy error: %v", err)
+	}
+	if err != nil {
+		abortWriter(wc, cancel, err)
+		return nil, err
+	}
+	if err := wc.Close(); err != nil {
+		if isChecksumMismatch(err) {
+			return nil, fmt.Errorf("object(%s) does not match the supplied checksum: %w", fullPath, models.ErrChecksumMismatch)
+		}
+		return nil, fmt.Errorf("Writer.Close error: %w", wrapGCSError(ctx, err))
+	}
+	counted.done()
+	if err := gcp.writeMetadata(g, o, metaData); err != nil {
+		return nil, fmt.Errorf("error writing metadata: %w", err)
+	}
+	stored, err := o.Attrs(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("could not retrieve object attributes: %w", wrapGCSError(ctx, err))
+	}
+	return g.parseMetaData(stored), nil
+}
@@ -0,0 +1,56 @@
+package gcpFS
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+
+	"cloud.google.com/go/storage"
+	"github.com/ninjamarcus/ninjaStorage/models"
+)
+
+// StreamTo sends the object at filePath as the body of req, e.g. a POST or PUT to a webhook, without
+// buffering it, and returns the response for the caller to inspect and close. Content-Length is the
+// object's stored size and Content-Type its content type unless req already sets one. Objects stored
+// gzip-encoded are sent as stored, with a matching Content-Encoding header. The request is made with
+// http.DefaultClient; cancelling req's context also stops the GCS read.
+func (gcp *GCPController) StreamTo(g *GCPFS, filePath string, req *http.Request) (*http.Response, error) {
+	fullPath, err := g.objectPath(filePath)
+	if err != nil {
+		return nil, err
+	}
+	done := g.startOp()
+	ctx, cancel := context.WithCancel(req.Context())
+	_, rc, err := g.openReader(ctx, fullPath, true)
+	if err != nil {
+		wrapped := readError(ctx, fullPath, err)
+		cancel()
+		done()
+		if err == storage.ErrObjectNotExist {
+			return nil, fmt.Errorf("object(%s) cannot be read: %w", fullPath, models.ErrNotFound)
+		}
+		return nil, wrapped
+	}
+
+	// The transport closes the body once it has been sent, which releases the GCS reader.
+	body := struct {
+		io.Reader
+		io.Closer
+	}{rc, &streamCloser{rc: rc, cancel: cancel, done: done}}
+	out := req.Clone(req.Context())
+	out.Body = body
+	out.GetBody = nil
+	out.ContentLength = rc.Attrs.Size
+	if out.Header.Get("Content-Type") == "" && rc.Attrs.ContentType != "" {
+		out.Header.Set("Content-Type", rc.Attrs.ContentType)
+	}
+	if rc.Attrs.ContentEncoding != "" {
+		out.Header.Set("Content-Encoding", rc.Attrs.ContentEncoding)
+	}
+	resp, err := http.DefaultClient.Do(out)
+	if err != nil {
+		return nil, fmt.Errorf("cannot stream object(%s) to %s: %v", fullPath, req.URL.Redacted(), err)
+	}
+	return resp, nil
+}
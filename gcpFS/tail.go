@@ -0,0 +1,42 @@
+package gcpFS
+
+import (
+	"fmt"
+
+	"cloud.google.com/go/storage"
+	"github.com/ninjamarcus/ninjaStorage/models"
+)
+
+// Tail returns the last n bytes of the object at filePath with a single range read, or the whole
+// object when it is shorter than n. The metadata is that of the generation read, so callers polling
+// a growing object can compare FileMetaData.Size between calls and only fetch what is new.
+func (gcp *GCPController) Tail(g *GCPFS, filePath string, n int64) ([]byte, *models.FileMetaData, error) {
+	defer g.startOp()()
+	if n < 0 {
+		return nil, nil, fmt.Errorf("n cannot be negative: %d", n)
+	}
+	fullPath, err := g.objectPath(filePath)
+	if err != nil {
+		return nil, nil, err
+	}
+	ctx, cancel := g.opContext(g.config.ReadTimeout)
+	defer cancel()
+	o := g.rawBucket(g.config.BucketName).Object(fullPath)
+	attrs, err := g.keyed(o).Attrs(ctx)
+	if err == storage.ErrObjectNotExist {
+		return nil, nil, fmt.Errorf("object(%s) cannot be found: %w", fullPath, models.ErrNotFound)
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("object.Attrs: %w", wrapGCSError(ctx, err))
+	}
+	offset := attrs.Size - n
+	if offset < 0 {
+		offset = 0
+	}
+	// Pin the generation so an append landing in between cannot shift the range.
+	data, err := readRange(ctx, g.keyed(o.Generation(attrs.Generation)), offset, attrs.Size-offset)
+	if err != nil {
+		return nil, nil, readError(ctx, fullPath, err)
+	}
+	return data, g.parseMetaData(attrs), nil
+}
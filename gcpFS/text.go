@@ -0,0 +1,78 @@
+package gcpFS
+
+import (
+	"bytes"
+	"encoding/binary"
+	"mime"
+	"strings"
+	"unicode/utf16"
+
+	"github.com/ninjamarcus/ninjaStorage/models"
+)
+
+// TextCharsetKey is the custom metadata key ReadText checks for a charset when the content type
+// does not name one.
+const TextCharsetKey = "charset"
+
+var (
+	bomUTF8    = []byte{0xef, 0xbb, 0xbf}
+	bomUTF16LE = []byte{0xff, 0xfe}
+	bomUTF16BE = []byte{0xfe, 0xff}
+)
+
+// ReadText reads the object at filePath as text and returns it as UTF-8. A byte order mark decides
+// the encoding when present (UTF-8 or UTF-16, and is stripped). Otherwise the charset comes from the
+// content type's charset parameter or the TextCharsetKey metadata: UTF-8, US-ASCII, ISO-8859-1 and
+// UTF-16LE/BE are built in, and GCPFSConfig.TextDecoders can supply any other (e.g. backed by
+// golang.org/x/text). Content in an unknown charset is returned as is.
+func (gcp *GCPController) ReadText(g *GCPFS, filePath string) (string, *models.FileMetaData, error) {
+	defer g.startOp()()
+	data, mdata, err := gcp.read(g, filePath, nil)
+	if err != nil {
+		return "", nil, err
+	}
+	switch {
+	case bytes.HasPrefix(data, bomUTF8):
+		return string(data[len(bomUTF8):]), mdata, nil
+	case bytes.HasPrefix(data, bomUTF16LE):
+		return decodeUTF16(data[len(bomUTF16LE):], binary.LittleEndian), mdata, nil
+	case bytes.HasPrefix(data, bomUTF16BE):
+		return decodeUTF16(data[len(bomUTF16BE):], binary.BigEndian), mdata, nil
+	}
+
+	charset := ""
+	if _, params, err := mime.ParseMediaType(mdata.ContentType); err == nil {
+		charset = params["charset"]
+	}
+	if charset == "" {
+		charset = mdata.UserMetaData[TextCharsetKey]
+	}
+	charset = strings.ToLower(charset)
+	if decode, ok := g.config.TextDecoders[charset]; ok {
+		text, err := decode(data)
+		return text, mdata, err
+	}
+	switch charset {
+	case "iso-8859-1", "latin1", "l1":
+		runes := make([]rune, len(data))
+		for i, b := range data {
+			runes[i] = rune(b)
+		}
+		return string(runes), mdata, nil
+	case "utf-16le":
+		return decodeUTF16(data, binary.LittleEndian), mdata, nil
+	case "utf-16be", "utf-16":
+		// Without a BOM, UTF-16 is big endian (RFC 2781).
+		return decodeUTF16(data, binary.BigEndian), mdata, nil
+	}
+	return string(data), mdata, nil
+}
+
+// decodeUTF16 converts UTF-16 in the given byte order to a string. A trailing odd byte is dropped.
+func decodeUTF16(data []byte, order binary.ByteOrder) string {
+	units := make([]uint16, len(data)/2)
+	for i := range units {
+		units[i] = order.Uint16(data[2*i:])
+	}
+	return string(utf16.Decode(units))
+}
@@ -0,0 +1,15 @@
+package gcpFS
+
+import (
+	"context"
+	"time"
+)
+
+// opContext derives the context of a single operation from g.ctx. A positive timeout becomes its
+// deadline; anything else leaves the deadline to g.ctx, see models.NoTimeout.
+func (g *GCPFS) opContext(timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		return context.WithCancel(g.ctx)
+	}
+	return context.WithTimeout(g.ctx, timeout)
+}
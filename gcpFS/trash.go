@@ -0,0 +1,92 @@
+package gcpFS
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/ninjamarcus/ninjaStorage/models"
+)
+
+// Custom metadata keys SoftDelete stamps onto trashed objects.
+const (
+	// TrashedFromKey holds the path, relative to ParentFolder, the object was deleted from.
+	TrashedFromKey = "trashed-from"
+	// TrashedAtKey holds the deletion time, RFC 3339 in UTC.
+	TrashedAtKey = "trashed-at"
+)
+
+// trashTimeFormat names the per-deletion folder in the trash: sortable, and fine-grained enough that
+// deleting the same path twice does not collide.
+const trashTimeFormat = "20060102T150405.000000000Z"
+
+// SoftDelete is a recoverable Delete: it moves filePath to <TrashPrefix><deletion time>/<filePath>,
+// stamping the original path and deletion time under TrashedFromKey and TrashedAtKey, where Restore
+// can bring it back from. The original is only removed if it was not replaced while being copied.
+// A held object is refused with ErrObjectHeld before anything is copied, and when the original
+// cannot be deleted the trash copy is removed again, so a failed SoftDelete can simply be retried.
+func (gcp *GCPController) SoftDelete(g *GCPFS, filePath string) error {
+	defer g.startOp()()
+	from, err := sanitizePath(filePath)
+	if err != nil {
+		return err
+	}
+	if g.isTrashed(from) {
+		return fmt.Errorf("%s is already in the trash", filePath)
+	}
+	now := time.Now().UTC()
+	trashedPath := g.trashPrefix() + now.Format(trashTimeFormat) + "/" + from
+	stamp := &models.FileMetaData{UserMetaData: map[string]string{
+		TrashedFromKey: from,
+		TrashedAtKey:   now.Format(time.RFC3339),
+	}}
+	if _, err := gcp.moveWithMetadata(g, filePath, trashedPath, stamp, true, nil); err != nil {
+		return fmt.Errorf("could not move %s to the trash: %w", filePath, err)
+	}
+	return nil
+}
+
+// Restore moves trashedPath, an object SoftDelete put below TrashPrefix, back to destPath, or to
+// the path it was deleted from when destPath is empty, and removes the trash stamps from its user
+// metadata. As with MoveWithMetadata, an object already at the destination fails the restore with
+// ErrAlreadyExists.
+func (gcp *GCPController) Restore(g *GCPFS, trashedPath string, destPath string) error {
+	defer g.startOp()()
+	if !g.isTrashed(trashedPath) {
+		return fmt.Errorf("%s is not below the trash folder %s", trashedPath, g.trashPrefix())
+	}
+	if destPath == "" {
+		mdata, err := gcp.Stat(g, trashedPath)
+		if err != nil {
+			return err
+		}
+		if destPath = mdata.UserMetaData[TrashedFromKey]; destPath == "" {
+			return fmt.Errorf("%s has no %s to restore it to", trashedPath, TrashedFromKey)
+		}
+	}
+	if g.isTrashed(destPath) {
+		return fmt.Errorf("cannot restore %s into the trash", trashedPath)
+	}
+	if _, err := gcp.moveWithMetadata(g, trashedPath, destPath, nil, true, []string{TrashedFromKey, TrashedAtKey}); err != nil {
+		return fmt.Errorf("could not restore %s to %s: %w", trashedPath, destPath, err)
+	}
+	return nil
+}
+
+// trashPrefix returns the configured TrashPrefix, or DefaultTrashPrefix.
+func (g *GCPFS) trashPrefix() string {
+	if g.config.TrashPrefix == "" {
+		return models.DefaultTrashPrefix
+	}
+	return g.config.TrashPrefix
+}
+
+// isTrashed reports whether filePath is below the trash folder. Invalid paths are not.
+func (g *GCPFS) isTrashed(filePath string) bool {
+	fullPath, err := g.objectPath(filePath)
+	if err != nil {
+		return false
+	}
+	trash, err := g.objectPath(g.trashPrefix())
+	return err == nil && strings.HasPrefix(fullPath+"/", trash+"/")
+}
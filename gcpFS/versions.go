@@ -0,0 +1,81 @@
+package gcpFS
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+
+	"cloud.google.com/go/storage"
+	"github.com/ninjamarcus/ninjaStorage/models"
+)
+
+// ReadGeneration downloads one generation of the object at filePath, which with object versioning
+// enabled may be a noncurrent one, e.g. taken from FileMetaData.Generation or a versioned
+// ListWithOptions. A generation that does not exist (any more) wraps ErrNotFound.
+func (gcp *GCPController) ReadGeneration(g *GCPFS, filePath string, generation int64) ([]byte, *models.FileMetaData, error) {
+	defer g.startOp()()
+	if generation <= 0 {
+		return nil, nil, fmt.Errorf("generation must be positive: %d", generation)
+	}
+	fullPath, err := g.objectPath(filePath)
+	if err != nil {
+		return nil, nil, err
+	}
+	ctx, cancel := g.opContext(g.config.ReadTimeout)
+	defer cancel()
+	o := g.rawBucket(g.config.BucketName).Object(fullPath).Generation(generation)
+	attrs, err := o.Attrs(ctx)
+	if err == storage.ErrObjectNotExist {
+		return nil, nil, fmt.Errorf("object(%s) generation %d cannot be found: %w", fullPath, generation, models.ErrNotFound)
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("object.Attrs: %w", wrapGCSError(ctx, err))
+	}
+	rc, err := g.keyed(o).NewReader(ctx)
+	if err == storage.ErrObjectNotExist {
+		return nil, nil, fmt.Errorf("object(%s) generation %d cannot be found: %w", fullPath, generation, models.ErrNotFound)
+	}
+	if err != nil {
+		return nil, nil, readError(ctx, fullPath, err)
+	}
+	defer rc.Close()
+	r, err := g.decodeReader(g.bufferReader(rc))
+	if err != nil {
+		return nil, nil, fmt.Errorf("object(%s) cannot be decompressed: %v", fullPath, err)
+	}
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, nil, fmt.Errorf("io.ReadAll failure: %v", err)
+	}
+	return data, g.parseMetaData(attrs), nil
+}
+
+// ListOptions tunes ListWithOptions.
+type ListOptions struct {
+	// Versions includes noncurrent generations. Every generation is then its own entry, keyed by
+	// the relative path, '#' and the generation (gsutil's notation), e.g. "logs/a.txt#1700000000".
+	Versions bool
+}
+
+// ListWithOptions is List with per-call options; opts may be nil.
+func (gcp *GCPController) ListWithOptions(g *GCPFS, prefix string, opts *ListOptions) (map[string]*models.FileMetaData, error) {
+	if opts == nil || !opts.Versions {
+		return gcp.List(g, prefix)
+	}
+	defer g.startOp()()
+	fullPrefix, err := g.listPrefix(prefix)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := g.opContext(g.config.ListTimeout)
+	defer cancel()
+
+	results := make(map[string]*models.FileMetaData)
+	err = g.listQuery(ctx, &storage.Query{Prefix: fullPrefix, Versions: true}, func(attrs *storage.ObjectAttrs) {
+		results[g.relativeName(attrs.Name)+"#"+strconv.FormatInt(attrs.Generation, 10)] = g.parseMetaData(attrs)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Bucket(%s).Objects: %w", g.config.BucketName, wrapGCSError(ctx, err))
+	}
+	return results, nil
+}
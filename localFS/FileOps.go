@@ -1,14 +1,360 @@
 package localFS
 
 import (
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io/fs"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
 	ninjaStorage "github.com/ninjamarcus/ninjaStorage/Interfaces"
 	"github.com/ninjamarcus/ninjaStorage/models"
 )
 
+// metaDir holds the JSON sidecar of every file, mirroring the file tree, directly below the root.
+// It is hidden from List and cannot be written to.
+const metaDir = ".ninjaStorage-meta"
+
+// LocalFS stores files in a directory on disk, ParentFolder, with the same behaviour as the GCS
+// backend, so applications and tests can run without credentials. Each file's metadata is kept in
+// a JSON sidecar under metaDir.
 type LocalFS struct {
-	ninjaStorage.FileOperations
+	root string
 }
 
+var _ ninjaStorage.Storage = (*LocalFS)(nil)
+
 func NewLocalStorage(fs *models.LOCALFSConfig) (*LocalFS, error) {
-	return &LocalFS{}, nil
+	if err := fs.Validate(); err != nil {
+		return &LocalFS{}, err
+	}
+	root, err := filepath.Abs(fs.ParentFolder)
+	if err != nil {
+		return &LocalFS{}, err
+	}
+	if err := os.MkdirAll(root, 0o755); err != nil {
+		return &LocalFS{}, fmt.Errorf("cannot create root folder %s: %v", root, err)
+	}
+	return &LocalFS{root: root}, nil
+}
+
+// resolve returns the on-disk paths of filePath and its sidecar. Paths are always relative to the
+// root: absolute paths, ".." segments and the metadata folder are rejected.
+func (l *LocalFS) resolve(filePath string) (file, meta string, err error) {
+	if filePath == "" {
+		return "", "", fmt.Errorf("Filepath cannot be empty: %w", models.ErrInvalidPath)
+	}
+	if path.IsAbs(filePath) || strings.Contains(filePath, `\`) {
+		return "", "", fmt.Errorf("file path %q must be relative and use forward slashes: %w", filePath, models.ErrInvalidPath)
+	}
+	for _, segment := range strings.Split(filePath, "/") {
+		if segment == ".." {
+			return "", "", fmt.Errorf("file path %q cannot contain ..: %w", filePath, models.ErrInvalidPath)
+		}
+	}
+	clean := path.Clean(filePath)
+	if clean == "." || clean == metaDir || strings.HasPrefix(clean, metaDir+"/") {
+		return "", "", fmt.Errorf("file path %q is reserved: %w", filePath, models.ErrInvalidPath)
+	}
+	return filepath.Join(l.root, filepath.FromSlash(clean)), filepath.Join(l.root, metaDir, filepath.FromSlash(clean)+".json"), nil
+}
+
+func (l *LocalFS) Write(data []byte, filePath string, metaData *models.FileMetaData) (*models.FileMetaData, error) {
+	if len(data) == 0 {
+		return nil, fmt.Errorf("length of data is 0 nothing to write")
+	}
+	file, meta, err := l.resolve(filePath)
+	if err != nil {
+		return nil, err
+	}
+	stored := &models.FileMetaData{TimeCreated: time.Now().UTC()}
+	if metaData != nil {
+		stored.ContentType = metaData.ContentType
+		stored.ContentEncoding = metaData.ContentEncoding
+		stored.ContentDisposition = metaData.ContentDisposition
+		stored.CustomTime = metaData.CustomTime
+		stored.UserMetaData = metaData.UserMetaData
+	}
+	if stored.ContentType == "" {
+		stored.ContentType = detectContentType(filePath, data)
+	}
+	sum := md5.Sum(data)
+	stored.Md5Hash = hex.EncodeToString(sum[:])
+	stored.Crc32c = fmt.Sprintf("%08x", crc32.Checksum(data, crc32.MakeTable(crc32.Castagnoli)))
+	if metaData != nil && metaData.Md5Hash != "" && metaData.Md5Hash != stored.Md5Hash {
+		return nil, fmt.Errorf("file(%s) does not match the supplied checksum: %w", filePath, models.ErrChecksumMismatch)
+	}
+	if metaData != nil && metaData.Crc32c != "" && metaData.Crc32c != stored.Crc32c {
+		return nil, fmt.Errorf("file(%s) does not match the supplied checksum: %w", filePath, models.ErrChecksumMismatch)
+	}
+	sidecar, err := json.Marshal(stored)
+	if err != nil {
+		return nil, fmt.Errorf("cannot encode metadata: %v", err)
+	}
+	// The old sidecar is set aside while the data is replaced, so the file is never paired with
+	// another version's checksums, only briefly without any metadata. It is put back if the data
+	// cannot be written.
+	old, err := os.ReadFile(meta)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("cannot read metadata of file:%s reason: %v", filePath, err)
+	}
+	if err := os.Remove(meta); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("cannot replace metadata of file:%s reason: %v", filePath, err)
+	}
+	if err := writeFileAtomic(file, data); err != nil {
+		if old != nil {
+			if rerr := writeFileAtomic(meta, old); rerr != nil {
+				return nil, fmt.Errorf("%v (and its previous metadata could not be restored: %v)", err, rerr)
+			}
+		}
+		return nil, err
+	}
+	if err := writeFileAtomic(meta, sidecar); err != nil {
+		return nil, err
+	}
+	return l.Stat(filePath)
+}
+
+func (l *LocalFS) Read(filePath string) ([]byte, *models.FileMetaData, error) {
+	file, _, err := l.resolve(filePath)
+	if err != nil {
+		return nil, nil, err
+	}
+	data, err := os.ReadFile(file)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil, fmt.Errorf("file(%s) cannot be read: %w", filePath, models.ErrNotFound)
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("file(%s) cannot be read: %v", filePath, err)
+	}
+	mdata, err := l.Stat(filePath)
+	if err != nil {
+		return nil, nil, err
+	}
+	return data, mdata, nil
+}
+
+func (l *LocalFS) Stat(filePath string) (*models.FileMetaData, error) {
+	file, meta, err := l.resolve(filePath)
+	if err != nil {
+		return nil, err
+	}
+	return l.stat(path.Clean(filePath), file, meta)
+}
+
+func (l *LocalFS) stat(name, file, meta string) (*models.FileMetaData, error) {
+	info, err := os.Stat(file)
+	if errors.Is(err, fs.ErrNotExist) || (err == nil && info.IsDir()) {
+		return nil, fmt.Errorf("file(%s) cannot be found: %w", name, models.ErrNotFound)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("file(%s) cannot be found: %v", name, err)
+	}
+	mdata := &models.FileMetaData{}
+	if sidecar, err := os.ReadFile(meta); err == nil {
+		if err := json.Unmarshal(sidecar, mdata); err != nil {
+			return nil, fmt.Errorf("file(%s) has unreadable metadata: %v", name, err)
+		}
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("file(%s) metadata cannot be read: %v", name, err)
+	}
+	mdata.Name = name
+	mdata.Size = info.Size()
+	mdata.Updated = info.ModTime().UTC()
+	if mdata.TimeCreated.IsZero() {
+		mdata.TimeCreated = mdata.Updated
+	}
+	return mdata, nil
+}
+
+func (l *LocalFS) Exists(filePath string) (bool, error) {
+	_, err := l.Stat(filePath)
+	if errors.Is(err, models.ErrNotFound) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (l *LocalFS) Delete(filePath string) error {
+	file, meta, err := l.resolve(filePath)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(file); errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("cannot delete file:%s reason: %w", filePath, models.ErrNotFound)
+	} else if err != nil {
+		return fmt.Errorf("cannot delete file:%s reason: %v", filePath, err)
+	}
+	if err := os.Remove(meta); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("cannot delete metadata of file:%s reason: %v", filePath, err)
+	}
+	return nil
+}
+
+// Copy copies filePathFrom, content and metadata, to filePathTo, which must not exist yet.
+func (l *LocalFS) Copy(filePathFrom string, filePathTo string) error {
+	fromFile, fromMeta, toFile, toMeta, err := l.resolvePair(filePathFrom, filePathTo)
+	if err != nil {
+		return err
+	}
+	data, err := os.ReadFile(fromFile)
+	if errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("cannot copy file:%s reason: %w", filePathFrom, models.ErrNotFound)
+	}
+	if err != nil {
+		return fmt.Errorf("cannot copy file:%s reason: %v", filePathFrom, err)
+	}
+	sidecar, err := os.ReadFile(fromMeta)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("cannot copy metadata of file:%s reason: %v", filePathFrom, err)
+	}
+	if sidecar != nil {
+		if err := writeFileAtomic(toMeta, sidecar); err != nil {
+			return err
+		}
+	}
+	return writeFileAtomic(toFile, data)
+}
+
+// Move renames filePathFrom, with its metadata, to filePathTo, which must not exist yet.
+func (l *LocalFS) Move(filePathFrom string, filePathTo string) error {
+	fromFile, fromMeta, toFile, toMeta, err := l.resolvePair(filePathFrom, filePathTo)
+	if err != nil {
+		return err
+	}
+	if _, err := os.Stat(fromFile); errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("cannot move file:%s reason: %w", filePathFrom, models.ErrNotFound)
+	}
+	if err := os.MkdirAll(filepath.Dir(toFile), 0o755); err != nil {
+		return fmt.Errorf("cannot move file:%s reason: %v", filePathFrom, err)
+	}
+	if err := os.Rename(fromFile, toFile); err != nil {
+		return fmt.Errorf("cannot move file:%s reason: %v", filePathFrom, err)
+	}
+	// The file moves first and is moved back if its sidecar cannot follow, so the metadata never
+	// ends up apart from it.
+	err = os.MkdirAll(filepath.Dir(toMeta), 0o755)
+	if err == nil {
+		err = os.Rename(fromMeta, toMeta)
+	}
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		if rerr := os.Rename(toFile, fromFile); rerr != nil {
+			return fmt.Errorf("cannot move metadata of file:%s reason: %v (and the file could not be moved back from %s: %v)", filePathFrom, err, filePathTo, rerr)
+		}
+		return fmt.Errorf("cannot move metadata of file:%s reason: %v", filePathFrom, err)
+	}
+	return nil
+}
+
+// resolvePair resolves the two paths of a copy or move and checks the destination is free.
+func (l *LocalFS) resolvePair(filePathFrom, filePathTo string) (fromFile, fromMeta, toFile, toMeta string, err error) {
+	if fromFile, fromMeta, err = l.resolve(filePathFrom); err != nil {
+		return
+	}
+	if toFile, toMeta, err = l.resolve(filePathTo); err != nil {
+		return
+	}
+	if fromFile == toFile {
+		err = fmt.Errorf("the filePathFrom: %s, cannot be the same as filePathTo: %s", filePathFrom, filePathTo)
+		return
+	}
+	if _, statErr := os.Stat(toFile); statErr == nil {
+		err = fmt.Errorf("cannot write to file:%s reason: %w", filePathTo, models.ErrAlreadyExists)
+	}
+	return
+}
+
+// List returns every file whose path starts with prefix, keyed by that path. As with GCS the prefix
+// is a plain string match, so "logs" also matches "logsarchive/"; end it with a slash to list a
+// folder.
+func (l *LocalFS) List(prefix string) (map[string]*models.FileMetaData, error) {
+	if prefix != "" {
+		if _, _, err := l.resolve(prefix); err != nil {
+			return nil, err
+		}
+		clean := path.Clean(prefix)
+		if strings.HasSuffix(prefix, "/") {
+			clean += "/"
+		}
+		prefix = clean
+	}
+	results := make(map[string]*models.FileMetaData)
+	err := filepath.WalkDir(l.root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(l.root, p)
+		if err != nil {
+			return err
+		}
+		name := filepath.ToSlash(rel)
+		if d.IsDir() {
+			if name == metaDir {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !strings.HasPrefix(name, prefix) || strings.HasPrefix(d.Name(), tmpPrefix) {
+			return nil
+		}
+		mdata, err := l.stat(name, p, filepath.Join(l.root, metaDir, rel+".json"))
+		if errors.Is(err, models.ErrNotFound) {
+			// Deleted while walking.
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		results[name] = mdata
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("cannot list %s: %v", l.root, err)
+	}
+	return results, nil
+}
+
+// tmpPrefix marks the temporary files writes are staged in, which List skips.
+const tmpPrefix = ".ninjaStorage-tmp-"
+
+// writeFileAtomic writes data to a temporary file next to name and renames it into place, so readers
+// never see a partially written file.
+func writeFileAtomic(name string, data []byte) error {
+	dir := filepath.Dir(name)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return fmt.Errorf("cannot create folder %s: %v", dir, err)
+	}
+	tmp, err := os.CreateTemp(dir, tmpPrefix+"*")
+	if err != nil {
+		return fmt.Errorf("cannot create temporary file: %v", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("cannot write %s: %v", name, err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("cannot write %s: %v", name, err)
+	}
+	if err := os.Rename(tmp.Name(), name); err != nil {
+		return fmt.Errorf("cannot write %s: %v", name, err)
+	}
+	return nil
+}
+
+// detectContentType picks a content type from the file extension, falling back to sniffing data.
+func detectContentType(filePath string, data []byte) string {
+	if t := mime.TypeByExtension(path.Ext(filePath)); t != "" {
+		return t
+	}
+	return http.DetectContentType(data)
 }
@@ -1 +1,139 @@
 package localFS
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	ninjaStorage "github.com/ninjamarcus/ninjaStorage/Interfaces"
+	"github.com/ninjamarcus/ninjaStorage/Interfaces/storagetest"
+	"github.com/ninjamarcus/ninjaStorage/models"
+)
+
+func newTestLocalFS(t *testing.T) *LocalFS {
+	t.Helper()
+	l, err := NewLocalStorage(&models.LOCALFSConfig{FS: &models.FS{ParentFolder: t.TempDir()}})
+	if err != nil {
+		t.Fatalf("NewLocalStorage: %v", err)
+	}
+	return l
+}
+
+func TestStorageInterface(t *testing.T) {
+	storagetest.Run(t, func(t *testing.T) ninjaStorage.Storage {
+		return newTestLocalFS(t)
+	})
+}
+
+func TestMetadataSidecar(t *testing.T) {
+	l := newTestLocalFS(t)
+	meta := map[string]string{"owner": "ann", "team": "dev"}
+	if _, err := l.Write([]byte("{}"), "docs/a.json", &models.FileMetaData{UserMetaData: meta}); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+
+	raw, err := os.ReadFile(filepath.Join(l.root, metaDir, "docs", "a.json.json"))
+	if err != nil {
+		t.Fatalf("the sidecar should be written: %v", err)
+	}
+	var stored models.FileMetaData
+	if err := json.Unmarshal(raw, &stored); err != nil {
+		t.Fatalf("sidecar is not JSON: %v", err)
+	}
+	if stored.UserMetaData["owner"] != "ann" || stored.ContentType != "application/json" || stored.Md5Hash != "99914b932bd37a50b983c5e7c90ae93b" {
+		t.Errorf("sidecar: %+v", stored)
+	}
+
+	// A fresh LocalFS on the same folder reads it back.
+	reopened, err := NewLocalStorage(&models.LOCALFSConfig{FS: &models.FS{ParentFolder: l.root}})
+	if err != nil {
+		t.Fatalf("NewLocalStorage: %v", err)
+	}
+	_, mdata, err := reopened.Read("docs/a.json")
+	if err != nil {
+		t.Fatalf("Read: %v", err)
+	}
+	if len(mdata.UserMetaData) != 2 || mdata.UserMetaData["team"] != "dev" {
+		t.Errorf("metadata did not round-trip: %v", mdata.UserMetaData)
+	}
+	list, err := reopened.List("")
+	if err != nil {
+		t.Fatalf("List: %v", err)
+	}
+	if len(list) != 1 {
+		t.Errorf("List should not show sidecars: %v", list)
+	}
+}
+
+func TestFailedWriteAndMoveKeepMetadataWithData(t *testing.T) {
+	l := newTestLocalFS(t)
+	if _, err := l.Write([]byte("x"), "dir/child.txt", nil); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	// "dir" is a folder, so the data cannot be written and its metadata must not be left behind.
+	if _, err := l.Write([]byte("x"), "dir", &models.FileMetaData{UserMetaData: map[string]string{"k": "v"}}); err == nil {
+		t.Fatalf("Write over a folder should fail")
+	}
+	if _, err := os.Stat(filepath.Join(l.root, metaDir, "dir.json")); err == nil {
+		t.Errorf("a failed Write left its sidecar behind")
+	}
+
+	if _, err := l.Write([]byte("body"), "src.txt", &models.FileMetaData{UserMetaData: map[string]string{"owner": "ann"}}); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	check := func(op string) {
+		t.Helper()
+		mdata, err := l.Stat("src.txt")
+		if err != nil || mdata.UserMetaData["owner"] != "ann" {
+			t.Errorf("%s: the source should keep its metadata: %+v, %v", op, mdata, err)
+		}
+	}
+	// The file cannot be moved under a regular file.
+	if _, err := l.Write([]byte("x"), "blocked", nil); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	if err := l.Move("src.txt", "blocked/dst.txt"); err == nil {
+		t.Errorf("Move under a file should fail")
+	}
+	check("Move failing on the file")
+	// The file can be moved but its sidecar cannot follow.
+	if err := os.WriteFile(filepath.Join(l.root, metaDir, "meta-blocked"), nil, 0o644); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	if err := l.Move("src.txt", "meta-blocked/dst.txt"); err == nil {
+		t.Errorf("Move with a blocked sidecar should fail")
+	}
+	check("Move failing on the sidecar")
+	if _, err := os.Stat(filepath.Join(l.root, "meta-blocked", "dst.txt")); err == nil {
+		t.Errorf("the file was left at the destination without its metadata")
+	}
+}
+
+func TestPathsAreSandboxed(t *testing.T) {
+	l := newTestLocalFS(t)
+	outside := filepath.Join(filepath.Dir(l.root), "escaped.txt")
+	for _, p := range []string{"../escaped.txt", "a/../../escaped.txt", "/etc/passwd", metaDir + "/x.json", ""} {
+		if _, err := l.Write([]byte("x"), p, nil); err == nil {
+			t.Errorf("Write(%q) should be rejected", p)
+		}
+		if _, _, err := l.Read(p); err == nil {
+			t.Errorf("Read(%q) should be rejected", p)
+		}
+	}
+	if err := l.Copy("a.txt", "../escaped.txt"); err == nil {
+		t.Errorf("Copy outside the root should be rejected")
+	}
+	if _, err := os.Stat(outside); err == nil {
+		t.Errorf("a file was written outside the root")
+	}
+	if _, err := l.List("../"); err == nil {
+		t.Errorf("List outside the root should be rejected")
+	}
+}
+
+func TestNewLocalStorageNeedsParentFolder(t *testing.T) {
+	if _, err := NewLocalStorage(&models.LOCALFSConfig{FS: &models.FS{}}); err == nil {
+		t.Errorf("an empty ParentFolder should be rejected")
+	}
+}
@@ -0,0 +1,8 @@
+package models
+
+// BucketInfo describes a bucket returned by ListBuckets.
+type BucketInfo struct {
+	Name         string `json:"name,omitempty"`
+	Location     string `json:"location,omitempty"`
+	StorageClass string `json:"storage_class,omitempty"`
+}
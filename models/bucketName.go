@@ -0,0 +1,28 @@
+package models
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+var bucketNamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9._-]*[a-z0-9]$`)
+
+// ValidateBucketName checks v against the GCS bucket naming rules: 3 to 63 characters (222 when the
+// name has dots, with no dot-separated part over 63), only lowercase letters, digits, dashes,
+// underscores and dots, starting and ending with a letter or digit.
+func ValidateBucketName(v string) error {
+	max := 63
+	if strings.Contains(v, ".") {
+		max = 222
+	}
+	if len(v) < 3 || len(v) > max || !bucketNamePattern.MatchString(v) {
+		return fmt.Errorf("invalid bucket name %q", v)
+	}
+	for _, part := range strings.Split(v, ".") {
+		if len(part) > 63 {
+			return fmt.Errorf("invalid bucket name %q: %q is longer than 63 characters", v, part)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,22 @@
+package models
+
+import (
+	"fmt"
+	"mime"
+)
+
+// ValidateContentDisposition checks that v is a well formed Content-Disposition value such as
+// `attachment` or `attachment; filename="report.pdf"`. The empty string is valid and means none.
+func ValidateContentDisposition(v string) error {
+	if v == "" {
+		return nil
+	}
+	disposition, _, err := mime.ParseMediaType(v)
+	if err != nil {
+		return fmt.Errorf("invalid content disposition %q: %v", v, err)
+	}
+	if disposition != "inline" && disposition != "attachment" {
+		return fmt.Errorf("invalid content disposition %q: type must be inline or attachment", v)
+	}
+	return nil
+}
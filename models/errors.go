@@ -0,0 +1,32 @@
+package models
+
+import "errors"
+
+// Sentinel errors returned by the storage backends. They are always wrapped with more context,
+// so compare them with errors.Is rather than ==.
+var (
+	// ErrNotFound the object does not exist.
+	ErrNotFound = errors.New("object not found")
+	// ErrAlreadyExists the object exists and the operation would have overwritten it.
+	ErrAlreadyExists = errors.New("object already exists")
+	// ErrPermissionDenied the caller's credentials do not allow the operation.
+	ErrPermissionDenied = errors.New("permission denied")
+	// ErrPreconditionFailed the object changed between reading it and acting on it.
+	ErrPreconditionFailed = errors.New("precondition failed")
+	// ErrContentTypeNotAllowed the content type is not permitted under the target prefix.
+	ErrContentTypeNotAllowed = errors.New("content type not allowed")
+	// ErrFenced a fenced write was rejected because the object is no longer at the expected generation.
+	ErrFenced = errors.New("write fenced by a newer generation")
+	// ErrMetadataInvalid the user metadata does not satisfy the configured MetadataSchema.
+	ErrMetadataInvalid = errors.New("metadata invalid")
+	// ErrChecksumMismatch the stored content does not match the checksum it was verified against.
+	ErrChecksumMismatch = errors.New("checksum mismatch")
+	// ErrObjectTooLarge the content exceeds the size allowed for the write.
+	ErrObjectTooLarge = errors.New("object too large")
+	// ErrCaseCollision an object whose key differs only by case already exists.
+	ErrCaseCollision = errors.New("object key collides by case")
+	// ErrObjectHeld the object is under a hold or retention period and cannot be deleted yet.
+	ErrObjectHeld = errors.New("object is held")
+	// ErrInvalidPath the object path or prefix is empty, absolute or would escape the root folder.
+	ErrInvalidPath = errors.New("invalid object path")
+)
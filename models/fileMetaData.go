@@ -5,11 +5,52 @@ import "time"
 // TODO: for local ninjaStorage store the metadata in its own folder so we can search it super quickly ish.
 
 type FileMetaData struct {
-	Bucket       string            `json:"bucket,omitempty"`
-	Md5Hash      string            `json:"md_5_hash,omitempty"`
-	UserMetaData map[string]string `json:"user_meta_data, omitempty"`
+	Bucket string `json:"bucket,omitempty"`
+	// Md5Hash is the hex MD5 of the content. GCS only keeps one for objects uploaded in one piece
+	// (simple, multipart and resumable uploads alike); composite objects have none, use Crc32c.
+	// Set it on a write to have GCS reject the upload, with ErrChecksumMismatch, unless the stored
+	// content matches.
+	Md5Hash string `json:"md_5_hash,omitempty"`
+	// Crc32c is the hex CRC32C (Castagnoli) of the content, present for every object. Like Md5Hash
+	// it is verified by GCS when set on a write.
+	Crc32c       string            `json:"crc_32c,omitempty"`
+	ContentType  string            `json:"content_type,omitempty"`
+	UserMetaData map[string]string `json:"user_meta_data,omitempty"`
 	Name         string            `json:"name,omitempty"`
 	Size         int64             `json:"size,omitempty"`
 	TimeCreated  time.Time         `json:"time_created,omitempty"`
 	Updated      time.Time         `json:"updated,omitempty"`
+	// Generation identifies the object's content, it changes every time the object is overwritten.
+	Generation int64 `json:"generation,omitempty"`
+	// Metageneration counts metadata updates within a generation.
+	Metageneration int64 `json:"metageneration,omitempty"`
+	// ContentEncoding is the stored encoding, e.g. "gzip" for objects GCS transcodes on download.
+	// Set it on a write when uploading already compressed data.
+	ContentEncoding string `json:"content_encoding,omitempty"`
+	// ContentDisposition controls how browsers present the object, e.g. `attachment; filename="a.pdf"`.
+	// Empty on a write falls back to GCPFSConfig.DefaultContentDisposition.
+	ContentDisposition string `json:"content_disposition,omitempty"`
+	// CacheControl is served as the object's Cache-Control header, e.g. "public, max-age=3600" to
+	// let a CDN cache it. Empty on a write leaves GCS's default.
+	CacheControl string `json:"cache_control,omitempty"`
+	// StorageClass is the object's storage tier, e.g. "STANDARD" or "COLDLINE". Set it on a write,
+	// in any case, to override GCPFSConfig.DefaultStorageClass.
+	StorageClass string `json:"storage_class,omitempty"`
+	// KMSKeyName is the Cloud KMS key the object is encrypted with. GCS reports the key version in
+	// use, i.e. the key name followed by /cryptoKeyVersions/<n>. Set it on a write to override
+	// GCPFSConfig.KMSKeyName.
+	KMSKeyName string `json:"kms_key_name,omitempty"`
+	// PredefinedACL is applied on writes: authenticatedRead, bucketOwnerFullControl,
+	// bucketOwnerRead, private, projectPrivate or publicRead. It is not read back.
+	PredefinedACL string `json:"predefined_acl,omitempty"`
+	// TemporaryHold and EventBasedHold report the object's holds, set with SetHold and
+	// SetEventBasedHold; either one keeps the object from being deleted or overwritten.
+	TemporaryHold  bool `json:"temporary_hold,omitempty"`
+	EventBasedHold bool `json:"event_based_hold,omitempty"`
+	// CustomTime is an application timestamp lifecycle rules can expire the object by, e.g. delete
+	// 30 days after it (daysSinceCustomTime). GCS keeps it to the second, and once set it can only be
+	// moved later, never cleared. Zero on a write leaves it unset.
+	CustomTime time.Time `json:"custom_time,omitempty"`
+	// Compressed is set on reads when the returned bytes are still encoded with ContentEncoding.
+	Compressed bool `json:"compressed,omitempty"`
 }
@@ -1,12 +1,172 @@
 package models
 
-import "errors"
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"path"
+	"regexp"
+	"strings"
+	"time"
+)
 
-// For Authentication you need to set your environment variable GOOGLE_APPLICATION_CREDENTIALS
+// NoTimeout disables a GCPFSConfig timeout, leaving operations bounded only by their context.
+const NoTimeout time.Duration = -1
+
+// Defaults Validate applies to timeouts left at zero.
+const (
+	DefaultReadTimeout     = 50 * time.Second
+	DefaultWriteTimeout    = 50 * time.Second
+	DefaultListTimeout     = time.Minute
+	DefaultMetadataTimeout = 10 * time.Second
+)
+
+// DefaultTrashPrefix is the trash folder used when GCPFSConfig.TrashPrefix is empty.
+const DefaultTrashPrefix = ".trash/"
+
+// UploadChunkSizeMultiple is the granularity GCS requires of GCPFSConfig.UploadChunkSize.
+const UploadChunkSizeMultiple = 256 << 10
+
+// projectIDPattern matches GCP project IDs, including legacy domain-scoped ones (example.com:proj).
+var projectIDPattern = regexp.MustCompile(`^([a-z0-9.-]+:)?[a-z][a-z0-9-]{4,28}[a-z0-9]$`)
+
+// Signing schemes for GCPFSConfig.SigningScheme.
+const (
+	SigningSchemeV4 = "v4"
+	SigningSchemeV2 = "v2"
+)
+
+// For Authentication you need to set your environment variable GOOGLE_APPLICATION_CREDENTIALS,
+// or set CredentialsJSON or CredentialsFile.
 type GCPFSConfig struct {
 	BucketName string
 	//I might not need project ID
 	ProjectID string
+	// CredentialsJSON is a service account key (or other credentials file) held in memory, e.g.
+	// fetched from a secret store, used instead of application default credentials. CredentialsFile
+	// is the path of one on disk. At most one may be set; with neither, NewGCPStorage falls back to
+	// GOOGLE_APPLICATION_CREDENTIALS and the other application default credentials. A service
+	// account key passed either way is also used for signing when SigningPrivateKey is unset.
+	CredentialsJSON []byte
+	CredentialsFile string
+	// AutoCreateBucket makes NewGCPStorage create BucketName in ProjectID, in BucketLocation (empty
+	// is the US multi-region), when it does not exist yet. Meant for emulators and CI; leave it off
+	// in production so a typo cannot create a stray bucket. Requires ProjectID.
+	AutoCreateBucket bool
+	BucketLocation   string
+	// UserProject is the project requests are billed to, needed to access requester-pays buckets;
+	// the credentials need serviceusage.services.use on it. It applies to every bucket accessed,
+	// the mirror and copy destinations included. Empty bills the bucket's own project.
+	UserProject string
+	// AllowedContentTypes optionally restricts the content types that can be written under a prefix
+	// (relative to ParentFolder), e.g. {"images/": {"image/*"}}. The longest matching prefix wins.
+	AllowedContentTypes map[string][]string
+	// DetectGzip makes reads sniff for the gzip magic bytes and transparently decompress objects that
+	// were stored gzipped without a Content-Encoding header. Off by default so reads return raw bytes.
+	DetectGzip bool
+	// MetadataSchema, when set, is checked against the user metadata of every write before upload.
+	MetadataSchema MetadataSchema
+	// CleanupFolderPlaceholders makes deletes also remove a "folder/" placeholder object once the last
+	// object under it is gone. Costs an extra list per folder level, so it is off by default.
+	CleanupFolderPlaceholders bool
+	// MirrorBucketName is an optional copy of BucketName that Read and Stat fall back to when the
+	// primary returns not-found or a server error. Writes only ever go to BucketName; keeping the
+	// mirror in sync is up to the caller (e.g. a transfer job), so a fallback read may return an
+	// older version of an object, or one already deleted from the primary.
+	MirrorBucketName string
+	// MaxRetries enables retrying of reads (Read, Stat), List, Copy and Delete: a failed call is
+	// re-issued up to MaxRetries more times while ShouldRetry accepts the error. Uploads are retried
+	// by the SDK with the same limit, backoff and classifier, buffering each chunk so it can be resent
+	// rather than restarting a partial upload. Zero leaves retries to the SDK.
+	MaxRetries int
+	// RetryBaseDelay is the backoff before the first retry, doubling on each attempt (up to 30s)
+	// with jitter. Defaults to 100ms.
+	RetryBaseDelay time.Duration
+	// ShouldRetry decides whether an error is worth retrying; attempt counts the failures so far,
+	// starting at 1. Nil uses gcpFS.DefaultShouldRetry (429, 5xx and transient network errors).
+	ShouldRetry func(err error, attempt int) bool
+	// ExistsCacheTTL caches Exists answers, found or not, for this long. Writes and deletes made
+	// through the same GCPFS invalidate their path immediately, but changes made elsewhere may go
+	// unnoticed for up to the TTL, so keep it short. Zero disables the cache.
+	ExistsCacheTTL time.Duration
+	// Provenance lists the fields (host, pid, time) stamped into the custom metadata of every
+	// uploaded object; metadata supplied with the write wins on conflict. Empty, the default,
+	// stamps nothing.
+	Provenance []ProvenanceField
+	// DefaultMetadata is merged into the custom metadata of every uploaded object, e.g. tenant, app
+	// and env for cost attribution; metadata supplied with the write wins on conflict.
+	DefaultMetadata map[string]string
+	// ReadBufferSize, when positive, wraps object readers in a bufio.Reader of this many bytes,
+	// which cuts per-read overhead for services reading many small objects. Zero keeps the
+	// SDK reader unbuffered.
+	ReadBufferSize int
+	// UploadChunkSize, in bytes and a multiple of 256 KiB, makes uploads resumable: the content is
+	// sent in chunks of this size, each buffered in memory until GCS acknowledges it, so a dropped
+	// connection only resends the current chunk. Zero sends each upload as a single request (or
+	// chunks of the SDK's 16 MiB default when MaxRetries is set).
+	UploadChunkSize int
+	// SigningAccessID and SigningPrivateKey (PEM) are the service account used to sign upload
+	// policies and URLs. When unset they are detected from GOOGLE_APPLICATION_CREDENTIALS, falling
+	// back to the IAM signBlob API for the runtime service account.
+	SigningAccessID   string
+	SigningPrivateKey []byte
+	// SigningScheme picks how signed URLs are signed: SigningSchemeV4, the default, or the legacy
+	// SigningSchemeV2. Upload policies are always V4.
+	SigningScheme string
+	// DefaultContentDisposition is set on every upload that does not carry its own
+	// FileMetaData.ContentDisposition, e.g. "attachment" so browsers download instead of render.
+	DefaultContentDisposition string
+	// LowercaseKeys lowercases object paths (below ParentFolder) for every operation, for serving
+	// layers that treat keys case-insensitively. Writes fail with ErrCaseCollision when a
+	// differently-cased object already exists at the normalized key. Read and Stat fall back to the
+	// path as given, so mixed-case objects written before enabling it can still be read.
+	LowercaseKeys bool
+	// TextDecoders adds charsets to ReadText, keyed by lowercase charset name (e.g. "shift_jis"),
+	// each converting raw content to a UTF-8 string. They take precedence over the built-in ones.
+	TextDecoders map[string]func(data []byte) (string, error)
+	// MaxOpsPerSecond caps how many writes, composes, deletes and copies (including those made by
+	// moves and DeletePrefix) start per second, spaced evenly, to stay under GCS's write quotas
+	// instead of retrying 429s. Waiting for a turn respects the GCPFS's context but not
+	// the operation timeouts. Zero is unlimited.
+	MaxOpsPerSecond float64
+	// Logger, when set, receives a key=value line for each retry and mirror failover, and with
+	// LogOperations for each operation, tagged with request_id for operations made through a GCPFS
+	// derived with gcpFS.WithRequestID. The ID is not sent to GCS, as the SDK has no per-call
+	// headers. Nil logs nothing.
+	Logger *log.Logger
+	// LogOperations additionally sends Logger an op_start and an op_done line for every read,
+	// write, stat, list, copy and delete: op, object, and on completion bytes transferred,
+	// duration and err. Off, or without a Logger, nothing is logged or timed.
+	LogOperations bool
+	// Metrics, when set, is told the duration and outcome of the same operations LogOperations
+	// logs, and the bytes read and written. Nil measures nothing.
+	Metrics Metrics
+	// DefaultStorageClass is the storage class, e.g. "NEARLINE", uploads and composed objects are
+	// written in unless FileMetaData.StorageClass names another. Empty uses the bucket's default.
+	DefaultStorageClass string
+	// KMSKeyName is the Cloud KMS key, as projects/*/locations/*/keyRings/*/cryptoKeys/*, that
+	// uploads and composed objects are encrypted with unless FileMetaData.KMSKeyName names another.
+	// GCS needs the bucket's service agent to hold roles/cloudkms.cryptoKeyEncrypterDecrypter on
+	// it. Empty uses the bucket's default encryption.
+	KMSKeyName string
+	// TrashPrefix is the folder below ParentFolder SoftDelete moves objects into, each under
+	// <TrashPrefix><deletion time>/<original path>. Empty uses DefaultTrashPrefix.
+	// It is an ordinary folder: List and the bulk operations see trashed objects like any other.
+	TrashPrefix string
+	// CDNBaseURL is the http(s) URL of a CDN fronting the bucket at ParentFolder, e.g.
+	// "https://cdn.example.com/assets". PublicURL appends the object path below ParentFolder to it.
+	CDNBaseURL string
+	// ReadTimeout bounds downloads of whole objects or ranges (Read, Tail, VerifyIntegrity, ...).
+	// WriteTimeout bounds uploads of byte slices and server-side copies and composes. ListTimeout
+	// bounds listings, including bulk scans such as ListParallel. MetadataTimeout bounds everything
+	// else: Stat, Exists, Delete, metadata updates and IAM calls. Validate replaces zero with the
+	// matching Default*Timeout; NoTimeout relies on the caller's context alone. Streaming reads and
+	// writes never have a fixed deadline.
+	ReadTimeout     time.Duration
+	WriteTimeout    time.Duration
+	ListTimeout     time.Duration
+	MetadataTimeout time.Duration
 	*FS
 }
 
@@ -17,9 +177,90 @@ func (g *GCPFSConfig) Validate() error {
 	if g.BucketName == "" {
 		return errors.New("BucketName has not been set")
 	}
+	if g.MirrorBucketName == g.BucketName {
+		return errors.New("MirrorBucketName cannot be the same as BucketName")
+	}
+	if g.UserProject != "" && !projectIDPattern.MatchString(g.UserProject) {
+		return fmt.Errorf("UserProject %q is not a valid project ID", g.UserProject)
+	}
+	if g.AutoCreateBucket && g.ProjectID == "" {
+		return errors.New("ProjectID has to be set for AutoCreateBucket")
+	}
+	if len(g.CredentialsJSON) > 0 && g.CredentialsFile != "" {
+		return errors.New("only one of CredentialsJSON and CredentialsFile can be set")
+	}
+
+	if err := ValidateContentDisposition(g.DefaultContentDisposition); err != nil {
+		return fmt.Errorf("DefaultContentDisposition: %v", err)
+	}
+	if err := ValidateStorageClass(g.DefaultStorageClass); err != nil {
+		return fmt.Errorf("DefaultStorageClass: %v", err)
+	}
+	if err := ValidateKMSKeyName(g.KMSKeyName); err != nil {
+		return fmt.Errorf("KMSKeyName: %v", err)
+	}
+	if g.CDNBaseURL != "" {
+		u, err := url.Parse(g.CDNBaseURL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || u.RawQuery != "" || u.Fragment != "" {
+			return fmt.Errorf("CDNBaseURL %q must be an http(s) URL without query or fragment", g.CDNBaseURL)
+		}
+	}
+	switch g.SigningScheme {
+	case "", SigningSchemeV4, SigningSchemeV2:
+	default:
+		return fmt.Errorf("SigningScheme must be %q or %q, got %q", SigningSchemeV4, SigningSchemeV2, g.SigningScheme)
+	}
+	if g.TrashPrefix != "" && (strings.HasPrefix(g.TrashPrefix, "/") || strings.HasPrefix(g.TrashPrefix, "../") || !strings.HasSuffix(g.TrashPrefix, "/") || path.Clean(g.TrashPrefix) != strings.TrimSuffix(g.TrashPrefix, "/")) {
+		return fmt.Errorf("TrashPrefix %q must be a clean relative folder ending in /", g.TrashPrefix)
+	}
+	if g.ReadBufferSize < 0 {
+		return errors.New("ReadBufferSize cannot be negative")
+	}
+	if g.UploadChunkSize < 0 || g.UploadChunkSize%UploadChunkSizeMultiple != 0 {
+		return fmt.Errorf("UploadChunkSize must be a non-negative multiple of %d, got %d", UploadChunkSizeMultiple, g.UploadChunkSize)
+	}
+	if g.MaxOpsPerSecond < 0 {
+		return errors.New("MaxOpsPerSecond cannot be negative")
+	}
+	if g.MaxRetries < 0 {
+		return errors.New("MaxRetries cannot be negative")
+	}
+
+	for _, t := range []struct {
+		timeout *time.Duration
+		def     time.Duration
+	}{
+		{&g.ReadTimeout, DefaultReadTimeout},
+		{&g.WriteTimeout, DefaultWriteTimeout},
+		{&g.ListTimeout, DefaultListTimeout},
+		{&g.MetadataTimeout, DefaultMetadataTimeout},
+	} {
+		if *t.timeout == 0 {
+			*t.timeout = t.def
+		}
+	}
+
+	for _, field := range g.Provenance {
+		if !field.Valid() {
+			return fmt.Errorf("Provenance has an unknown field %q", field)
+		}
+	}
+
+	for k := range g.DefaultMetadata {
+		if k == "" {
+			return fmt.Errorf("DefaultMetadata has an empty key")
+		}
+	}
 
 	if g.ProjectID == "" {
 		//return errors.New("ProjectID has not been set")
 	}
+	for prefix, types := range g.AllowedContentTypes {
+		for _, t := range types {
+			if parts := strings.Split(t, "/"); len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+				return fmt.Errorf("AllowedContentTypes[%q] has an invalid content type %q", prefix, t)
+			}
+		}
+	}
 	return nil
 }
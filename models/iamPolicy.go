@@ -0,0 +1,12 @@
+package models
+
+// Policy is a simplified view of a bucket's IAM policy.
+type Policy struct {
+	Bindings []PolicyBinding `json:"bindings,omitempty"`
+}
+
+// PolicyBinding grants a role to a set of members, e.g. "user:alice@example.com" or "allUsers".
+type PolicyBinding struct {
+	Role    string   `json:"role"`
+	Members []string `json:"members"`
+}
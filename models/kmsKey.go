@@ -0,0 +1,18 @@
+package models
+
+import (
+	"fmt"
+	"regexp"
+)
+
+var kmsKeyNamePattern = regexp.MustCompile(`^projects/[^/]+/locations/[^/]+/keyRings/[^/]+/cryptoKeys/[^/]+$`)
+
+// ValidateKMSKeyName checks that v is the resource name of a Cloud KMS key, i.e.
+// projects/<project>/locations/<location>/keyRings/<ring>/cryptoKeys/<key>. The empty string is
+// valid and means the bucket's default encryption.
+func ValidateKMSKeyName(v string) error {
+	if v != "" && !kmsKeyNamePattern.MatchString(v) {
+		return fmt.Errorf("invalid KMS key name %q: want projects/*/locations/*/keyRings/*/cryptoKeys/*", v)
+	}
+	return nil
+}
@@ -1,10 +1,16 @@
 package models
 
-// TODO: extra configuration details for the local fs stuff go in here
+import "errors"
+
+// LOCALFSConfig configures the local filesystem backend. ParentFolder is the directory files are
+// stored under; it is created if missing.
 type LOCALFSConfig struct {
 	*FS
 }
 
-func (l *LOCALFSConfig) Validate() {
-
+func (l *LOCALFSConfig) Validate() error {
+	if l.FS == nil || l.ParentFolder == "" {
+		return errors.New("ParentFolder has not been set")
+	}
+	return nil
 }
@@ -0,0 +1,71 @@
+package models
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// MetadataRule constrains a single user metadata key.
+type MetadataRule struct {
+	// Required rejects objects that do not carry the key.
+	Required bool
+	// Pattern, when set, must match the whole value.
+	Pattern *regexp.Regexp
+	// Allowed, when set, lists the only accepted values.
+	Allowed []string
+}
+
+// MetadataSchema maps user metadata keys to the rules their values must satisfy.
+// Keys not in the schema are accepted as is.
+type MetadataSchema map[string]MetadataRule
+
+// Validate checks meta against the schema, returning an error wrapping ErrMetadataInvalid that names
+// every missing or invalid key.
+func (s MetadataSchema) Validate(meta map[string]string) error {
+	var missing, invalid []string
+	for key, rule := range s {
+		value, ok := meta[key]
+		if !ok {
+			if rule.Required {
+				missing = append(missing, key)
+			}
+			continue
+		}
+		if !rule.accepts(value) {
+			invalid = append(invalid, key)
+		}
+	}
+	if len(missing) == 0 && len(invalid) == 0 {
+		return nil
+	}
+	sort.Strings(missing)
+	sort.Strings(invalid)
+	var problems []string
+	if len(missing) > 0 {
+		problems = append(problems, "missing keys: "+strings.Join(missing, ", "))
+	}
+	if len(invalid) > 0 {
+		problems = append(problems, "invalid keys: "+strings.Join(invalid, ", "))
+	}
+	return fmt.Errorf("%w: %s", ErrMetadataInvalid, strings.Join(problems, "; "))
+}
+
+func (r MetadataRule) accepts(value string) bool {
+	if r.Pattern != nil {
+		loc := r.Pattern.FindStringIndex(value)
+		if loc == nil || loc[0] != 0 || loc[1] != len(value) {
+			return false
+		}
+	}
+	if len(r.Allowed) > 0 {
+		for _, a := range r.Allowed {
+			if a == value {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
@@ -0,0 +1,43 @@
+package models
+
+import (
+	"errors"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func testSchema() MetadataSchema {
+	return MetadataSchema{
+		"tenant": {Required: true, Pattern: regexp.MustCompile(`[a-z]+`)},
+		"env":    {Required: true, Allowed: []string{"dev", "prod"}},
+		"owner":  {Pattern: regexp.MustCompile(`.+@example\.com`)},
+	}
+}
+
+func TestMetadataSchemaValid(t *testing.T) {
+	err := testSchema().Validate(map[string]string{"tenant": "acme", "env": "prod", "extra": "anything"})
+	if err != nil {
+		t.Fatalf("expected valid metadata, got %v", err)
+	}
+}
+
+func TestMetadataSchemaMissingKey(t *testing.T) {
+	err := testSchema().Validate(map[string]string{"tenant": "acme"})
+	if !errors.Is(err, ErrMetadataInvalid) {
+		t.Fatalf("expected ErrMetadataInvalid, got %v", err)
+	}
+	if !strings.Contains(err.Error(), "missing keys: env") {
+		t.Errorf("error should name the missing key: %v", err)
+	}
+}
+
+func TestMetadataSchemaInvalidValue(t *testing.T) {
+	err := testSchema().Validate(map[string]string{"tenant": "Acme1", "env": "staging", "owner": "bob@example.com"})
+	if !errors.Is(err, ErrMetadataInvalid) {
+		t.Fatalf("expected ErrMetadataInvalid, got %v", err)
+	}
+	if !strings.Contains(err.Error(), "invalid keys: env, tenant") {
+		t.Errorf("error should name the invalid keys: %v", err)
+	}
+}
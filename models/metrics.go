@@ -0,0 +1,12 @@
+package models
+
+import "time"
+
+// Metrics receives measurements of the operations a GCPFS makes, for export to e.g. Prometheus:
+// ObserveOp once per read, write, stat, list, copy and delete, with err nil on success, and
+// AddBytes with the content bytes each read and write moved. op is the same name LogOperations
+// logs ("read", "write", "write_stream", ...). Implementations are called concurrently.
+type Metrics interface {
+	ObserveOp(op string, bucket string, duration time.Duration, err error)
+	AddBytes(op string, bucket string, n int64)
+}
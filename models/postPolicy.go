@@ -0,0 +1,9 @@
+package models
+
+// PostPolicy is a signed policy for an HTML form POST upload straight to GCS. The form must be
+// posted to URL as multipart/form-data with every entry of Fields as a form field, followed by the
+// file itself in a field named "file".
+type PostPolicy struct {
+	URL    string            `json:"url"`
+	Fields map[string]string `json:"fields"`
+}
@@ -0,0 +1,23 @@
+package models
+
+import "fmt"
+
+// predefinedACLs are the canned object ACLs GCS accepts on uploads.
+var predefinedACLs = map[string]bool{
+	"authenticatedRead":      true,
+	"bucketOwnerFullControl": true,
+	"bucketOwnerRead":        true,
+	"private":                true,
+	"projectPrivate":         true,
+	"publicRead":             true,
+}
+
+// ValidatePredefinedACL checks that v is one of the predefined object ACLs GCS knows, e.g.
+// "publicRead" or "projectPrivate". The empty string is valid and leaves the bucket's default
+// object ACL in place.
+func ValidatePredefinedACL(v string) error {
+	if v != "" && !predefinedACLs[v] {
+		return fmt.Errorf("unknown predefined ACL %q: want authenticatedRead, bucketOwnerFullControl, bucketOwnerRead, private, projectPrivate or publicRead", v)
+	}
+	return nil
+}
@@ -0,0 +1,45 @@
+package models
+
+import (
+	"strconv"
+	"time"
+)
+
+// ProvenanceField is a piece of upload provenance that can be stamped onto written objects. Its
+// value is the custom metadata key it is stored under.
+type ProvenanceField string
+
+const (
+	// ProvenanceHost stamps the hostname of the uploading machine.
+	ProvenanceHost ProvenanceField = "uploaded-by-host"
+	// ProvenancePID stamps the process ID of the uploader.
+	ProvenancePID ProvenanceField = "uploaded-by-pid"
+	// ProvenanceTime stamps the upload time, RFC 3339 in UTC.
+	ProvenanceTime ProvenanceField = "uploaded-at"
+)
+
+// Valid reports whether p is one of the known provenance fields.
+func (p ProvenanceField) Valid() bool {
+	switch p {
+	case ProvenanceHost, ProvenancePID, ProvenanceTime:
+		return true
+	}
+	return false
+}
+
+// UploadHost returns the stamped ProvenanceHost, or "" when the object has none.
+func (f *FileMetaData) UploadHost() string {
+	return f.UserMetaData[string(ProvenanceHost)]
+}
+
+// UploadPID returns the stamped ProvenancePID, or 0 when the object has none.
+func (f *FileMetaData) UploadPID() int {
+	pid, _ := strconv.Atoi(f.UserMetaData[string(ProvenancePID)])
+	return pid
+}
+
+// UploadTime returns the stamped ProvenanceTime, or the zero time when the object has none.
+func (f *FileMetaData) UploadTime() time.Time {
+	t, _ := time.Parse(time.RFC3339, f.UserMetaData[string(ProvenanceTime)])
+	return t
+}
@@ -0,0 +1,23 @@
+package models
+
+import (
+	"fmt"
+	"strings"
+)
+
+// storageClasses are the storage classes GCS accepts for objects, including the legacy ones.
+var storageClasses = []string{"STANDARD", "NEARLINE", "COLDLINE", "ARCHIVE", "MULTI_REGIONAL", "REGIONAL", "DURABLE_REDUCED_AVAILABILITY"}
+
+// ValidateStorageClass checks that v names a GCS storage class, in any case, e.g. "COLDLINE" or
+// "Coldline". The empty string is valid and means the bucket's default class.
+func ValidateStorageClass(v string) error {
+	if v == "" {
+		return nil
+	}
+	for _, class := range storageClasses {
+		if strings.EqualFold(v, class) {
+			return nil
+		}
+	}
+	return fmt.Errorf("invalid storage class %q: want one of %s", v, strings.Join(storageClasses, ", "))
+}
@@ -1,6 +1,7 @@
 package ninjaStorage
 
 import (
+	"cloud.google.com/go/storage"
 	"github.com/ninjamarcus/ninjaStorage/gcpFS"
 	"github.com/ninjamarcus/ninjaStorage/localFS"
 	"github.com/ninjamarcus/ninjaStorage/models"
@@ -8,7 +9,13 @@ import (
 
 // NewStorageObj baseFolder is the folder with which all further writes will exist
 func NewStorageGCP(fs *models.GCPFSConfig) (*gcpFS.GCPFS, error) {
-	return gcpFS.NewGCPStorage(fs)
+	return new(gcpFS.GCPController).NewGCPStorage(fs)
+}
+
+// NewStorageGCPWithClient is NewStorageGCP using an existing client, see
+// gcpFS.GCPController.NewGCPStorageWithClient.
+func NewStorageGCPWithClient(fs *models.GCPFSConfig, client *storage.Client) (*gcpFS.GCPFS, error) {
+	return new(gcpFS.GCPController).NewGCPStorageWithClient(fs, client)
 }
 
 func NewStorageLocal(fs *models.LOCALFSConfig) (*localFS.LocalFS, error) {